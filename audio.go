@@ -0,0 +1,101 @@
+package gotube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ExtractAudio downloads an audio only stream and writes it to w as a standalone audio file without re-encoding.
+// AAC in mp4 (e.g. itag 140) is written as m4a, and Opus in webm (e.g. itag 251) as Ogg Opus.
+// AudioExtension returns an extension for the written file.
+func ExtractAudio(w io.Writer, audio *Stream) error {
+	return ExtractAudioContext(context.Background(), w, audio)
+}
+
+// ExtractAudioContext is ExtractAudio with a context.
+// The download is canceled when ctx is done or extraction fails.
+func ExtractAudioContext(ctx context.Context, w io.Writer, audio *Stream) error {
+	if audio.MediaType != "audio" {
+		return fmt.Errorf("%s is not an audio only stream", audio)
+	}
+	var extract func(io.Writer, io.Reader) error
+	switch audio.Format {
+	case "mp4":
+		extract = writeM4A
+	case "webm":
+		extract = writeOggOpus
+	default:
+		return fmt.Errorf("cannot extract audio from %s", audio.Format)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r := audio.pipe(ctx)
+	defer r.Close()
+
+	if err := extract(w, r); err != nil {
+		return err
+	}
+	logger.print("audio extraction completed")
+	return nil
+}
+
+// AudioExtension returns an extension of a file written by ExtractAudio for a stream, or "" if not supported
+func AudioExtension(audio *Stream) string {
+	switch audio.Format {
+	case "mp4":
+		return ".m4a"
+	case "webm":
+		if audio.AudioCodec == "opus" {
+			return ".opus"
+		}
+	}
+	return ""
+}
+
+// BestAudio returns an audio only stream with the highest bitrate among streams of a given format,
+// or among all streams if format is empty. It returns nil if not found.
+// The bitrate a stream reports is used, and the one of its itag only if the stream reports none.
+// Streams ExtractAudio can write, AAC and Opus, are preferred to the others such as Vorbis whatever their bitrates are.
+func BestAudio(streams []*Stream, format string) *Stream {
+	var best *Stream
+	bestBitrate, bestExtractable := -1, false
+	for _, s := range streams {
+		if s.MediaType != "audio" || (format != "" && s.Format != format) {
+			continue
+		}
+		bitrate := audioBitrate(s)
+		extractable := isExtractable(s)
+		if (extractable && !bestExtractable) || (extractable == bestExtractable && bitrate > bestBitrate) {
+			best, bestBitrate, bestExtractable = s, bitrate, extractable
+		}
+	}
+	return best
+}
+
+// audioBitrate returns bits per second of an audio stream,
+// taken from the itag table if the stream does not report it, or 0 if unknown
+func audioBitrate(audio *Stream) int {
+	if audio.bitrate > 0 {
+		return audio.bitrate
+	}
+	kbps, err := strconv.Atoi(strings.TrimSuffix(audio.Abr, "kbps"))
+	if err != nil {
+		return 0
+	}
+	return kbps * 1000
+}
+
+// isExtractable tells whether ExtractAudio can write the codec of an audio stream
+func isExtractable(audio *Stream) bool {
+	switch audio.Format {
+	case "mp4":
+		return strings.HasPrefix(audio.AudioCodec, "mp4a")
+	case "webm":
+		return audio.AudioCodec == "opus"
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package gotube
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestBestAudio(t *testing.T) {
+	streams := []*Stream{
+		{MediaType: "video", Format: "mp4", itag: 137},
+		{MediaType: "audio", Format: "mp4", Abr: "128kbps", itag: 140},
+		{MediaType: "audio", Format: "webm", Abr: "160kbps", itag: 251},
+		{MediaType: "audio", Format: "webm", Abr: "64kbps", itag: 250},
+	}
+	cases := []struct {
+		format string
+		itag   int
+	}{
+		{"", 251},
+		{"mp4", 140},
+		{"webm", 251},
+	}
+	for _, c := range cases {
+		if best := BestAudio(streams, c.format); best == nil || best.itag != c.itag {
+			t.Errorf("got %v for format %q, expected itag %d", best, c.format, c.itag)
+		}
+	}
+	if best := BestAudio(streams[:1], ""); best != nil {
+		t.Errorf("got %v, expected nil without audio streams", best)
+	}
+}
+
+func TestBestAudioReportedBitrate(t *testing.T) {
+	// bitrates streams report are preferred to the itag table, where 141 has 256kbps
+	streams := []*Stream{
+		{MediaType: "audio", Format: "mp4", AudioCodec: "mp4a.40.2", Abr: "256kbps", bitrate: 130000, itag: 141},
+		{MediaType: "audio", Format: "mp4", AudioCodec: "mp4a.40.2", Abr: "128kbps", bitrate: 150000, itag: 140},
+		{MediaType: "audio", Format: "mp4", AudioCodec: "mp4a.40.5", Abr: "48kbps", itag: 139},
+	}
+	if best := BestAudio(streams, ""); best == nil || best.itag != 140 {
+		t.Errorf("got %v, expected itag 140 of the highest reported bitrate", best)
+	}
+	// the table is used for a stream without a reported bitrate
+	streams[1].bitrate = 0
+	if best := BestAudio(streams, ""); best == nil || best.itag != 141 {
+		t.Errorf("got %v, expected itag 141 reporting more than 128kbps of 140 in the table", best)
+	}
+	streams[0].bitrate = 100000
+	if best := BestAudio(streams, ""); best == nil || best.itag != 140 {
+		t.Errorf("got %v, expected itag 140 of 128kbps in the table", best)
+	}
+}
+
+func TestBestAudioPrefersExtractableCodecs(t *testing.T) {
+	streams := []*Stream{
+		{MediaType: "audio", Format: "webm", AudioCodec: "vorbis", Abr: "256kbps", itag: 172},
+		{MediaType: "audio", Format: "webm", AudioCodec: "vorbis", Abr: "128kbps", itag: 171},
+		{MediaType: "audio", Format: "webm", AudioCodec: "opus", Abr: "160kbps", itag: 251},
+	}
+	for _, format := range []string{"", "webm"} {
+		best := BestAudio(streams, format)
+		if best == nil || best.itag != 251 {
+			t.Fatalf("got %v for format %q, expected itag 251", best, format)
+		}
+		if ext := AudioExtension(best); ext != ".opus" {
+			t.Errorf("got extension %s, expected .opus", ext)
+		}
+	}
+	// vorbis is chosen only when no other audio is there, and it has no extension ExtractAudio writes
+	if best := BestAudio(streams[:2], ""); best == nil || best.itag != 172 || AudioExtension(best) != "" {
+		t.Errorf("got %v, expected itag 172 without an extension", best)
+	}
+}
+
+func TestExtractAudio(t *testing.T) {
+	m4a := testFMP4(1, 1000, 44100, 0, "soun", []testFragment{{0, []byte("audio0")}, {1024, []byte("audio1")}})
+	opus := testWebM(1000000, 40, "A_OPUS", true, []testCluster{{0, []int16{0, 20}, []string{"\xf8one", "\xf8two"}}})
+	var mu sync.Mutex
+	var requested []int
+	cases := []struct {
+		format   string
+		content  []byte
+		extract  func(t *testing.T, out []byte) [][]byte
+		expected [][]byte
+	}{
+		{"mp4", m4a, m4aSamples, [][]byte{[]byte("audio0"), []byte("audio1")}},
+		{"webm", opus, func(t *testing.T, out []byte) [][]byte {
+			pages := oggPagesOf(t, out)
+			return pages[len(pages)-1].packets
+		}, [][]byte{[]byte("\xf8one"), []byte("\xf8two")}},
+	}
+	for _, c := range cases {
+		stream := &Stream{
+			url:       "https://foobar?itag=140&signature=geho",
+			MediaType: "audio",
+			Format:    c.format,
+			Retry:     &NoRetry,
+			client:    rangeServingClient(c.content, nil, &requested, &mu),
+		}
+		buf := new(bytes.Buffer)
+		if err := ExtractAudio(buf, stream); err != nil {
+			t.Errorf("extraction from %s failed, %s", c.format, err)
+			continue
+		}
+		if samples := c.extract(t, buf.Bytes()); !reflect.DeepEqual(samples, c.expected) {
+			t.Errorf("got %q from %s, expected %q", samples, c.format, c.expected)
+		}
+	}
+
+	video := &Stream{MediaType: "video", Format: "mp4"}
+	if err := ExtractAudio(new(bytes.Buffer), video); err == nil {
+		t.Error("video stream should be rejected")
+	}
+}
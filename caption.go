@@ -0,0 +1,268 @@
+package gotube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	htmlpkg "html"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CaptionTrack is a caption track of a video listed in the player response
+type CaptionTrack struct {
+	LanguageCode  string // such as en and ja
+	Name          string // display name of the track, in the language of the page
+	AutoGenerated bool   // generated by speech recognition
+	Translatable  bool   // can be translated into other languages with Translate
+	baseURL       string
+	dl            *YoutubeDownloader
+}
+
+// Caption is a cue of a caption track
+type Caption struct {
+	Start    time.Duration
+	Duration time.Duration
+	Text     string
+}
+
+// playerCaptions is the part of the player response listing caption tracks
+type playerCaptions struct {
+	Captions struct {
+		Renderer struct {
+			CaptionTracks []struct {
+				BaseURL        string       `json:"baseUrl"`
+				Name           playerString `json:"name"`
+				LanguageCode   string       `json:"languageCode"`
+				Kind           string       `json:"kind"`
+				IsTranslatable bool         `json:"isTranslatable"`
+			} `json:"captionTracks"`
+		} `json:"playerCaptionsTracklistRenderer"`
+	} `json:"captions"`
+}
+
+// playerString is a text of the player response given either as simpleText or as runs
+type playerString struct {
+	SimpleText string `json:"simpleText"`
+	Runs       []struct {
+		Text string `json:"text"`
+	} `json:"runs"`
+}
+
+func (s playerString) String() string {
+	if s.SimpleText != "" {
+		return s.SimpleText
+	}
+	var text string
+	for _, r := range s.Runs {
+		text += r.Text
+	}
+	return text
+}
+
+// Captions returns caption tracks of the video, which is empty if the video has no caption.
+// FetchStreams must be called beforehand.
+func (dl *YoutubeDownloader) Captions() ([]*CaptionTrack, error) {
+	if dl.metadata == nil {
+		return nil, errors.New("streams are not fetched yet")
+	}
+	return dl.captions, nil
+}
+
+// captionTracks returns caption tracks listed in the player response
+func (pc *playerCaptions) captionTracks(dl *YoutubeDownloader) []*CaptionTrack {
+	var tracks []*CaptionTrack
+	for _, t := range pc.Captions.Renderer.CaptionTracks {
+		if t.BaseURL == "" {
+			continue
+		}
+		tracks = append(tracks, &CaptionTrack{
+			LanguageCode:  t.LanguageCode,
+			Name:          t.Name.String(),
+			AutoGenerated: t.Kind == "asr",
+			Translatable:  t.IsTranslatable,
+			baseURL:       t.BaseURL,
+			dl:            dl,
+		})
+	}
+	return tracks
+}
+
+// Translate returns the track machine translated into a language
+func (c *CaptionTrack) Translate(languageCode string) (*CaptionTrack, error) {
+	if !c.Translatable {
+		return nil, fmt.Errorf("caption track of %s is not translatable", c.LanguageCode)
+	}
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("tlang", languageCode)
+	u.RawQuery = q.Encode()
+	translated := *c
+	translated.LanguageCode = languageCode
+	translated.Translatable = false
+	translated.baseURL = u.String()
+	return &translated, nil
+}
+
+// Download returns captions of the track
+func (c *CaptionTrack) Download() ([]Caption, error) {
+	return c.DownloadContext(context.Background())
+}
+
+// DownloadContext is Download with a context.
+// The request is canceled when ctx is done.
+func (c *CaptionTrack) DownloadContext(ctx context.Context) ([]Caption, error) {
+	data, err := c.dl.getResource(ctx, c.baseURL)
+	if err != nil {
+		return nil, err
+	}
+	return parseTimedText(data)
+}
+
+// parseTimedText reads captions of timedtext in XML of format 1 or 3, or in JSON3
+func parseTimedText(data []byte) ([]Caption, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("empty timedtext")
+	}
+	if data[0] == '{' {
+		return parseTimedTextJSON(data)
+	}
+	return parseTimedTextXML(data)
+}
+
+// parseTimedTextXML reads <text start="1.5" dur="2"> of format 1 and <p t="1500" d="2000"> of format 3
+func parseTimedTextXML(data []byte) ([]Caption, error) {
+	var doc struct {
+		Texts []struct {
+			Start string `xml:"start,attr"`
+			Dur   string `xml:"dur,attr"`
+			Inner string `xml:",innerxml"`
+		} `xml:"text"`
+		Paragraphs []struct {
+			T     int    `xml:"t,attr"`
+			D     int    `xml:"d,attr"`
+			Inner string `xml:",innerxml"`
+		} `xml:"body>p"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid timedtext, %s", err)
+	}
+
+	var captions []Caption
+	for _, t := range doc.Texts {
+		start, err := strconv.ParseFloat(t.Start, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start %s", t.Start)
+		}
+		dur, _ := strconv.ParseFloat(t.Dur, 64)
+		// texts of format 1 are escaped twice, and tags such as <font> are in the escaped text
+		captions = appendCaption(captions, seconds(start), seconds(dur), timedTextContent(htmlpkg.UnescapeString(t.Inner)))
+	}
+	for _, p := range doc.Paragraphs {
+		captions = appendCaption(captions, time.Duration(p.T)*time.Millisecond, time.Duration(p.D)*time.Millisecond, timedTextContent(p.Inner))
+	}
+	return captions, nil
+}
+
+// parseTimedTextJSON reads events of JSON3
+func parseTimedTextJSON(data []byte) ([]Caption, error) {
+	var doc struct {
+		Events []struct {
+			TStartMs    int `json:"tStartMs"`
+			DDurationMs int `json:"dDurationMs"`
+			Segs        []struct {
+				UTF8 string `json:"utf8"`
+			} `json:"segs"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid timedtext, %s", err)
+	}
+	var captions []Caption
+	for _, e := range doc.Events {
+		var text string
+		for _, s := range e.Segs {
+			text += s.UTF8
+		}
+		captions = appendCaption(captions, time.Duration(e.TStartMs)*time.Millisecond, time.Duration(e.DDurationMs)*time.Millisecond, text)
+	}
+	return captions, nil
+}
+
+// appendCaption appends a caption unless its text is blank
+func appendCaption(captions []Caption, start, duration time.Duration, text string) []Caption {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return captions
+	}
+	return append(captions, Caption{Start: start, Duration: duration, Text: text})
+}
+
+// timedTextContent returns a plain text of inner XML of an element, whose tags are removed and entities are unescaped
+func timedTextContent(inner string) string {
+	return htmlpkg.UnescapeString(htmlTagRegex.ReplaceAllString(inner, ""))
+}
+
+// seconds converts seconds to a duration rounded to milliseconds
+func seconds(s float64) time.Duration {
+	return time.Duration(s*1000+0.5) * time.Millisecond
+}
+
+// CaptionFormats are formats captions can be written in, keyed by their extensions
+var CaptionFormats = map[string]func(io.Writer, []Caption) error{
+	"srt": WriteSRT,
+	"vtt": WriteWebVTT,
+	"txt": WriteTranscript,
+}
+
+// WriteSRT writes captions in SubRip
+func WriteSRT(w io.Writer, captions []Caption) error {
+	for i, c := range captions {
+		start, end := captionTime(c.Start, ","), captionTime(c.Start+c.Duration, ",")
+		if _, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, start, end, c.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteWebVTT writes captions in WebVTT
+func WriteWebVTT(w io.Writer, captions []Caption) error {
+	if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+		return err
+	}
+	escaper := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	for _, c := range captions {
+		start, end := captionTime(c.Start, "."), captionTime(c.Start+c.Duration, ".")
+		if _, err := fmt.Fprintf(w, "%s --> %s\n%s\n\n", start, end, escaper.Replace(c.Text)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTranscript writes texts of captions, a caption in a line
+func WriteTranscript(w io.Writer, captions []Caption) error {
+	for _, c := range captions {
+		if _, err := fmt.Fprintln(w, strings.Replace(c.Text, "\n", " ", -1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// captionTime formats a time in hh:mm:ss followed by milliseconds after a separator
+func captionTime(t time.Duration, separator string) string {
+	ms := int64(t / time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
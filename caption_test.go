@@ -0,0 +1,115 @@
+package gotube
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testCaptions = []Caption{
+	{Start: 1500 * time.Millisecond, Duration: 2 * time.Second, Text: "I'm <here> & there"},
+	{Start: time.Hour + 2*time.Minute + 3*time.Second + 45*time.Millisecond, Duration: 1200 * time.Millisecond, Text: "second\nline"},
+}
+
+func TestParseTimedText(t *testing.T) {
+	cases := map[string]string{
+		"format 1": `<?xml version="1.0" encoding="utf-8" ?><transcript>` +
+			`<text start="1.5" dur="2">I&amp;#39;m &amp;lt;here&amp;gt; &amp;amp; there</text>` +
+			`<text start="3.5" dur="1">  </text>` +
+			`<text start="3723.045" dur="1.2">second
+line</text></transcript>`,
+		"format 3": `<?xml version="1.0" encoding="utf-8" ?><timedtext format="3"><body>` +
+			`<p t="1500" d="2000"><s>I&#39;m</s><s> &lt;here&gt;</s><s> &amp; there</s></p>` +
+			`<p t="3500" d="1000">` + "\n" + `</p>` +
+			`<p t="3723045" d="1200">second
+line</p></body></timedtext>`,
+		"json3": `{"wireMagic":"pb3","events":[` +
+			`{"tStartMs":1500,"dDurationMs":2000,"segs":[{"utf8":"I'm"},{"utf8":" <here>"},{"utf8":" & there"}]},` +
+			`{"tStartMs":3500,"dDurationMs":1000,"segs":[{"utf8":"\n"}]},` +
+			`{"tStartMs":3600000},` +
+			`{"tStartMs":3723045,"dDurationMs":1200,"segs":[{"utf8":"second\nline"}]}]}`,
+	}
+	for name, data := range cases {
+		captions, err := parseTimedText([]byte(data))
+		if err != nil {
+			t.Errorf("%s: failed to parse, %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(captions, testCaptions) {
+			t.Errorf("%s: got %q, expected %q", name, captions, testCaptions)
+		}
+	}
+	for _, data := range []string{"", "<transcript><text start=\"x\">a</text></transcript>", "{\"events\":"} {
+		if _, err := parseTimedText([]byte(data)); err == nil {
+			t.Errorf("invalid timedtext %q is accepted", data)
+		}
+	}
+}
+
+func TestWriteCaptions(t *testing.T) {
+	cases := map[string]string{
+		"srt": "1\n00:00:01,500 --> 00:00:03,500\nI'm <here> & there\n\n" +
+			"2\n01:02:03,045 --> 01:02:04,245\nsecond\nline\n\n",
+		"vtt": "WEBVTT\n\n" +
+			"00:00:01.500 --> 00:00:03.500\nI'm &lt;here&gt; &amp; there\n\n" +
+			"01:02:03.045 --> 01:02:04.245\nsecond\nline\n\n",
+		"txt": "I'm <here> & there\nsecond line\n",
+	}
+	for format, expected := range cases {
+		buf := new(bytes.Buffer)
+		if err := CaptionFormats[format](buf, testCaptions); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expected {
+			t.Errorf("%s: got %q, expected %q", format, buf.String(), expected)
+		}
+	}
+}
+
+func TestCaptionTrackDownload(t *testing.T) {
+	var requested []string
+	dl := &YoutubeDownloader{
+		Retry: &NoRetry,
+		client: &fakeClient{fakeGet: func(ctx context.Context, u string) (*http.Response, error) {
+			requested = append(requested, u)
+			body := `<transcript><text start="0" dur="1.5">hello</text></transcript>`
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+		}},
+	}
+	pr := parsePlayerResponse([]byte(`{"captions":{"playerCaptionsTracklistRenderer":{"captionTracks":[` +
+		`{"baseUrl":"https://www.youtube.com/api/timedtext?v=6LZM3_wp2ps&lang=en","name":{"simpleText":"English"},"languageCode":"en","isTranslatable":true},` +
+		`{"baseUrl":"https://www.youtube.com/api/timedtext?v=6LZM3_wp2ps&lang=ja&kind=asr","name":{"runs":[{"text":"Japanese"},{"text":" (auto-generated)"}]},"languageCode":"ja","kind":"asr"}` +
+		`]}}}`))
+	tracks := pr.captionTracks(dl)
+	if len(tracks) != 2 || tracks[0].Name != "English" || tracks[0].AutoGenerated || tracks[1].Name != "Japanese (auto-generated)" || !tracks[1].AutoGenerated {
+		t.Fatalf("wrong caption tracks %v", tracks)
+	}
+
+	captions, err := tracks[0].Download()
+	if err != nil {
+		t.Fatalf("download failed, %s", err)
+	}
+	if expected := []Caption{{0, 1500 * time.Millisecond, "hello"}}; !reflect.DeepEqual(captions, expected) {
+		t.Errorf("got %v, expected %v", captions, expected)
+	}
+
+	translated, err := tracks[0].Translate("fr")
+	if err != nil {
+		t.Fatalf("translation failed, %s", err)
+	}
+	if _, err := translated.Download(); err != nil {
+		t.Fatalf("download failed, %s", err)
+	}
+	if u, _ := url.Parse(requested[1]); translated.LanguageCode != "fr" || u.Query().Get("tlang") != "fr" || u.Query().Get("lang") != "en" {
+		t.Errorf("requested %s for a translation into fr", requested[1])
+	}
+	if _, err := tracks[1].Translate("fr"); err == nil {
+		t.Error("an untranslatable track is translated")
+	}
+}
@@ -0,0 +1,358 @@
+package gotube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChannelTab is a tab of a channel listing videos
+type ChannelTab string
+
+// Tabs of a channel which Uploads lists
+const (
+	VideosTab  ChannelTab = "videos"
+	ShortsTab  ChannelTab = "shorts"
+	StreamsTab ChannelTab = "streams"
+)
+
+var (
+	channelIDPattern = regexp.MustCompile(`^UC[\w-]{22}$`)
+	viewCountPattern = regexp.MustCompile(`^([\d,]+) views?$`)
+)
+
+// Channel resolves a channel url to the channel id and lists videos uploaded to the channel.
+// Retry is applied to requests for the channel, DefaultRetryPolicy if nil, and Retry, Pool and RateLimiter are set to downloaders of videos.
+type Channel struct {
+	videoLister        // kept first for 64-bit alignment of retries
+	Title       string // set when a page of the channel is requested by ResolveID or Uploads
+	url         string // page of the channel such as https://www.youtube.com/@handle
+	id          string
+}
+
+// ChannelVideo is a video listed in a tab of a channel
+type ChannelVideo struct {
+	ID         string
+	Title      string
+	Duration   time.Duration // 0 if not shown, e.g. for shorts and live streams
+	ViewCount  int64         // 0 if not shown, shown only approximately such as 1.2M, or live
+	Published  string        // time since published as shown in the channel such as "2 years ago", "" if not shown
+	IsLive     bool          // being broadcast live now
+	IsUpcoming bool          // scheduled to be broadcast live
+}
+
+// channelData is the part of ytInitialData of a channel page, or of a response to a continuation, used to list videos
+type channelData struct {
+	Contents struct {
+		TwoColumn struct {
+			Tabs []struct {
+				TabRenderer struct {
+					Selected bool `json:"selected"`
+					Endpoint struct {
+						CommandMetadata struct {
+							WebCommandMetadata struct {
+								URL string `json:"url"`
+							} `json:"webCommandMetadata"`
+						} `json:"commandMetadata"`
+					} `json:"endpoint"`
+					Content struct {
+						RichGrid *struct {
+							Contents []channelItem `json:"contents"`
+						} `json:"richGridRenderer"`
+					} `json:"content"`
+				} `json:"tabRenderer"`
+			} `json:"tabs"`
+		} `json:"twoColumnBrowseResultsRenderer"`
+	} `json:"contents"`
+	Metadata struct {
+		Renderer struct {
+			Title      string `json:"title"`
+			ExternalID string `json:"externalId"`
+		} `json:"channelMetadataRenderer"`
+	} `json:"metadata"`
+	OnResponseReceivedActions []struct {
+		AppendContinuationItemsAction struct {
+			ContinuationItems []channelItem `json:"continuationItems"`
+		} `json:"appendContinuationItemsAction"`
+	} `json:"onResponseReceivedActions"`
+}
+
+// channelItem is either a video or a continuation to the following videos
+type channelItem struct {
+	RichItem *struct {
+		Content struct {
+			Video *struct {
+				VideoID           string       `json:"videoId"`
+				Title             playerString `json:"title"`
+				LengthText        playerString `json:"lengthText"`
+				ViewCountText     playerString `json:"viewCountText"`
+				PublishedTimeText playerString `json:"publishedTimeText"`
+				UpcomingEventData *struct{}    `json:"upcomingEventData"`
+				Badges            []struct {
+					Renderer struct {
+						Style string `json:"style"`
+					} `json:"metadataBadgeRenderer"`
+				} `json:"badges"`
+			} `json:"videoRenderer"`
+			Reel *struct {
+				VideoID       string       `json:"videoId"`
+				Headline      playerString `json:"headline"`
+				ViewCountText playerString `json:"viewCountText"`
+			} `json:"reelItemRenderer"`
+			ShortsLockup *struct {
+				OnTap struct {
+					InnertubeCommand struct {
+						ReelWatchEndpoint struct {
+							VideoID string `json:"videoId"`
+						} `json:"reelWatchEndpoint"`
+					} `json:"innertubeCommand"`
+				} `json:"onTap"`
+				OverlayMetadata struct {
+					PrimaryText struct {
+						Content string `json:"content"`
+					} `json:"primaryText"`
+				} `json:"overlayMetadata"`
+			} `json:"shortsLockupViewModel"`
+		} `json:"content"`
+	} `json:"richItemRenderer"`
+	Continuation *continuationRenderer `json:"continuationItemRenderer"`
+}
+
+// NewChannel returns a Channel of a url such as https://www.youtube.com/@handle, /c/name, /user/name and /channel/UC...
+// A bare channel id or @handle is also accepted.
+func NewChannel(channelURL string) (*Channel, error) {
+	s := strings.TrimSpace(channelURL)
+	switch {
+	case channelIDPattern.MatchString(s):
+		return &Channel{videoLister: videoLister{client: &youtubeClient{}}, url: "https://www.youtube.com/channel/" + s, id: s}, nil
+	case strings.HasPrefix(s, "@") && !strings.ContainsAny(s, "/?#"):
+		return &Channel{videoLister: videoLister{client: &youtubeClient{}}, url: "https://www.youtube.com/" + s}, nil
+	}
+
+	raw := s
+	if !schemePattern.MatchString(raw) {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("unexpected URL format %s", s)
+	}
+	if !youtubeHosts[strings.ToLower(u.Hostname())] {
+		return nil, fmt.Errorf("%s is not a YouTube channel URL", s)
+	}
+	segments := strings.Split(strings.TrimPrefix(u.EscapedPath(), "/"), "/")
+	ch := &Channel{videoLister: videoLister{client: &youtubeClient{}}}
+	switch {
+	case strings.HasPrefix(segments[0], "@") && len(segments[0]) > 1:
+		ch.url = "https://www.youtube.com/" + segments[0]
+	case len(segments) > 1 && segments[1] != "" && (segments[0] == "c" || segments[0] == "user"):
+		ch.url = "https://www.youtube.com/" + segments[0] + "/" + segments[1]
+	case len(segments) > 1 && segments[0] == "channel" && channelIDPattern.MatchString(segments[1]):
+		ch.url = "https://www.youtube.com/channel/" + segments[1]
+		ch.id = segments[1]
+	default:
+		return nil, fmt.Errorf("no channel found in %s", s)
+	}
+	return ch, nil
+}
+
+// ResolveID returns the channel id, UC followed by 22 characters.
+// The page of the channel is requested unless the url has the id.
+func (ch *Channel) ResolveID() (string, error) {
+	return ch.ResolveIDContext(context.Background())
+}
+
+// ResolveIDContext is ResolveID with a context.
+// The request for the page is canceled when ctx is done.
+func (ch *Channel) ResolveIDContext(ctx context.Context) (string, error) {
+	if ch.id != "" {
+		return ch.id, nil
+	}
+	html, err := ch.getResource(ctx, ch.url)
+	if err != nil {
+		return "", err
+	}
+	if _, err := ch.parsePage(html); err != nil {
+		return "", err
+	}
+	return ch.id, nil
+}
+
+// Uploads lists every video in a tab of the channel, VideosTab for uploaded videos.
+// Videos beyond the first page are requested by following continuations.
+func (ch *Channel) Uploads(tab ChannelTab) ([]*ChannelVideo, error) {
+	return ch.UploadsContext(context.Background(), tab)
+}
+
+// UploadsContext is Uploads with a context.
+// Requests for the page and the continuations are canceled when ctx is done.
+func (ch *Channel) UploadsContext(ctx context.Context, tab ChannelTab) ([]*ChannelVideo, error) {
+	html, err := ch.getResource(ctx, ch.url+"/"+string(tab))
+	if err != nil {
+		return nil, err
+	}
+	data, err := ch.parsePage(html)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := data.tab(tab)
+	if !ok {
+		return nil, fmt.Errorf("channel %s has no %s tab", ch.url, tab)
+	}
+
+	cfg := extractInnertubeConfig(html)
+	var videos []*ChannelVideo
+	requested := make(map[string]bool)
+	for {
+		var token string
+		videos, token = appendChannelVideos(videos, items)
+		// a token seen before would list the same videos again
+		if token == "" || requested[token] {
+			break
+		}
+		requested[token] = true
+		if items, err = ch.continuation(ctx, cfg, token); err != nil {
+			return nil, err
+		}
+	}
+	return videos, nil
+}
+
+// Downloader returns a YoutubeDownloader of a video of the channel, which shares Retry, Pool and RateLimiter.
+// FetchStreams of it must be called to download the video.
+func (ch *Channel) Downloader(video *ChannelVideo) (*YoutubeDownloader, error) {
+	return ch.downloader(video.ID)
+}
+
+// parsePage reads ytInitialData of a page of the channel, and sets the id and the title
+func (ch *Channel) parsePage(html []byte) (*channelData, error) {
+	raw := findJSON(html, initialDataRegex, "ytInitialData")
+	if raw == nil {
+		return nil, fmt.Errorf("no ytInitialData found in the page of channel %s", ch.url)
+	}
+	data := &channelData{}
+	if err := json.Unmarshal(raw, data); err != nil {
+		return nil, fmt.Errorf("invalid ytInitialData of channel %s, %s", ch.url, err)
+	}
+	id := data.Metadata.Renderer.ExternalID
+	if id == "" {
+		id = extractMeta(html, channelIDRegex, "channel id")
+	}
+	if !channelIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("no channel id found in the page of channel %s", ch.url)
+	}
+	ch.id = id
+	ch.Title = data.Metadata.Renderer.Title
+	return data, nil
+}
+
+// tab returns items of a tab, ok is false if the page does not show it.
+// A channel without the tab shows another one instead.
+func (d *channelData) tab(tab ChannelTab) (items []channelItem, ok bool) {
+	for _, t := range d.Contents.TwoColumn.Tabs {
+		r := t.TabRenderer
+		if r.Selected && r.Content.RichGrid != nil && strings.HasSuffix(r.Endpoint.CommandMetadata.WebCommandMetadata.URL, "/"+string(tab)) {
+			return r.Content.RichGrid.Contents, true
+		}
+	}
+	return nil, false
+}
+
+// appendChannelVideos appends videos of items to videos and returns the token of a continuation, "" if none
+func appendChannelVideos(videos []*ChannelVideo, items []channelItem) ([]*ChannelVideo, string) {
+	var token string
+	for _, item := range items {
+		if item.Continuation != nil {
+			token = item.Continuation.token()
+		}
+		if item.RichItem == nil {
+			continue
+		}
+		content := item.RichItem.Content
+		switch {
+		case content.Video != nil:
+			v := content.Video
+			video := &ChannelVideo{
+				ID:         v.VideoID,
+				Title:      v.Title.String(),
+				Duration:   parseLengthText(v.LengthText.String()),
+				ViewCount:  parseViewCount(v.ViewCountText.String()),
+				Published:  v.PublishedTimeText.String(),
+				IsUpcoming: v.UpcomingEventData != nil,
+			}
+			for _, badge := range v.Badges {
+				if badge.Renderer.Style == "BADGE_STYLE_TYPE_LIVE_NOW" {
+					video.IsLive = true
+				}
+			}
+			videos = append(videos, video)
+		case content.Reel != nil:
+			r := content.Reel
+			videos = append(videos, &ChannelVideo{ID: r.VideoID, Title: r.Headline.String(), ViewCount: parseViewCount(r.ViewCountText.String())})
+		case content.ShortsLockup != nil:
+			l := content.ShortsLockup
+			videos = append(videos, &ChannelVideo{ID: l.OnTap.InnertubeCommand.ReelWatchEndpoint.VideoID, Title: l.OverlayMetadata.PrimaryText.Content})
+		}
+	}
+	return videos, token
+}
+
+// parseLengthText converts a length such as 4:39 or 1:02:03 into a duration, 0 if invalid
+func parseLengthText(text string) time.Duration {
+	if text == "" {
+		return 0
+	}
+	var seconds int
+	for _, part := range strings.Split(text, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// parseViewCount converts a number of views such as "3,923,415,688 views" into an integer, 0 if not exact
+func parseViewCount(text string) int64 {
+	m := viewCountPattern.FindStringSubmatch(text)
+	if m == nil {
+		return 0
+	}
+	views, err := strconv.ParseInt(strings.Replace(m[1], ",", "", -1), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return views
+}
+
+// continuation requests the items following those of a token
+func (ch *Channel) continuation(ctx context.Context, cfg *innertubeConfig, token string) ([]channelItem, error) {
+	res, err := cfg.browse(ctx, ch.client, ch.retryPolicy(), &ch.retries, token)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &channelData{}
+	if err := json.Unmarshal(res, data); err != nil {
+		return nil, fmt.Errorf("invalid continuation of channel %s, %s", ch.url, err)
+	}
+	var items []channelItem
+	for _, action := range data.OnResponseReceivedActions {
+		items = append(items, action.AppendContinuationItemsAction.ContinuationItems...)
+	}
+	return items, nil
+}
+
+// getResource gets a page of the channel, failed requests are retried according to the retry policy
+func (ch *Channel) getResource(ctx context.Context, url string) ([]byte, error) {
+	return readResource(ctx, ch.retryPolicy(), &ch.retries, url, func() (*http.Response, error) {
+		return ch.client.Get(ctx, url)
+	})
+}
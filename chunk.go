@@ -0,0 +1,102 @@
+package gotube
+
+import (
+	"context"
+	"fmt"
+)
+
+// RangeError reports a byte range [Start, End) of a stream which failed to be downloaded.
+type RangeError struct {
+	Start int
+	End   int
+	Err   error
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("range %d-%d, %s", e.Start, e.End-1, e.Err)
+}
+
+// ChunkReader receives downloaded chunks of a stream in order.
+// Call Next to wait for the next chunk, and check Err when Next returns false.
+//
+//	r, _ := stream.SequentialChunkDownload(20 * time.Second)
+//	defer r.Close()
+//	for r.Next() {
+//		w.Write(r.Chunk())
+//	}
+//	if err := r.Err(); err != nil {
+//		// handle error
+//	}
+type ChunkReader struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	results   <-chan chunkResult
+	remaining int
+	current   chunkResult
+	err       error
+}
+
+// chunkResult is a downloaded data of range [start, end) or an error
+type chunkResult struct {
+	data  []byte
+	start int
+	end   int
+	err   error
+}
+
+// Next waits for the next chunk and reports whether it is available.
+// It returns false when all chunks are received, a chunk fails to be downloaded,
+// or the context is done.
+func (r *ChunkReader) Next() bool {
+	if r.err != nil || r.remaining == 0 {
+		return false
+	}
+	res, ok := <-r.results
+	if !ok {
+		// the pipeline is stopped before all chunks are sent
+		r.err = r.ctx.Err()
+		if r.err == nil {
+			r.err = context.Canceled
+		}
+		return false
+	}
+	if res.err != nil {
+		if err := r.ctx.Err(); err != nil {
+			// the request failed because of cancellation
+			r.err = err
+		} else {
+			r.err = &RangeError{Start: res.start, End: res.end, Err: res.err}
+		}
+		r.cancel() // stop remaining requests
+		return false
+	}
+	r.current = res
+	r.remaining--
+	if r.remaining == 0 {
+		r.cancel() // release the context
+	}
+	return true
+}
+
+// Chunk returns the chunk received by the last call of Next
+func (r *ChunkReader) Chunk() []byte {
+	return r.current.data
+}
+
+// Range returns a byte range [start, end) of the chunk received by the last call of Next
+func (r *ChunkReader) Range() (start, end int) {
+	return r.current.start, r.current.end
+}
+
+// Err returns an error which stopped Next, or nil if all chunks are received.
+// A failed download is reported as *RangeError.
+func (r *ChunkReader) Err() error {
+	return r.err
+}
+
+// Close stops requests and goroutines of the download.
+// Close must be called if you stop before Next returns false.
+func (r *ChunkReader) Close() error {
+	r.cancel()
+	return nil
+}
@@ -1,22 +1,43 @@
 package gotube
 
 import (
+	"bytes"
+	"context"
 	"net/http"
 )
 
 type client interface {
-	Get(url string) (*http.Response, error)
-	Head(url string) (*http.Response, error)
+	Get(ctx context.Context, url string) (*http.Response, error)
+	Head(ctx context.Context, url string) (*http.Response, error)
+	Post(ctx context.Context, url, contentType string, body []byte) (*http.Response, error)
 }
 
 type youtubeClient struct{}
 
-// Get wraps http.Get method
-func (c *youtubeClient) Get(url string) (*http.Response, error) {
-	return http.Get(url)
+// Get wraps http.Get method, the request is canceled when ctx is done
+func (c *youtubeClient) Get(ctx context.Context, url string) (*http.Response, error) {
+	return c.do(ctx, http.MethodGet, url)
 }
 
-// Head wraps http.Head method
-func (c *youtubeClient) Head(url string) (*http.Response, error) {
-	return http.Head(url)
+// Head wraps http.Head method, the request is canceled when ctx is done
+func (c *youtubeClient) Head(ctx context.Context, url string) (*http.Response, error) {
+	return c.do(ctx, http.MethodHead, url)
+}
+
+// Post wraps http.Post method, the request is canceled when ctx is done
+func (c *youtubeClient) Post(ctx context.Context, url, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return http.DefaultClient.Do(req.WithContext(ctx))
+}
+
+func (c *youtubeClient) do(ctx context.Context, method, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req.WithContext(ctx))
 }
@@ -0,0 +1,337 @@
+package gotube
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// clipChunkDuration is a duration of media fetched by a request of DownloadRange
+	clipChunkDuration = 20 * time.Second
+)
+
+// DownloadRange returns content of the stream between start and end as a standalone file.
+// Only media segments covering the range are fetched following the container index, so the stream must be a DASH stream.
+// The content starts at the keyframe at or before start and ends at the end of the segment containing end,
+// and its timestamps are shifted to begin at zero.
+// end of 0 means the end of the stream.
+func (s *Stream) DownloadRange(start, end time.Duration) ([]byte, error) {
+	return s.DownloadRangeContext(context.Background(), start, end)
+}
+
+// DownloadRangeContext is DownloadRange with a context.
+// The requests are canceled when ctx is done.
+func (s *Stream) DownloadRangeContext(ctx context.Context, start, end time.Duration) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := s.DownloadRangeToContext(ctx, buf, start, end); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DownloadRangeTo writes content of the stream between start and end to w as it arrives (see DownloadRange).
+func (s *Stream) DownloadRangeTo(w io.Writer, start, end time.Duration) error {
+	return s.DownloadRangeToContext(context.Background(), w, start, end)
+}
+
+// DownloadRangeToContext is DownloadRangeTo with a context.
+// The requests are canceled when ctx is done.
+func (s *Stream) DownloadRangeToContext(ctx context.Context, w io.Writer, start, end time.Duration) error {
+	if start < 0 || (end != 0 && end <= start) {
+		return fmt.Errorf("invalid range %s-%s", start, end)
+	}
+	if !s.hasIndex() {
+		return errors.New("stream has no container index to find the range")
+	}
+	totalSize, err := s.GetSizeContext(ctx)
+	if err != nil {
+		return err
+	}
+	segments, err := s.segments(ctx, totalSize)
+	if err != nil {
+		return fmt.Errorf("failed to read container index, %s", err)
+	}
+	clip := clipSegments(segments, start, end)
+	if clip == nil {
+		return fmt.Errorf("range %s-%s is beyond the end of the stream", start, end)
+	}
+	var duration time.Duration
+	for _, seg := range clip {
+		duration += seg.duration
+	}
+
+	init, err := s.download(ctx, rangeQuery(s.initRange.start, s.initRange.end), nil)
+	if err != nil {
+		return fmt.Errorf("failed to fetch init range, %s", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	media := s.pipeSegments(ctx, clip)
+	defer media.Close()
+
+	switch s.Format {
+	case "mp4":
+		err = clipMP4(w, init, media, int64(clip[0].start), duration)
+	case "webm":
+		err = clipWebM(w, init, media, duration)
+	}
+	if err != nil {
+		return err
+	}
+	logger.print("download completed")
+	return nil
+}
+
+// CanClip tells whether DownloadRange can download a range of the stream,
+// which needs the container index given to DASH streams
+func (s *Stream) CanClip() bool {
+	return s.hasIndex()
+}
+
+// StartTime returns a start time designated by t= of the url given to NewDownloader such as t=90 or t=1m30s, or 0 if not designated
+func (dl *YoutubeDownloader) StartTime() time.Duration {
+	return dl.startTime
+}
+
+// startTimeOf returns a start time designated by t= in the query or the fragment of a url, or 0 if not designated
+func startTimeOf(videoURL string) time.Duration {
+	u, err := url.Parse(videoURL)
+	if err != nil {
+		return 0
+	}
+	v := u.Query().Get("t")
+	if v == "" {
+		// t= can also be in the fragment
+		if fragment, err := url.ParseQuery(u.Fragment); err == nil {
+			v = fragment.Get("t")
+		}
+	}
+	t, err := parseStartTime(v)
+	if err != nil {
+		return 0
+	}
+	return t
+}
+
+// parseStartTime parses a value of t= in seconds or in hours, minutes and seconds such as 1h2m3s
+func parseStartTime(v string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	if strings.HasPrefix(v, "-") || strings.ContainsAny(v, ".") {
+		return 0, fmt.Errorf("invalid start time %s", v)
+	}
+	return time.ParseDuration(v)
+}
+
+// clipSegments returns segments covering a range, or nil if start is beyond the end of the stream.
+// The first one starts at or before start, and the last one contains end.
+func clipSegments(segments []mediaSegment, start, end time.Duration) []mediaSegment {
+	last := segments[len(segments)-1]
+	if last.duration > 0 && start >= last.time+last.duration {
+		return nil
+	}
+	first := 0
+	for i, seg := range segments {
+		if seg.time <= start {
+			first = i
+		}
+	}
+	n := len(segments)
+	if end > 0 {
+		for n > first+1 && segments[n-1].time >= end {
+			n--
+		}
+	}
+	return segments[first:n]
+}
+
+// pipeSegments returns a reader of consecutive segments fetched in order.
+// Segments are grouped into requests of clipChunkDuration, and closing the reader stops the download.
+func (s *Stream) pipeSegments(ctx context.Context, segments []mediaSegment) io.ReadCloser {
+	end := segments[len(segments)-1].end
+	ranges := segmentRanges(segments, end, clipChunkDuration)
+	ranges[0] = segments[0].start
+
+	pr, pw := io.Pipe()
+	go func() {
+		t := s.newProgressTracker(end-segments[0].start, len(ranges)-1, 0)
+		defer t.finish()
+		for i := 0; i+1 < len(ranges); i++ {
+			data, err := s.download(ctx, rangeQuery(ranges[i], ranges[i+1]-1), t)
+			t.chunkDone(err != nil)
+			if err == nil {
+				_, err = pw.Write(data)
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+	return pr
+}
+
+// clipMP4 writes a fragmented MP4 of an init range and fragments starting at mediaStart in the stream.
+// The track is renumbered to 1 and decode times are shifted so that the first fragment starts at zero.
+func clipMP4(w io.Writer, init []byte, media io.Reader, mediaStart int64, duration time.Duration) error {
+	in := &fmp4Reader{r: bytes.NewReader(init)}
+	if err := in.readInit(1); err != nil {
+		return fmt.Errorf("invalid init range, %s", err)
+	}
+	// positions of fragments are those in the stream, which base data offsets refer to
+	in.r, in.pos = media, mediaStart
+
+	moov, err := clipMoov(in, duration)
+	if err != nil {
+		return err
+	}
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(in.ftyp); err != nil {
+		return err
+	}
+	if _, err := cw.Write(moov); err != nil {
+		return err
+	}
+
+	var origin uint64
+	sequence := uint32(1)
+	for {
+		f, err := in.readFragment()
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			break
+		}
+		if t, ok := fragmentDecodeTime(f.moof[8:]); ok {
+			if sequence == 1 {
+				origin = t
+			}
+			if t < origin {
+				return fmt.Errorf("decode time %d precedes the first fragment", t)
+			}
+			setFragmentDecodeTime(f.moof[8:], t-origin)
+		}
+		if err := f.relocate(sequence, in.trackID, cw.n); err != nil {
+			return err
+		}
+		if _, err := cw.Write(f.moof); err != nil {
+			return err
+		}
+		if _, err := cw.Write(f.mdat); err != nil {
+			return err
+		}
+		sequence++
+	}
+	if sequence == 1 {
+		return errors.New("no fragment found in the range")
+	}
+	return nil
+}
+
+// clipMoov returns moov of the input with durations set to a clip and the track renumbered
+func clipMoov(in *fmp4Reader, duration time.Duration) ([]byte, error) {
+	boxes, err := parseMP4Boxes(in.moov.payload)
+	if err != nil {
+		return nil, err
+	}
+	mvhd, trak := childBox(boxes, "mvhd"), childBox(boxes, "trak")
+	if mvhd == nil {
+		return nil, errors.New("no mvhd box found")
+	}
+	movieTimescale, _, err := movieTime(mvhd)
+	if err != nil {
+		return nil, err
+	}
+	if err := setTrackID(trak, in.trackID); err != nil {
+		return nil, err
+	}
+	trex := findMP4Box(in.moov.payload, "mvex", "trex")
+	if trex == nil || len(trex.payload) < 8 {
+		return nil, errors.New("no valid trex box found")
+	}
+	binary.BigEndian.PutUint32(trex.payload[4:8], in.trackID)
+
+	movieDuration := uint64(duration) * uint64(movieTimescale) / uint64(time.Second)
+	setMovieDuration(mvhd, movieDuration)
+	setTrackDuration(trak, movieDuration, in.timescale, movieTimescale)
+	if mdhd := findMP4Box(trak.payload, "mdia", "mdhd"); mdhd != nil {
+		setMovieDuration(mdhd, uint64(duration)*uint64(in.timescale)/uint64(time.Second))
+	}
+	if mehd := findMP4Box(in.moov.payload, "mvex", "mehd"); mehd != nil {
+		if len(mehd.payload) >= 12 && mehd.payload[0] == 1 {
+			binary.BigEndian.PutUint64(mehd.payload[4:12], movieDuration)
+		} else if len(mehd.payload) >= 8 && mehd.payload[0] == 0 {
+			binary.BigEndian.PutUint32(mehd.payload[4:8], uint32(movieDuration))
+		}
+	}
+	return in.moov.bytes(), nil
+}
+
+// setFragmentDecodeTime rewrites baseMediaDecodeTime in tfdt of a moof payload
+func setFragmentDecodeTime(moof []byte, t uint64) {
+	tfdt := findMP4Box(moof, "traf", "tfdt")
+	if tfdt == nil || len(tfdt.payload) < 8 {
+		return
+	}
+	if tfdt.payload[0] == 1 {
+		if len(tfdt.payload) >= 12 {
+			binary.BigEndian.PutUint64(tfdt.payload[4:12], t)
+		}
+	} else {
+		binary.BigEndian.PutUint32(tfdt.payload[4:8], uint32(t))
+	}
+}
+
+// clipWebM writes a WebM of an init range and clusters following it.
+// The track is renumbered to 1 and timecodes are shifted so that the first cluster starts at zero.
+func clipWebM(w io.Writer, init []byte, media io.Reader, duration time.Duration) error {
+	in := &webmReader{r: io.MultiReader(bytes.NewReader(init), media)}
+	if err := in.readInit(); err != nil {
+		return fmt.Errorf("invalid init range, %s", err)
+	}
+	info := webmInfo(in.info, in.timecodeScale, float64(duration)/float64(in.timecodeScale))
+	tracks := makeEBMLElement(ebmlIDTracks, renumberTrack(in.track, 1, in.trackUID))
+	return writeWebM(w, in.header, in.timecodeScale, info, tracks, func(m *webmMuxer) error {
+		return m.writeClip(in)
+	})
+}
+
+// writeClip writes a cluster for each cluster of a single track with timecodes shifted by the first cluster
+func (m *webmMuxer) writeClip(in *webmReader) error {
+	var origin int64
+	for first := true; ; first = false {
+		c, err := in.readCluster()
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			if first {
+				return errors.New("no Cluster found in the range")
+			}
+			return m.flush()
+		}
+		if first {
+			origin = c.time
+		}
+		if err := m.startCluster((c.time-origin)/int64(m.timecodeScale), true); err != nil {
+			return err
+		}
+		for _, b := range c.blocks {
+			b.time -= origin
+			if err := m.add(b, 1, in.timecodeScale); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package gotube
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClipSegments(t *testing.T) {
+	segments := []mediaSegment{
+		{start: 0, end: 10, time: 0, duration: time.Second},
+		{start: 10, end: 20, time: time.Second, duration: time.Second},
+		{start: 20, end: 30, time: 2 * time.Second, duration: time.Second},
+	}
+	cases := []struct {
+		start, end time.Duration
+		expected   []mediaSegment
+	}{
+		{0, 0, segments},
+		{1500 * time.Millisecond, 0, segments[1:]},
+		{time.Second, 2 * time.Second, segments[1:2]},
+		{500 * time.Millisecond, 1500 * time.Millisecond, segments[:2]},
+		{2500 * time.Millisecond, 2600 * time.Millisecond, segments[2:]},
+		{3 * time.Second, 0, nil},
+	}
+	for _, c := range cases {
+		if clip := clipSegments(segments, c.start, c.end); !reflect.DeepEqual(clip, c.expected) {
+			t.Errorf("got %v for %s-%s, expected %v", clip, c.start, c.end, c.expected)
+		}
+	}
+}
+
+func TestStartTime(t *testing.T) {
+	cases := map[string]time.Duration{
+		"https://www.youtube.com/watch?v=iEPTlhBmwRg":             0,
+		"https://www.youtube.com/watch?v=iEPTlhBmwRg&t=90":        90 * time.Second,
+		"https://www.youtube.com/watch?v=iEPTlhBmwRg&t=90s":       90 * time.Second,
+		"https://www.youtube.com/watch?v=iEPTlhBmwRg&t=1h2m3s":    time.Hour + 2*time.Minute + 3*time.Second,
+		"https://www.youtube.com/watch?v=iEPTlhBmwRg#t=1m30s":     90 * time.Second,
+		"https://www.youtube.com/watch?v=iEPTlhBmwRg&t=-5":        0,
+		"https://www.youtube.com/watch?v=iEPTlhBmwRg&t=somewhere": 0,
+		"https://youtu.be/iEPTlhBmwRg?t=42":                       42 * time.Second,
+	}
+	for u, expected := range cases {
+		dl, err := NewDownloader(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if start := dl.StartTime(); start != expected {
+			t.Errorf("got %s for %s, expected %s", start, u, expected)
+		}
+	}
+}
+
+func TestDownloadRangeMP4(t *testing.T) {
+	video := testFMP4(1, 1000, 90000, 4000, "vide", []testFragment{
+		{0, []byte("video0")},
+		{90000, []byte("video1")},
+		{180000, []byte("video2")},
+		{270000, []byte("video3")},
+	})
+	boxes, err := parseMP4Boxes(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replace the sidx of the synthetic stream with one of a second for each fragment
+	var init, fragments []byte
+	var sizes []int
+	for _, b := range boxes {
+		switch b.typ {
+		case "ftyp", "moov":
+			init = append(init, b.bytes()...)
+		case "moof":
+			sizes = append(sizes, len(b.bytes()))
+			fragments = append(fragments, b.bytes()...)
+		case "mdat":
+			sizes[len(sizes)-1] += len(b.bytes())
+			fragments = append(fragments, b.bytes()...)
+		}
+	}
+	index := sidxBox(0, sizes, []uint32{1000, 1000, 1000, 1000})
+	content := append(append(append([]byte{}, init...), index...), fragments...)
+	segmentStart := len(init) + len(index)
+
+	var mu sync.Mutex
+	var requested []int
+	stream := Stream{
+		url:        "https://foobar?itag=137&signature=geho",
+		Format:     "mp4",
+		Retry:      &NoRetry,
+		initRange:  byteRange{start: 0, end: len(init) - 1},
+		indexRange: byteRange{start: len(init), end: segmentStart - 1},
+		client:     rangeServingClient(content, nil, &requested, &mu),
+	}
+	out, err := stream.DownloadRange(1500*time.Millisecond, 2500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("download failed, %s", err)
+	}
+
+	// only the index, the init range and the segments of the range are fetched
+	if expected := []int{len(init), 0, segmentStart + sizes[0]}; !reflect.DeepEqual(requested, expected) {
+		t.Errorf("requested ranges starting at %v, expected %v", requested, expected)
+	}
+	if _, samples := samplesOf(t, out); !reflect.DeepEqual(samples, [][]byte{[]byte("video1"), []byte("video2")}) {
+		t.Errorf("got samples %q", samples)
+	}
+	var decodeTimes []uint64
+	outBoxes, _ := parseMP4Boxes(out)
+	for _, b := range outBoxes {
+		if b.typ == "moof" {
+			d, _ := fragmentDecodeTime(b.payload)
+			decodeTimes = append(decodeTimes, d)
+		}
+	}
+	if expected := []uint64{0, 90000}; !reflect.DeepEqual(decodeTimes, expected) {
+		t.Errorf("got decode times %v, expected %v", decodeTimes, expected)
+	}
+	if _, d, _ := movieTime(findMP4Box(out, "moov", "mvhd")); d != 2000 {
+		t.Errorf("got movie duration %d, expected 2000", d)
+	}
+	if findMP4Box(out, "sidx") != nil {
+		t.Error("sidx of the stream is left in the output")
+	}
+}
+
+// testWebMWithCues builds a WebM of a video track with Cues pointing every cluster.
+// It returns the content and the ranges of the init and the index.
+func testWebMWithCues(clusters []testCluster, duration float64) ([]byte, byteRange, byteRange) {
+	elements, _ := parseEBMLElements(testWebM(1000000, duration, "V_VP9", false, clusters))
+	header := makeEBMLElement(ebmlIDHeader, elements[0].data)
+	children, _ := parseEBMLElements(elements[1].data)
+	var info, tracks, clusterData []byte
+	var positions []int
+	for _, c := range children {
+		switch c.id {
+		case ebmlIDInfo:
+			info = makeEBMLElement(c.id, c.data)
+		case ebmlIDTracks:
+			tracks = makeEBMLElement(c.id, c.data)
+		case ebmlIDCluster:
+			positions = append(positions, len(clusterData))
+			clusterData = append(clusterData, makeEBMLElement(c.id, c.data)...)
+		}
+	}
+
+	// positions are encoded in 4 bytes so that the length of Cues does not depend on them
+	cues := func(base int) []byte {
+		points := make([][]byte, 0, len(clusters))
+		for i, c := range clusters {
+			position := make([]byte, 4)
+			binary.BigEndian.PutUint32(position, uint32(base+positions[i]))
+			points = append(points, makeEBMLElement(ebmlIDCuePoint,
+				makeEBMLElement(ebmlIDCueTime, ebmlUintBytes(c.timecode)),
+				makeEBMLElement(ebmlIDCueTrackPositions,
+					makeEBMLElement(ebmlIDCueTrack, ebmlUintBytes(1)),
+					makeEBMLElement(ebmlIDCueClusterPosition, position),
+				),
+			))
+		}
+		return makeEBMLElement(ebmlIDCues, points...)
+	}
+	base := len(info) + len(tracks) + len(cues(0))
+	segment := append(append(append(append([]byte{}, info...), tracks...), cues(base)...), clusterData...)
+	content := append(append(header, ebmlIDBytes(ebmlIDSegment)...), ebmlUnknownSizeBytes()...)
+	segmentStart := len(content)
+	content = append(content, segment...)
+	initRange := byteRange{start: 0, end: segmentStart + len(info) + len(tracks) - 1}
+	indexRange := byteRange{start: initRange.end + 1, end: segmentStart + base - 1}
+	return content, initRange, indexRange
+}
+
+func TestDownloadRangeWebM(t *testing.T) {
+	content, initRange, indexRange := testWebMWithCues([]testCluster{
+		{0, []int16{0, 500}, []string{"v0", "v1"}},
+		{1000, []int16{0, 500}, []string{"v2", "v3"}},
+		{2000, []int16{0, 500}, []string{"v4", "v5"}},
+		{3000, []int16{0, 500}, []string{"v6", "v7"}},
+	}, 4000)
+
+	var mu sync.Mutex
+	var requested []int
+	stream := Stream{
+		url:        "https://foobar?itag=248&signature=geho",
+		Format:     "webm",
+		Retry:      &NoRetry,
+		initRange:  initRange,
+		indexRange: indexRange,
+		client:     rangeServingClient(content, nil, &requested, &mu),
+	}
+	out, err := stream.DownloadRange(1500*time.Millisecond, 2500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("download failed, %s", err)
+	}
+
+	// the init range is fetched with the index and once again
+	segments, _ := stream.segments(context.Background(), len(content))
+	if expected := []int{0, 0, segments[1].start}; !reflect.DeepEqual(requested, expected) {
+		t.Errorf("requested ranges starting at %v, expected %v", requested, expected)
+	}
+	_, blocks := webmBlocksOf(t, out)
+	expected := []testWebMBlock{{1, 0, "v2"}, {1, 500, "v3"}, {1, 1000, "v4"}, {1, 1500, "v5"}}
+	if !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("got blocks %v, expected %v", blocks, expected)
+	}
+	wi, err := parseWebMInit(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wi.duration != 2000 {
+		t.Errorf("got duration %f, expected 2000", wi.duration)
+	}
+}
+
+func TestDownloadRangeWithoutIndex(t *testing.T) {
+	stream := Stream{url: "https://foobar?itag=22&signature=geho", Format: "mp4"}
+	if _, err := stream.DownloadRange(time.Second, 2*time.Second); err == nil {
+		t.Error("download of a stream without index should fail")
+	}
+	if _, err := stream.DownloadRange(2*time.Second, time.Second); err == nil {
+		t.Error("download of an inverted range should fail")
+	}
+}
+
+func TestClipTruncatedBoxes(t *testing.T) {
+	// truncated tfdt and mehd are left as they are
+	moof := makeMP4Box("traf", makeMP4Box("tfdt", []byte{1, 0, 0, 0, 0, 0, 0, 0}))
+	setFragmentDecodeTime(moof, 10)
+	setFragmentDecodeTime(makeMP4Box("traf", makeMP4Box("tfdt")), 10)
+
+	in := &fmp4Reader{r: bytes.NewReader(testFMP4(1, 1000, 48000, 2000, "soun", nil))}
+	if err := in.readInit(1); err != nil {
+		t.Fatal(err)
+	}
+	moovBoxes, _ := parseMP4Boxes(in.moov.payload)
+	var payload []byte
+	for _, b := range moovBoxes {
+		if b.typ == "mvex" {
+			b.payload = append(b.payload, makeMP4Box("mehd")...)
+		}
+		payload = append(payload, b.bytes()...)
+	}
+	in.moov.payload = payload
+	if _, err := clipMoov(in, time.Second); err != nil {
+		t.Errorf("failed to clip moov with an empty mehd, %s", err)
+	}
+}
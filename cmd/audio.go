@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/matthewlujp/gotube"
+)
+
+// runAudioOnly saves the best audio stream as an audio file without prompting a stream
+func runAudioOnly(downloader *gotube.YoutubeDownloader) {
+	stream := gotube.BestAudio(downloader.Streams, audioFormatOf(*saveFilePath))
+	if stream == nil {
+		log.Fatalln("no audio stream found")
+	}
+
+	if *saveFilePath == "" {
+		fmt.Print("Where to save the audio?> ")
+		fmt.Scan(saveFilePath)
+	}
+	if filepath.Ext(*saveFilePath) == "" {
+		*saveFilePath += gotube.AudioExtension(stream)
+	}
+
+	fmt.Printf("Downloading %s on %s......\n", stream, *saveFilePath)
+	stream.OnProgress = printProgress
+	applyRateLimit()
+	if err := saveAudio(*saveFilePath, stream); err != nil {
+		log.Fatalf("failed to extract audio of stream %s, %s", stream, err)
+	}
+	embedMetadata(downloader, *saveFilePath)
+	writeSubs(downloader, *saveFilePath)
+	fmt.Printf("Download completed!\nWritten on %s.\nBitrate %s\n", *saveFilePath, stream.Abr)
+}
+
+// audioFormatOf returns a stream format for an extension of an audio file, "" if any format is fine
+func audioFormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".m4a", ".aac", ".mp4":
+		return "mp4"
+	case ".opus", ".ogg":
+		return "webm"
+	}
+	return ""
+}
+
+// saveAudio downloads an audio stream and writes it into an m4a or opus file
+func saveAudio(path string, stream *gotube.Stream) error {
+	f, errOpen := os.Create(path)
+	if errOpen != nil {
+		return fmt.Errorf("failed to create or open %s, %s", path, errOpen)
+	}
+	defer f.Close()
+	if err := gotube.ExtractAudio(f, stream); err != nil {
+		return fmt.Errorf("error while writing the audio to the file, %s", err)
+	}
+	return nil
+}
@@ -5,21 +5,47 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/matthewlujp/gotube"
 	"github.com/pkg/profile"
 )
 
+const (
+	maxBufferedBytes = 32 * 1024 * 1024
+)
+
 var (
 	saveFilePath *string
 	url          string
 	cpuProfile   *bool
+	resume       *bool
+	limitRate    *string
+	merge        *bool
+	audioOnly    *bool
+	embed        *bool
+	section      *string
+	subs         *bool
+	subLang      *string
+	subFormat    *string
+	listItems    *string
 )
 
 func init() {
-	usageText := "Usage: gotube [Youtube video url] [file path to save a downloaded video]"
-	saveFilePath = flag.String("s", "", "save file path")
+	usageText := "Usage: gotube [Youtube video url] [file path to save a downloaded video]\n       gotube --audio-only [-s file path] [Youtube video url]\n       gotube [--playlist-items 1-10,15] [-s directory] [Youtube playlist url]\n       gotube serve [-addr host:port]"
+	saveFilePath = flag.String("s", "", "save file path, or a directory to save videos of a playlist in")
 	cpuProfile = flag.Bool("p", false, "write cpu profile to a file under /var")
+	resume = flag.Bool("c", false, "resume an interrupted download of the save file")
+	merge = flag.Bool("m", false, "merge a video only stream with the best audio stream of the same format")
+	audioOnly = flag.Bool("audio-only", false, "save the best audio stream as m4a or opus without choosing a stream, the format follows the extension of the save file if any")
+	embed = flag.Bool("embed-metadata", false, "embed title, channel, upload date, description, source url and thumbnail into the saved file")
+	section = flag.String("section", "", "download only a section of the video such as 1:00-3:30, either end can be omitted, t= of the url sets the start if not given")
+	subs = flag.Bool("write-subs", false, "save captions next to the saved file, e.g. video.en.srt for video.mp4")
+	subLang = flag.String("sub-lang", "en", "language of captions saved with --write-subs, translated from another language if not available")
+	subFormat = flag.String("sub-format", "srt", "format of captions saved with --write-subs, srt, vtt or txt")
+	listItems = flag.String("playlist-items", "", "download only videos at these positions of a playlist such as 1-10,15, a url with both a video and a playlist is treated as the playlist if given")
+	limitRate = flag.String("limit-rate", "", "maximum download rate in bytes per second, K, M and G suffixes are allowed (e.g. 500K)")
 	flag.Parse()
 
 	if !*cpuProfile && flag.NArg() < 1 {
@@ -31,6 +57,10 @@ func init() {
 func main() {
 	if *cpuProfile {
 		runProfile()
+	} else if url == "serve" {
+		runServer(flag.Args()[1:])
+	} else if isPlaylist(url) {
+		runPlaylist()
 	} else {
 		run()
 	}
@@ -41,52 +71,82 @@ func runProfile() {
 	fmt.Println("cpu profile")
 	defer profile.Start().Stop()
 
-	streams, errFetch := getStreams("https://www.youtube.com/watch?v=09R8_2nJtjg")
+	downloader, errFetch := fetchStreams("https://www.youtube.com/watch?v=09R8_2nJtjg")
 	if errFetch != nil {
 		log.Fatalln(errFetch)
 	}
-	// data, errDownload := streams[19].Download()
-	data, errDownload := streams[19].ParallelDownload()
-	if errDownload != nil {
-		log.Fatalln(errDownload)
-	}
-	if err := save("test.mp4", data); err != nil {
+	if err := save("test.mp4", downloader.Streams[19]); err != nil {
 		log.Fatalln(err)
 	}
 }
 
 func run() {
 	// code for command line usage
-	streams, err := getStreams(url)
+	downloader, err := fetchStreams(url)
 	if err != nil {
 		log.Fatal(err)
 	}
+	start, end, clipped := sectionOf(downloader)
+	if *audioOnly {
+		runAudioOnly(downloader)
+		return
+	}
+	streams := downloader.Streams
 	streamID := printStreamsAndPrompt(streams) // make user choose a stream
 
-	// download a designated stream
 	stream := streams[streamID]
-	fmt.Printf("Downloading %d th stream, %s ......", streamID, stream)
-	// data, errDownload := stream.Download()
-	data, errDownload := stream.ParallelDownload()
-	if errDownload != nil {
-		log.Fatalf("failed to download stream %s, %s", stream, errDownload)
+	if clipped && *section == "" && !stream.CanClip() {
+		fmt.Printf("Start time %s of the url is ignored since the stream cannot be clipped, the whole video is downloaded.\n", start)
+		clipped = false
 	}
-	fmt.Println("Downloaded")
 
 	// make user to input save file path if not designated as a commandline flag
 	if *saveFilePath == "" {
 		fmt.Print("Where to save the video?> ")
 		fmt.Scan(saveFilePath)
 	}
-	fmt.Printf("Saving on %s......", *saveFilePath)
 
-	if err := save(*saveFilePath, data); err != nil {
-		log.Fatalln(err)
+	// download a designated stream directly into the file
+	fmt.Printf("Downloading %d th stream, %s on %s......\n", streamID, stream, *saveFilePath)
+	stream.OnProgress = printProgress
+	applyRateLimit()
+	if *merge {
+		audio := gotube.BestAudio(streams, stream.Format)
+		if audio == nil {
+			log.Fatalf("no %s audio stream to merge", stream.Format)
+		}
+		fmt.Printf("Merging with %s......\n", audio)
+		if err := saveMerged(*saveFilePath, stream, audio); err != nil {
+			log.Fatalf("failed to merge stream %s and %s, %s", stream, audio, err)
+		}
+	} else if clipped {
+		if err := saveSection(*saveFilePath, stream, start, end); err != nil {
+			log.Fatalf("failed to download a section of stream %s, %s", stream, err)
+		}
+	} else if err := save(*saveFilePath, stream); err != nil {
+		log.Fatalf("failed to download stream %s, %s", stream, err)
 	}
+	embedMetadata(downloader, *saveFilePath)
+	writeSubs(downloader, *saveFilePath)
 	fmt.Printf("Download completed!\nWritten on %s.\nBitrate %s, FPS %s, Resolution %s\n", *saveFilePath, stream.Abr, stream.Fps, stream.Resolution)
+	if retries := stream.Retries(); retries > 0 {
+		fmt.Printf("%d failed requests were retried.\n", retries)
+	}
 }
 
-func getStreams(url string) ([]*gotube.Stream, error) {
+// applyRateLimit sets the global rate limit given by --limit-rate
+func applyRateLimit() {
+	if *limitRate == "" {
+		return
+	}
+	rate, err := parseRate(*limitRate)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	gotube.SetGlobalRateLimit(rate)
+}
+
+func fetchStreams(url string) (*gotube.YoutubeDownloader, error) {
 	downloader, errNewPlayer := gotube.NewDownloader(url)
 	if errNewPlayer != nil {
 		return nil, errNewPlayer
@@ -94,13 +154,17 @@ func getStreams(url string) ([]*gotube.Stream, error) {
 	if err := downloader.FetchStreams(); err != nil {
 		return nil, err
 	}
-	return downloader.Streams, nil
+	return downloader, nil
 }
 
 func printStreamsAndPrompt(streams []*gotube.Stream) int {
 	fmt.Println("Fetched streams:\nID    Stream info")
 	for i, s := range streams {
-		fmt.Printf("%d --- %s\n", i, s)
+		if s.ContentLength > 0 {
+			fmt.Printf("%d --- %s %s\n", i, s, formatBytes(float64(s.ContentLength)))
+		} else {
+			fmt.Printf("%d --- %s\n", i, s)
+		}
 	}
 
 	fmt.Print("Choose stream ID> ")
@@ -110,13 +174,42 @@ func printStreamsAndPrompt(streams []*gotube.Stream) int {
 	return streamID
 }
 
-func save(path string, data []byte) error {
+// parseRate converts a rate such as 500K or 2M into bytes per second
+func parseRate(rate string) (int, error) {
+	number := rate
+	multiplier := 1
+	switch strings.ToUpper(rate[len(rate)-1:]) {
+	case "K":
+		multiplier = 1024
+	case "M":
+		multiplier = 1024 * 1024
+	case "G":
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 {
+		number = rate[:len(rate)-1]
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %s", rate)
+	}
+	return int(n * float64(multiplier)), nil
+}
+
+// save downloads a stream and writes it to the file as data arrives
+// With -c option, finished ranges are recorded so that the download can be resumed.
+func save(path string, stream *gotube.Stream) error {
+	if *resume {
+		return stream.ResumableDownload(path)
+	}
+
 	f, errOpen := os.Create(path)
 	if errOpen != nil {
 		return fmt.Errorf("failed to create or open %s, %s", path, errOpen)
 	}
-	if _, err := f.Write(data); err != nil {
-		return fmt.Errorf("error while writing the downloader video to the file, %s", err)
+	defer f.Close()
+	if err := stream.ParallelDownloadTo(f, maxBufferedBytes); err != nil {
+		return fmt.Errorf("error while writing the downloaded video to the file, %s", err)
 	}
 	return nil
 }
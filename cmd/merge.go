@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/matthewlujp/gotube"
+)
+
+// saveMerged downloads a video stream and an audio stream and writes them into one file
+func saveMerged(path string, video, audio *gotube.Stream) error {
+	var mux func(io.Writer, *gotube.Stream, *gotube.Stream) error
+	switch video.Format {
+	case "mp4":
+		mux = gotube.MuxMP4
+	case "webm":
+		mux = gotube.MuxWebM
+	default:
+		return fmt.Errorf("merging %s streams is not supported", video.Format)
+	}
+
+	f, errOpen := os.Create(path)
+	if errOpen != nil {
+		return fmt.Errorf("failed to create or open %s, %s", path, errOpen)
+	}
+	defer f.Close()
+	if err := mux(f, video, audio); err != nil {
+		return fmt.Errorf("error while writing the merged video to the file, %s", err)
+	}
+	return nil
+}
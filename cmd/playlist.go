@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/matthewlujp/gotube"
+)
+
+// unsafeFileNameChars are replaced in titles used as file names
+var unsafeFileNameChars = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
+
+// isPlaylist tells whether the url is downloaded as a playlist,
+// which is the case for a url of a playlist without a video, or any url with the list parameter with --playlist-items
+func isPlaylist(url string) bool {
+	if _, err := gotube.ParsePlaylistID(url); err != nil {
+		return false
+	}
+	_, errVideo := gotube.ParseVideoID(url)
+	return errVideo != nil || *listItems != ""
+}
+
+// runPlaylist saves videos of a playlist without prompting, into the directory given by -s.
+// Files are named after the index and the title, e.g. "03 - title.mp4".
+func runPlaylist() {
+	playlist, err := gotube.NewPlaylistDownloader(url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := playlist.FetchEntries(); err != nil {
+		log.Fatal(err)
+	}
+	entries, err := playlist.SelectEntries(*listItems)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(entries) == 0 {
+		log.Fatalf("no video to download in playlist %s", playlist.Title)
+	}
+
+	dir := *saveFilePath
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalf("failed to create %s, %s", dir, err)
+	}
+	width := len(strconv.Itoa(entries[len(entries)-1].Index))
+	applyRateLimit()
+
+	fmt.Printf("Downloading %d videos of %s by %s......\n", len(entries), playlist.Title, playlist.Author)
+	var failed, skipped int
+	for _, entry := range entries {
+		if !entry.Available {
+			fmt.Printf("Skipping %d %s, which is private or deleted.\n", entry.Index, entry.Title)
+			skipped++
+			continue
+		}
+		name := fmt.Sprintf("%0*d - %s", width, entry.Index, fileName(entry))
+		if err := savePlaylistEntry(playlist, entry, filepath.Join(dir, name)); err != nil {
+			log.Printf("failed to download %d %s, %s", entry.Index, entry.Title, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d videos are not downloaded", failed, len(entries))
+	}
+	fmt.Printf("Download completed!\n%d videos are written in %s.\n", len(entries)-skipped, dir)
+}
+
+// savePlaylistEntry saves a video of a playlist on a path without an extension, which is added according to the saved stream
+func savePlaylistEntry(playlist *gotube.PlaylistDownloader, entry *gotube.PlaylistEntry, path string) error {
+	downloader, err := playlist.Downloader(entry)
+	if err != nil {
+		return err
+	}
+	if err := downloader.FetchStreams(); err != nil {
+		return err
+	}
+
+	streams := downloader.Streams
+	if *audioOnly {
+		stream := gotube.BestAudio(streams, "")
+		if stream == nil {
+			return errors.New("no audio stream found")
+		}
+		path += gotube.AudioExtension(stream)
+		fmt.Printf("Downloading %s on %s......\n", stream, path)
+		stream.OnProgress = printProgress
+		if err := saveAudio(path, stream); err != nil {
+			return err
+		}
+	} else {
+		stream, audio := bestVideo(streams, *merge)
+		if stream == nil {
+			return errors.New("no video stream found")
+		}
+		path += "." + stream.Format
+		fmt.Printf("Downloading %s on %s......\n", stream, path)
+		stream.OnProgress = printProgress
+		if audio != nil {
+			err = saveMerged(path, stream, audio)
+		} else {
+			err = save(path, stream)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	embedMetadata(downloader, path)
+	writeSubs(downloader, path)
+	return nil
+}
+
+// bestVideo returns the stream of video and audio with the highest resolution.
+// With merge, a video only stream is chosen together with the best audio stream of the same format if any.
+func bestVideo(streams []*gotube.Stream, merge bool) (video, audio *gotube.Stream) {
+	bestHeight := -1
+	for _, s := range streams {
+		if s.MediaType != "video" || s.Is3D {
+			continue
+		}
+		var a *gotube.Stream
+		if s.AudioCodec == "" {
+			if !merge {
+				continue
+			}
+			if a = gotube.BestAudio(streams, s.Format); a == nil {
+				continue
+			}
+		}
+		height, err := strconv.Atoi(strings.TrimSuffix(s.Resolution, "p"))
+		if err != nil {
+			height = 0
+		}
+		if height > bestHeight {
+			video, audio, bestHeight = s, a, height
+		}
+	}
+	return video, audio
+}
+
+// fileName returns the title of an entry usable as a file name, or the id if the title is empty
+func fileName(entry *gotube.PlaylistEntry) string {
+	name := strings.TrimSpace(unsafeFileNameChars.Replace(entry.Title))
+	if name == "" {
+		return entry.ID
+	}
+	return name
+}
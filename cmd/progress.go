@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matthewlujp/gotube"
+)
+
+const (
+	progressBarWidth = 30
+)
+
+// printProgress draws a progress bar on one line, it is redrawn every call
+func printProgress(p gotube.Progress) {
+	line := fmt.Sprintf("%s %s", formatBytes(float64(p.CompletedBytes)), formatBytes(p.Speed)+"/s")
+	if p.TotalBytes > 0 {
+		ratio := float64(p.CompletedBytes) / float64(p.TotalBytes)
+		if ratio > 1 {
+			ratio = 1
+		}
+		filled := int(ratio * progressBarWidth)
+		bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+		line = fmt.Sprintf("[%s] %5.1f%% %s/%s %s/s", bar, ratio*100, formatBytes(float64(p.CompletedBytes)), formatBytes(float64(p.TotalBytes)), formatBytes(p.Speed))
+	}
+	if p.ETA > 0 {
+		line += fmt.Sprintf(" ETA %s", p.ETA.Round(time.Second))
+	}
+	if p.ChunksFailed > 0 {
+		line += fmt.Sprintf(" %d failed", p.ChunksFailed)
+	}
+
+	// pad with spaces to erase a longer previous line
+	fmt.Printf("\r%-80s", line)
+	if p.Done {
+		fmt.Println()
+	}
+}
+
+// formatBytes returns a human readable size such as 12.3MB
+func formatBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f%s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", n, units[i])
+}
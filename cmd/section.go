@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matthewlujp/gotube"
+)
+
+// sectionOf returns a range to download given by --section or t= of the url, ok is false for the whole video
+func sectionOf(downloader *gotube.YoutubeDownloader) (start, end time.Duration, ok bool) {
+	if *section != "" {
+		if *merge || *resume || *audioOnly {
+			log.Fatalln("--section cannot be used with -m, -c or --audio-only")
+		}
+		start, end, err := parseSection(*section)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return start, end, true
+	}
+	if start = downloader.StartTime(); start > 0 {
+		if *merge || *resume || *audioOnly {
+			fmt.Printf("Start time %s of the url is ignored with -m, -c or --audio-only.\n", start)
+			return 0, 0, false
+		}
+		fmt.Printf("Starting at %s designated in the url.\n", start)
+		return start, 0, true
+	}
+	return 0, 0, false
+}
+
+// parseSection parses a range such as 1:00-3:30, either end of which can be omitted (e.g. 1:00- till the end)
+func parseSection(v string) (time.Duration, time.Duration, error) {
+	parts := strings.Split(v, "-")
+	if len(parts) != 2 || (parts[0] == "" && parts[1] == "") {
+		return 0, 0, fmt.Errorf("invalid section %s, expected start-end such as 1:00-3:30", v)
+	}
+	var start, end time.Duration
+	var err error
+	if parts[0] != "" {
+		if start, err = parseTimestamp(parts[0]); err != nil {
+			return 0, 0, err
+		}
+	}
+	if parts[1] != "" {
+		if end, err = parseTimestamp(parts[1]); err != nil {
+			return 0, 0, err
+		}
+		if end <= start {
+			return 0, 0, fmt.Errorf("invalid section %s, end is not after start", v)
+		}
+	}
+	return start, end, nil
+}
+
+// parseTimestamp parses a time in [[hours:]minutes:]seconds, seconds can have a fraction
+func parseTimestamp(v string) (time.Duration, error) {
+	fields := strings.Split(v, ":")
+	if len(fields) > 3 {
+		return 0, fmt.Errorf("invalid time %s", v)
+	}
+	var seconds float64
+	for i, f := range fields {
+		n, err := strconv.ParseFloat(f, 64)
+		if err != nil || n < 0 || (i < len(fields)-1 && n != float64(int(n))) {
+			return 0, fmt.Errorf("invalid time %s", v)
+		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// saveSection downloads a range of a stream into a standalone file
+func saveSection(path string, stream *gotube.Stream, start, end time.Duration) error {
+	f, errOpen := os.Create(path)
+	if errOpen != nil {
+		return fmt.Errorf("failed to create or open %s, %s", path, errOpen)
+	}
+	defer f.Close()
+	if err := stream.DownloadRangeTo(f, start, end); err != nil {
+		return fmt.Errorf("error while writing the section to the file, %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/matthewlujp/gotube"
+)
+
+// runServer serves streams at http://{addr}/v/{videoID}/{itag} until the process is killed
+func runServer(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "address to listen on")
+	flags.Parse(args)
+
+	applyRateLimit()
+
+	log.Printf("serving streams at http://%s/v/{video id}/{itag}", *addr)
+	log.Printf("DASH manifests are at http://%s/v/{video id}/manifest.mpd", *addr)
+	log.Fatalln(http.ListenAndServe(*addr, gotube.NewServer(nil)))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/matthewlujp/gotube"
+)
+
+// writeSubs saves captions of --sub-lang next to the saved video if --write-subs is given,
+// e.g. video.en.srt for video.mp4.
+// A failure is only reported since the video itself is fine.
+func writeSubs(downloader *gotube.YoutubeDownloader, videoPath string) {
+	if !*subs {
+		return
+	}
+	write, ok := gotube.CaptionFormats[*subFormat]
+	if !ok {
+		log.Printf("unknown subtitle format %s, one of srt, vtt and txt is expected", *subFormat)
+		return
+	}
+	tracks, err := downloader.Captions()
+	if err != nil {
+		log.Printf("failed to get caption tracks, %s", err)
+		return
+	}
+	track, err := chooseCaptionTrack(tracks, *subLang)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	captions, err := track.Download()
+	if err != nil {
+		log.Printf("failed to download captions of %s, %s", *subLang, err)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if err := write(buf, captions); err != nil {
+		log.Printf("failed to convert captions, %s", err)
+		return
+	}
+	path := fmt.Sprintf("%s.%s.%s", strings.TrimSuffix(videoPath, filepath.Ext(videoPath)), *subLang, *subFormat)
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		log.Printf("failed to write captions, %s", err)
+		return
+	}
+	fmt.Printf("Captions of %s are written on %s.\n", track.Name, path)
+}
+
+// chooseCaptionTrack returns a track of a language, preferring one written by a person to an auto-generated one.
+// A translatable track is translated if none is in the language.
+func chooseCaptionTrack(tracks []*gotube.CaptionTrack, lang string) (*gotube.CaptionTrack, error) {
+	var auto, translatable *gotube.CaptionTrack
+	for _, t := range tracks {
+		switch {
+		case t.LanguageCode == lang && !t.AutoGenerated:
+			return t, nil
+		case t.LanguageCode == lang && auto == nil:
+			auto = t
+		case t.Translatable && (translatable == nil || translatable.AutoGenerated && !t.AutoGenerated):
+			translatable = t
+		}
+	}
+	if auto != nil {
+		return auto, nil
+	}
+	if translatable != nil {
+		return translatable.Translate(lang)
+	}
+	return nil, fmt.Errorf("no captions of %s", lang)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log"
+
+	"github.com/matthewlujp/gotube"
+)
+
+// embedMetadata writes metadata of the video into the saved file if --embed-metadata is given.
+// A failure is only reported since the file itself is fine.
+func embedMetadata(downloader *gotube.YoutubeDownloader, path string) {
+	if !*embed {
+		return
+	}
+	m, err := downloader.MetadataWithThumbnail()
+	if err != nil {
+		log.Printf("failed to get metadata, %s", err)
+		return
+	}
+	if err := gotube.Tag(path, m); err != nil {
+		log.Printf("failed to embed metadata into %s, %s", path, err)
+	}
+}
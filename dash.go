@@ -0,0 +1,167 @@
+package gotube
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	mpdNamespace     = "urn:mpeg:dash:schema:mpd:2011"
+	mpdProfile       = "urn:mpeg:dash:profile:isoff-on-demand:2011"
+	mpdMinBufferTime = 2 * time.Second
+)
+
+// mpd is the root of a DASH manifest of a static presentation of SegmentBase representations
+type mpd struct {
+	XMLName                   xml.Name    `xml:"MPD"`
+	Namespace                 string      `xml:"xmlns,attr"`
+	Profiles                  string      `xml:"profiles,attr"`
+	Type                      string      `xml:"type,attr"`
+	MediaPresentationDuration string      `xml:"mediaPresentationDuration,attr,omitempty"`
+	MinBufferTime             string      `xml:"minBufferTime,attr"`
+	Period                    []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ContentType         string              `xml:"contentType,attr"`
+	MimeType            string              `xml:"mimeType,attr"`
+	SubsegmentAlignment bool                `xml:"subsegmentAlignment,attr"`
+	Representations     []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID          string         `xml:"id,attr"`
+	Codecs      string         `xml:"codecs,attr,omitempty"`
+	Bandwidth   int            `xml:"bandwidth,attr"`
+	Width       int            `xml:"width,attr,omitempty"`
+	Height      int            `xml:"height,attr,omitempty"`
+	FrameRate   int            `xml:"frameRate,attr,omitempty"`
+	BaseURL     string         `xml:"BaseURL"`
+	SegmentBase mpdSegmentBase `xml:"SegmentBase"`
+}
+
+type mpdSegmentBase struct {
+	IndexRange     string            `xml:"indexRange,attr"`
+	Initialization mpdInitialization `xml:"Initialization"`
+}
+
+type mpdInitialization struct {
+	Range string `xml:"range,attr"`
+}
+
+// DASHManifest returns an MPD document listing adaptive streams, those with a container index, of the video.
+// Streams are grouped into an AdaptationSet for each media type and format, and refer to download urls as BaseURL.
+// FetchStreams must be called beforehand.
+// The urls expire in hours, so serve streams with Server for a long playback.
+func (dl *YoutubeDownloader) DASHManifest() ([]byte, error) {
+	if dl.Streams == nil {
+		return nil, errors.New("streams are not fetched yet")
+	}
+	return dashManifest(dl.Streams, func(s *Stream) (string, error) {
+		return s.getDownloadURL()
+	})
+}
+
+// dashManifest encodes an MPD document of adaptive streams whose BaseURL is given by baseURL
+func dashManifest(streams []*Stream, baseURL func(*Stream) (string, error)) ([]byte, error) {
+	var sets []mpdAdaptationSet
+	var duration time.Duration
+	for _, s := range streams {
+		if !s.hasIndex() || s.initRange.end >= s.indexRange.start {
+			continue
+		}
+		u, err := baseURL(s)
+		if err != nil {
+			logger.printf("stream %d is not listed in the manifest, %s", s.itag, err)
+			continue
+		}
+		if s.Duration > duration {
+			duration = s.Duration
+		}
+
+		mimeType := fmt.Sprintf("%s/%s", s.MediaType, s.Format)
+		var set *mpdAdaptationSet
+		for i := range sets {
+			if sets[i].MimeType == mimeType {
+				set = &sets[i]
+			}
+		}
+		if set == nil {
+			sets = append(sets, mpdAdaptationSet{ContentType: s.MediaType, MimeType: mimeType, SubsegmentAlignment: true})
+			set = &sets[len(sets)-1]
+		}
+
+		codecs := s.VideoCodec
+		if s.MediaType == "audio" {
+			codecs = s.AudioCodec
+		}
+		set.Representations = append(set.Representations, mpdRepresentation{
+			ID:        strconv.Itoa(s.itag),
+			Codecs:    codecs,
+			Bandwidth: dashBandwidth(s),
+			Width:     s.width,
+			Height:    s.height,
+			FrameRate: s.frameRate,
+			BaseURL:   u,
+			SegmentBase: mpdSegmentBase{
+				IndexRange:     fmt.Sprintf("%d-%d", s.indexRange.start, s.indexRange.end),
+				Initialization: mpdInitialization{Range: fmt.Sprintf("%d-%d", s.initRange.start, s.initRange.end)},
+			},
+		})
+	}
+	if len(sets) == 0 {
+		return nil, errors.New("no adaptive stream found")
+	}
+
+	// video before audio, and representations in ascending order of bandwidth
+	sort.SliceStable(sets, func(i, j int) bool { return sets[i].ContentType > sets[j].ContentType })
+	for _, set := range sets {
+		reps := set.Representations
+		sort.SliceStable(reps, func(i, j int) bool { return reps[i].Bandwidth < reps[j].Bandwidth })
+	}
+
+	m := mpd{
+		Namespace:     mpdNamespace,
+		Profiles:      mpdProfile,
+		Type:          "static",
+		MinBufferTime: xsDuration(mpdMinBufferTime),
+		Period:        []mpdPeriod{{AdaptationSets: sets}},
+	}
+	if duration > 0 {
+		m.MediaPresentationDuration = xsDuration(duration)
+	}
+	data, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// xsDuration formats a duration in xs:duration such as PT279.5S
+func xsDuration(d time.Duration) string {
+	return "PT" + strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S"
+}
+
+// dashBandwidth returns bits per second of a stream for its representation.
+// The bitrate of the itag table or an estimate from the size and the duration is used if the stream does not report it.
+func dashBandwidth(s *Stream) int {
+	if s.MediaType == "audio" {
+		if bitrate := audioBitrate(s); bitrate > 0 {
+			return bitrate
+		}
+	} else if s.bitrate > 0 {
+		return s.bitrate
+	}
+	if s.ContentLength > 0 && s.Duration > 0 {
+		return int(float64(s.ContentLength) * 8 / s.Duration.Seconds())
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package gotube
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// dashTestStreams returns adaptive streams of two mp4 videos, a webm video and an mp4 audio, and a progressive stream
+func dashTestStreams(t *testing.T) []*Stream {
+	var streams []*Stream
+	for _, info := range []map[string]string{
+		{"itag": "137", "type": "video/mp4; codecs=\"avc1.640028\"", "bitrate": "4400000", "size": "1920x1080", "fps": "30", "init": "0-708", "index": "709-1412", "duration": "279"},
+		{"itag": "136", "type": "video/mp4; codecs=\"avc1.4d401f\"", "bitrate": "1100000", "size": "1280x720", "fps": "30", "init": "0-707", "index": "708-1411", "duration": "279"},
+		{"itag": "140", "type": "audio/mp4; codecs=\"mp4a.40.2\"", "bitrate": "128000", "init": "0-591", "index": "592-963", "duration": "280"},
+		{"itag": "248", "type": "video/webm; codecs=\"vp9\"", "bitrate": "3000000", "size": "1920x1080", "fps": "30", "init": "0-242", "index": "243-1044", "duration": "279"},
+		{"itag": "22", "type": "video/mp4; codecs=\"avc1.64001F, mp4a.40.2\"", "duration": "279"},
+	} {
+		info["url"] = "https://foobar/videoplayback?itag=" + info["itag"] + "&signature=geho"
+		s, err := newStream(info, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams = append(streams, s)
+	}
+	return streams
+}
+
+func TestDASHManifest(t *testing.T) {
+	dl := &YoutubeDownloader{Streams: dashTestStreams(t)}
+	data, err := dl.DASHManifest()
+	if err != nil {
+		t.Fatalf("failed to generate manifest, %s", err)
+	}
+	if !strings.HasPrefix(string(data), xml.Header) {
+		t.Error("no xml declaration")
+	}
+	var m mpd
+	if err := xml.Unmarshal(data, &m); err != nil {
+		t.Fatalf("invalid manifest, %s", err)
+	}
+	if m.Type != "static" || m.Profiles != mpdProfile || m.MediaPresentationDuration != "PT280S" {
+		t.Errorf("got MPD of type %s, profiles %s and duration %s", m.Type, m.Profiles, m.MediaPresentationDuration)
+	}
+	if len(m.Period) != 1 {
+		t.Fatalf("got %d periods, expected 1", len(m.Period))
+	}
+
+	sets := m.Period[0].AdaptationSets
+	var mimeTypes []string
+	for _, set := range sets {
+		mimeTypes = append(mimeTypes, set.MimeType)
+	}
+	if expected := []string{"video/mp4", "video/webm", "audio/mp4"}; !reflect.DeepEqual(mimeTypes, expected) {
+		t.Fatalf("got adaptation sets %v, expected %v", mimeTypes, expected)
+	}
+
+	// the progressive stream is not listed, and representations are in ascending order of bandwidth
+	expected := []mpdRepresentation{
+		{
+			ID: "136", Codecs: "avc1.4d401f", Bandwidth: 1100000, Width: 1280, Height: 720, FrameRate: 30,
+			BaseURL:     "https://foobar/videoplayback?itag=136&signature=geho",
+			SegmentBase: mpdSegmentBase{IndexRange: "708-1411", Initialization: mpdInitialization{Range: "0-707"}},
+		},
+		{
+			ID: "137", Codecs: "avc1.640028", Bandwidth: 4400000, Width: 1920, Height: 1080, FrameRate: 30,
+			BaseURL:     "https://foobar/videoplayback?itag=137&signature=geho",
+			SegmentBase: mpdSegmentBase{IndexRange: "709-1412", Initialization: mpdInitialization{Range: "0-708"}},
+		},
+	}
+	if !reflect.DeepEqual(sets[0].Representations, expected) {
+		t.Errorf("got representations %+v, expected %+v", sets[0].Representations, expected)
+	}
+	audio := sets[2].Representations
+	if len(audio) != 1 || audio[0].ID != "140" || audio[0].Codecs != "mp4a.40.2" || audio[0].Width != 0 {
+		t.Errorf("got audio representations %+v", audio)
+	}
+	if strings.Contains(string(data), "width=\"0\"") {
+		t.Error("zero width is written for audio")
+	}
+}
+
+func TestDASHBandwidthWithoutBitrate(t *testing.T) {
+	streams := dashTestStreams(t)
+	video, audio := streams[0], streams[2]
+	video.bitrate, audio.bitrate = 0, 0
+	video.ContentLength = 279 * 550000
+
+	if bandwidth := dashBandwidth(video); bandwidth != 4400000 {
+		t.Errorf("got bandwidth %d of video, expected 4400000 estimated from the size", bandwidth)
+	}
+	if bandwidth := dashBandwidth(audio); bandwidth != 128000 {
+		t.Errorf("got bandwidth %d of audio, expected 128000 of the itag table", bandwidth)
+	}
+	manifest, err := (&YoutubeDownloader{Streams: streams}).DASHManifest()
+	if err != nil {
+		t.Fatalf("failed to generate manifest, %s", err)
+	}
+	if strings.Contains(string(manifest), `bandwidth="0"`) {
+		t.Errorf("bandwidth 0 in manifest\n%s", manifest)
+	}
+
+	audio.Abr = ""
+	if bandwidth := dashBandwidth(audio); bandwidth != 0 {
+		t.Errorf("got bandwidth %d of audio, expected 0 without its size", bandwidth)
+	}
+}
+
+func TestDASHManifestWithoutAdaptiveStreams(t *testing.T) {
+	if _, err := (&YoutubeDownloader{}).DASHManifest(); err == nil {
+		t.Error("manifest is generated before streams are fetched")
+	}
+	dl := &YoutubeDownloader{Streams: dashTestStreams(t)[4:]}
+	if _, err := dl.DASHManifest(); err == nil {
+		t.Error("manifest is generated without adaptive streams")
+	}
+}
@@ -2,17 +2,20 @@ package gotube
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	htmlpkg "html"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
 	logger                         *errorLogger
-	youtubeWatchURLPattern         = regexp.MustCompile(`https?://www.youtube.com/watch\?v=([\w-]{11})`)
 	jsURLRegex                     = regexp.MustCompile(`.\"js\":\"(.+?)\"`)
 	ageRestrictedJsURLRegex        = regexp.MustCompile(`;yt\.setConfig\(\{\'PLAYER_CONFIG\':\s*{.+?"js":"(.+?)"}.+?}(,\'EXPERIMENT_FLAGS\'|;)`)
 	titleRegex                     = regexp.MustCompile(`"title":"(.+?)","`)
@@ -23,52 +26,91 @@ var (
 	ageRestrictedAdaptiveFmtsRegex = regexp.MustCompile(`adaptive_fmts=(.+?)&`)
 	ageRestrictedURLFmtsRegex      = regexp.MustCompile(`url_encoded_fmt_stream_map=(.+?)&`)
 	stsRegex                       = regexp.MustCompile(`"sts"\s*:\s*(\d+)`)
-	videoIDRegex                   = regexp.MustCompile(`watch\?v=([\w-]{11})`)
+	authorRegex                    = regexp.MustCompile(`"author":"(.+?)"`)
+	uploadDateRegex                = regexp.MustCompile(`itemprop="datePublished" content="(.+?)"`)
+	descriptionRegex               = regexp.MustCompile(`(?s)<p id="eow-description"[^>]*>(.*?)</p>`)
+	metaDescriptionRegex           = regexp.MustCompile(`<meta name="description" content="(.*?)">`)
+	brRegex                        = regexp.MustCompile(`<br\s*/?>`)
+	htmlTagRegex                   = regexp.MustCompile(`<[^>]+>`)
+	playerResponseRegex            = regexp.MustCompile(`"player_response":"((?:[^"\\]|\\.)*)"`)
+	ageRestrictedPlayerRespRegex   = regexp.MustCompile(`(?:^|&)player_response=([^&]*)`)
 )
 
 // YoutubeDownloader collects information of a Youtube video and fetches streams of it.
 type YoutubeDownloader struct {
-	client  client
-	Streams []*Stream // accessable
-	url     string
-	title   string
+	retries     int64 // accessed atomically, kept first for 64-bit alignment
+	client      client
+	Streams     []*Stream    // accessable
+	Retry       *RetryPolicy // policy for failed requests, also set to fetched streams, DefaultRetryPolicy is used if nil
+	Pool        *WorkerPool  // pool set to fetched streams, DefaultWorkerPool is used if nil
+	RateLimiter *RateLimiter // limiter set to fetched streams
+	url         string
+	metadata    *Metadata
+	info        *VideoInfo
+	captions    []*CaptionTrack
+	startTime   time.Duration
 }
 
-// NewDownloader returns a instance which implements YoutubeDownloader according to a given url
-func NewDownloader(url string) (*YoutubeDownloader, error) {
-	bURL := []byte(url)
-	if youtubeWatchURLPattern.Match(bURL) {
-		return &YoutubeDownloader{client: &youtubeClient{}, url: url}, nil
+// NewDownloader returns a instance which implements YoutubeDownloader according to a given url.
+// Any url accepted by ParseVideoID is normalized to the canonical watch url, and t= of it is kept as StartTime.
+func NewDownloader(videoURL string) (*YoutubeDownloader, error) {
+	videoID, err := ParseVideoID(videoURL)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("unexpected URL format %s", url)
+	return &YoutubeDownloader{client: &youtubeClient{}, url: CanonicalWatchURL(videoID), startTime: startTimeOf(videoURL)}, nil
 }
 
 // FetchStreams build Stream instances based on information collected
 // By using one of obtained Stream instances, video can be downloaded.
 func (dl *YoutubeDownloader) FetchStreams() error {
-	videoData, errExtractData := dl.extractData() // extract title, jsURL, and string form streams
+	return dl.FetchStreamsContext(context.Background())
+}
+
+// FetchStreamsContext is FetchStreams with a context.
+// Requests for the page and the player script are canceled when ctx is done.
+func (dl *YoutubeDownloader) FetchStreamsContext(ctx context.Context) error {
+	videoData, pr, streamInfos, errExtractData := dl.extractData(ctx) // extract title, jsURL, and streams
 	if errExtractData != nil {
+		if videoData != nil {
+			dl.info = newVideoInfo(videoData, pr)
+		}
 		return errExtractData
 	}
-	dl.title = videoData["title"]
+	dl.metadata = &Metadata{
+		Title:       videoData["title"],
+		Author:      videoData["author"],
+		UploadDate:  videoData["upload_date"],
+		Description: videoData["description"],
+		URL:         CanonicalWatchURL(videoData["video_id"]),
+		videoID:     videoData["video_id"],
+	}
+	dl.info = newVideoInfo(videoData, pr)
+	dl.captions = pr.captionTracks(dl)
 
 	// download js script and build a decipherer instance
 	var deci decipherer
-	if js, err := dl.getResource(videoData["jsURL"]); err == nil {
+	if js, err := dl.getResource(ctx, videoData["jsURL"]); err == nil {
 		if d, err := newDecipherer(js); err == nil {
 			deci = d
 		}
 	}
 
 	// create stream instances
-	stringStreams := strings.Split(videoData["streams"], ",")
-	dl.Streams = make([]*Stream, 0, len(stringStreams))
-	for _, ss := range stringStreams {
-		stream, errBuildStream := inflateStream(ss, dl.client, deci, videoData)
+	dl.Streams = make([]*Stream, 0, len(streamInfos))
+	for _, info := range streamInfos {
+		for k, v := range videoData {
+			info[k] = v
+		}
+		stream, errBuildStream := newStream(info, dl.client, deci)
 		if errBuildStream != nil {
 			logger.printf("%s", errBuildStream)
 			continue
 		}
+		stream.Retry = dl.Retry
+		stream.Pool = dl.Pool
+		stream.RateLimiter = dl.RateLimiter
+		stream.refresh = dl.refresher(stream.itag)
 		dl.Streams = append(dl.Streams, stream)
 	}
 
@@ -78,77 +120,148 @@ func (dl *YoutubeDownloader) FetchStreams() error {
 	return nil
 }
 
-func (dl *YoutubeDownloader) getResource(url string) ([]byte, error) {
-	res, errGet := dl.client.Get(url)
-	if errGet != nil {
-		return nil, fmt.Errorf("request to %s failed, %s", dl.url, errGet)
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("request to %s got status %s", dl.url, res.Status)
-	}
+// getResource get resource and return its content
+// Failed requests are retried according to the retry policy.
+func (dl *YoutubeDownloader) getResource(ctx context.Context, url string) ([]byte, error) {
+	return readResource(ctx, dl.retryPolicy(), &dl.retries, url, func() (*http.Response, error) {
+		return dl.client.Get(ctx, url)
+	})
+}
 
+// readResource sends a request to url with send and returns the body.
+// Failed requests are retried according to p, and the number of retries is added to retries.
+func readResource(ctx context.Context, p *RetryPolicy, retries *int64, url string, send func() (*http.Response, error)) ([]byte, error) {
 	buf := new(bytes.Buffer)
-	if _, err := buf.ReadFrom(res.Body); err != nil {
-		return nil, fmt.Errorf("read html failed, %s", err)
+	err := p.do(ctx, retries, func() error {
+		buf.Reset()
+		res, errGet := send()
+		if errGet != nil {
+			return fmt.Errorf("request to %s failed, %s", url, errGet)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return newStatusError(url, res)
+		}
+
+		if _, err := buf.ReadFrom(res.Body); err != nil {
+			return fmt.Errorf("read html failed, %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return buf.Bytes(), nil
 }
 
-func (dl *YoutubeDownloader) extractData() (map[string]string, error) {
-	html, errGetHTML := dl.getResource(dl.url)
+// extractData returns information of the video, the player response and infos of streams in the form newStream takes.
+// Streams are read from the player response, or from adaptive_fmts and url_encoded_fmt_stream_map of older page layouts.
+// Information of the video is returned with the error for a video which is not playable.
+func (dl *YoutubeDownloader) extractData(ctx context.Context) (map[string]string, *playerResponse, []map[string]string, error) {
+	html, errGetHTML := dl.getResource(ctx, dl.url)
 	if errGetHTML != nil {
-		return nil, errGetHTML
+		return nil, nil, nil, errGetHTML
 	}
 
-	videoData := make(map[string]string)
-
 	videoID, errVideoID := dl.extractVideoID()
 	if errVideoID != nil {
-		return nil, errVideoID
+		return nil, nil, nil, errVideoID
+	}
+
+	playerResponse := findInitialPlayerResponse(html)
+	pr := parsePlayerResponse(playerResponse)
+	streams := pr.streamInfos()
+	if err := pr.playable(); err != nil && len(streams) == 0 {
+		// information such as being upcoming is given without streams
+		return describeVideo(videoID, pr, html, nil, nil, false), pr, nil, err
 	}
-	ageRestricted := isAgeRestricted(html)
+	// the embed page and the video info are needed only for older layouts
+	ageRestricted := len(streams) == 0 && isAgeRestricted(html)
 
 	var embedHTML []byte
 	var videoInfo []byte
 	if ageRestricted {
 		var err error
-		embedHTML, err = dl.getResource(embedURL(videoID))
+		embedHTML, err = dl.getResource(ctx, embedURL(videoID))
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
-		videoInfo, err = dl.getAuxiliaryInfo(embedHTML, videoID)
+		videoInfo, err = dl.getAuxiliaryInfo(ctx, embedHTML, videoID)
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, err
+		}
+	}
+	if playerResponse == nil {
+		playerResponse = extractPlayerResponse(html, videoInfo, ageRestricted)
+		pr = parsePlayerResponse(playerResponse)
+		streams = pr.streamInfos()
+	}
+	if len(streams) == 0 {
+		rawStreams, errExtractStreams := extractStreams(html, videoInfo, ageRestricted)
+		if errExtractStreams != nil {
+			if err := pr.playable(); err != nil {
+				return describeVideo(videoID, pr, html, embedHTML, videoInfo, ageRestricted), pr, nil, err
+			}
+			return nil, nil, nil, errExtractStreams
+		}
+		for _, rs := range strings.Split(strings.Join(rawStreams, ","), ",") {
+			info, err := parseRawStream(rs)
+			if err != nil {
+				logger.printf("%s", err)
+				continue
+			}
+			streams = append(streams, info)
 		}
 	}
 
-	videoData["title"] = extractTitle(html, embedHTML, ageRestricted)
+	return describeVideo(videoID, pr, html, embedHTML, videoInfo, ageRestricted), pr, streams, nil
+}
+
+// describeVideo returns information of the video in the player response, or extracted from the page if not given
+func describeVideo(videoID string, pr *playerResponse, html, embedHTML, videoInfo []byte, ageRestricted bool) map[string]string {
+	videoData := make(map[string]string)
+	details, microformat := pr.VideoDetails, pr.Microformat.Renderer
+	videoData["video_id"] = videoID
+	videoData["title"] = orExtract(details.Title, func() string { return extractTitle(html, embedHTML, ageRestricted) })
 	videoData["jsURL"] = extractJsURL(html, embedHTML, ageRestricted)
-	videoData["duration"] = extractDuration(html, videoInfo, ageRestricted)
-	streams, errExtractStreams := extractStreams(html, videoInfo, ageRestricted)
-	if errExtractStreams != nil {
-		return nil, errExtractStreams
+	videoData["duration"] = orExtract(details.LengthSeconds, func() string { return extractDuration(html, videoInfo, ageRestricted) })
+	videoData["author"] = orExtract(details.Author, func() string { return extractAuthor(html) })
+	videoData["upload_date"] = orExtract(pr.uploadDate(), func() string { return extractUploadDate(html) })
+	videoData["description"] = orExtract(details.ShortDescription, func() string { return extractDescription(html) })
+	videoData["channel_id"] = orExtract(details.ChannelID, func() string { return extractMeta(html, channelIDRegex, "channel id") })
+	videoData["view_count"] = orExtract(details.ViewCount, func() string { return extractMeta(html, viewCountRegex, "view count") })
+	videoData["category"] = orExtract(microformat.Category, func() string { return extractMeta(html, categoryRegex, "category") })
+	videoData["publish_date"] = orExtract(isoDate(microformat.PublishDate), func() string { return extractMeta(html, publishDateRegex, "publish date") })
+	if details.AverageRating > 0 {
+		videoData["avg_rating"] = strconv.FormatFloat(details.AverageRating, 'f', -1, 64)
+	} else {
+		videoData["avg_rating"] = extractMeta(html, avgRatingRegex, "average rating")
 	}
-	videoData["streams"] = strings.Join(streams, ",")
-	return videoData, nil
+	if details.Keywords == nil {
+		videoData["keywords"] = extractMeta(html, keywordsRegex, "keywords")
+	}
+	return videoData
 }
 
-func (dl *YoutubeDownloader) extractVideoID() (string, error) {
-	res := videoIDRegex.FindStringSubmatch(dl.url)
-	if res == nil {
-		return "", fmt.Errorf("no id found in %s", dl.url)
+// orExtract returns a value given by the player response, or extracts it from the page if empty
+func orExtract(value string, extract func() string) string {
+	if value != "" {
+		return value
 	}
-	return res[1], nil
+	return extract()
+}
+
+func (dl *YoutubeDownloader) extractVideoID() (string, error) {
+	return ParseVideoID(dl.url)
 }
 
-func (dl *YoutubeDownloader) getAuxiliaryInfo(embedHTML []byte, videoID string) ([]byte, error) {
+func (dl *YoutubeDownloader) getAuxiliaryInfo(ctx context.Context, embedHTML []byte, videoID string) ([]byte, error) {
 	sts := stsRegex.FindSubmatch(embedHTML)
 	if len(sts) < 2 {
 		return nil, errors.New("failed to obtain sts for video info url")
 	}
 	videoInfoURL := auxiliaryInfoURL(videoID, string(sts[1][:]))
-	videoInfo, err := dl.getResource(videoInfoURL)
+	videoInfo, err := dl.getResource(ctx, videoInfoURL)
 	if err != nil {
 		return nil, err
 	}
@@ -179,7 +292,7 @@ func extractTitle(html, embedHTML []byte, ageRestricted bool) string {
 		logger.print("no title is extracted\n")
 		return ""
 	}
-	return string(title[1][:])
+	return unescapeJSON(string(title[1][:]))
 }
 
 func extractDuration(html, videoInfo []byte, ageRestricted bool) string {
@@ -196,12 +309,61 @@ func extractDuration(html, videoInfo []byte, ageRestricted bool) string {
 	return string(duration[1][:])
 }
 
+func extractAuthor(html []byte) string {
+	author := authorRegex.FindSubmatch(html)
+	if author == nil {
+		logger.print("no author is extracted\n")
+		return ""
+	}
+	return unescapeJSON(string(author[1]))
+}
+
+func extractUploadDate(html []byte) string {
+	date := uploadDateRegex.FindSubmatch(html)
+	if date == nil {
+		logger.print("no upload date is extracted\n")
+		return ""
+	}
+	return string(date[1])
+}
+
+// extractDescription returns the description in plain text, or the shortened one in meta if not found
+func extractDescription(html []byte) string {
+	if description := descriptionRegex.FindSubmatch(html); description != nil {
+		text := brRegex.ReplaceAll(description[1], []byte("\n"))
+		return htmlpkg.UnescapeString(string(htmlTagRegex.ReplaceAll(text, nil)))
+	}
+	if description := metaDescriptionRegex.FindSubmatch(html); description != nil {
+		return htmlpkg.UnescapeString(string(description[1]))
+	}
+	logger.print("no description is extracted\n")
+	return ""
+}
+
+// extractPlayerResponse returns the player response in JSON of older layouts, which is a string in the player config
+func extractPlayerResponse(html, videoInfo []byte, ageRestricted bool) []byte {
+	if ageRestricted {
+		if playerResponse := ageRestrictedPlayerRespRegex.FindSubmatch(videoInfo); playerResponse != nil {
+			if decoded, err := url.QueryUnescape(string(playerResponse[1])); err == nil {
+				return []byte(decoded)
+			}
+		}
+	} else if playerResponse := playerResponseRegex.FindSubmatch(html); playerResponse != nil {
+		return []byte(unescapeJSON(string(playerResponse[1])))
+	}
+	logger.print("no player response is extracted\n")
+	return nil
+}
+
 func extractJsURL(html, embedHTML []byte, ageRestricted bool) string {
 	var jsURL [][]byte
 	if ageRestricted {
 		jsURL = ageRestrictedJsURLRegex.FindSubmatch(embedHTML)
 	} else {
 		jsURL = jsURLRegex.FindSubmatch(html)
+		if jsURL == nil {
+			jsURL = jsURLKeyRegex.FindSubmatch(html)
+		}
 	}
 	if jsURL == nil {
 		logger.print("no js url is extracted\n")
@@ -239,7 +401,8 @@ func isAgeRestricted(html []byte) bool {
 	return strings.Contains(string(html[:]), "og:restrictions:age")
 }
 
-func inflateStream(rawStream string, c client, d decipherer, auxiliaryInfo map[string]string) (*Stream, error) {
+// parseRawStream splits a stream of adaptive_fmts or url_encoded_fmt_stream_map into a map newStream takes
+func parseRawStream(rawStream string) (map[string]string, error) {
 	// split into key=val pairs
 	var items []string
 	if strings.Contains(rawStream, "\\u0026") {
@@ -262,15 +425,5 @@ func inflateStream(rawStream string, c client, d decipherer, auxiliaryInfo map[s
 		}
 		values[vals[0]] = unescaped
 	}
-
-	// add auxiliaryInfo
-	for k, v := range auxiliaryInfo {
-		values[k] = v
-	}
-
-	if stream, err := newStream(values, c, d); err != nil {
-		return nil, err
-	} else {
-		return stream, nil
-	}
+	return values, nil
 }
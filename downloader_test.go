@@ -1,10 +1,13 @@
 package gotube
 
 import (
+	"context"
 	"net/http"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	mock "github.com/matthewlujp/gotube/mocks"
@@ -15,6 +18,9 @@ const (
 	validURL                  = "https://www.youtube.com/watch?v=iEPTlhBmwRg"
 	jsURL                     = "https://youtube.com/yts/jsbin/player-vfllqtOs7/ja_JP/base.js"
 	title                     = "Maroon 5 - Moves Like Jagger ft. Christina Aguilera"
+	author                    = "Maroon5VEVO"
+	uploadDate                = "2011-08-09"
+	descriptionPrefix         = "UK release: Sept 5th"
 	ageRestrictedURL          = "https://www.youtube.com/watch?v=6LZM3_wp2ps&has_verified=1"
 	ageRestrictedEmbedURL     = "https://www.youtube.com/embed/6LZM3_wp2ps"
 	ageRestrictedJsURL        = "https://youtube.com/yts/jsbin/player-vflX7BSrP/ja_JP/base.js"
@@ -26,11 +32,21 @@ const (
 
 type fakeClient struct {
 	client
-	fakeGet func(url string) (*http.Response, error)
+	fakeGet  func(ctx context.Context, url string) (*http.Response, error)
+	fakeHead func(ctx context.Context, url string) (*http.Response, error)
+	fakePost func(ctx context.Context, url, contentType string, body []byte) (*http.Response, error)
 }
 
-func (c *fakeClient) Get(url string) (*http.Response, error) {
-	return c.fakeGet(url)
+func (c *fakeClient) Get(ctx context.Context, url string) (*http.Response, error) {
+	return c.fakeGet(ctx, url)
+}
+
+func (c *fakeClient) Head(ctx context.Context, url string) (*http.Response, error) {
+	return c.fakeHead(ctx, url)
+}
+
+func (c *fakeClient) Post(ctx context.Context, url, contentType string, body []byte) (*http.Response, error) {
+	return c.fakePost(ctx, url, contentType, body)
 }
 
 func TestNewDownloader(t *testing.T) {
@@ -39,9 +55,25 @@ func TestNewDownloader(t *testing.T) {
 		t.Errorf("invalid or non youtube url should be rejected, but didn't for %s", dummyURL)
 	}
 
-	// watch youtube url
-	if _, err := NewDownloader(validURL); err != nil {
-		t.Errorf("failed to instantiate player from %s", validURL)
+	// every shape of youtube url is normalized to the canonical watch url
+	for _, u := range []string{
+		validURL,
+		"iEPTlhBmwRg",
+		"https://youtu.be/iEPTlhBmwRg?t=42",
+		"https://m.youtube.com/watch?v=iEPTlhBmwRg&feature=share",
+		"https://music.youtube.com/watch?v=iEPTlhBmwRg",
+		"https://www.youtube.com/embed/iEPTlhBmwRg",
+		"https://www.youtube.com/shorts/iEPTlhBmwRg",
+		"https://www.youtube.com/v/iEPTlhBmwRg",
+		"https://www.youtube.com/live/iEPTlhBmwRg",
+		"youtube.com/watch?v=iEPTlhBmwRg",
+	} {
+		dl, err := NewDownloader(u)
+		if err != nil {
+			t.Errorf("failed to instantiate player from %s, %s", u, err)
+		} else if dl.url != validURL {
+			t.Errorf("got url %s for %s, expected %s", dl.url, u, validURL)
+		}
 	}
 }
 
@@ -51,8 +83,8 @@ func TestFetchStreamManifests(t *testing.T) {
 	c := mock.NewMockclient(ctrl)
 
 	gomock.InOrder(
-		c.EXPECT().Get(validURL).Return(getMockPage()),
-		c.EXPECT().Get(jsURL).Return(getContent(mockScriptPath)),
+		c.EXPECT().Get(gomock.Any(), validURL).Return(getMockPage()),
+		c.EXPECT().Get(gomock.Any(), jsURL).Return(getContent(mockScriptPath)),
 	)
 
 	downloader := YoutubeDownloader{
@@ -65,8 +97,58 @@ func TestFetchStreamManifests(t *testing.T) {
 	}
 
 	// check title
-	if downloader.title != title {
-		t.Errorf("wrong title, exepected %s, got %s", title, downloader.title)
+	if downloader.metadata.Title != title {
+		t.Errorf("wrong title, exepected %s, got %s", title, downloader.metadata.Title)
+	}
+
+	// check other metadata
+	if downloader.metadata.Author != author {
+		t.Errorf("wrong author, expected %s, got %s", author, downloader.metadata.Author)
+	}
+	if downloader.metadata.UploadDate != uploadDate {
+		t.Errorf("wrong upload date, expected %s, got %s", uploadDate, downloader.metadata.UploadDate)
+	}
+	if !strings.HasPrefix(downloader.metadata.Description, descriptionPrefix) {
+		t.Errorf("wrong description, expected to start with %s, got %s", descriptionPrefix, downloader.metadata.Description)
+	}
+	if captions, err := downloader.Captions(); err != nil || len(captions) != 0 {
+		t.Errorf("got %d caption tracks and %v, expected none", len(captions), err)
+	}
+
+	// check info read from the page of the older layout
+	info, err := downloader.Info()
+	if err != nil {
+		t.Fatalf("failed to get info, %s", err)
+	}
+	description := "UK release: Sept 5th - Pre-order the new album “Hands All Over” including ‘Moves Like Jagger’ on iTunes now:  http://bit.ly/oravTV\n\n" +
+		"Sign up for updates: http://smarturl.it/Maroon5.News\n\n" +
+		"Music video by Maroon 5 performing Moves Like Jagger. (C) 2011 A&M/Octone Records\n" +
+		"#VEVOCertified on April 16, 2012. http://www.vevo.com/certified http://www.youtube.com/vevocertified\n" +
+		"Best of Maroon 5: https://goo.gl/8n9iCm\n" +
+		"Subscribe here: https://goo.gl/EFMAUy"
+	thumbnails := []Thumbnail{
+		{URL: "https://i.ytimg.com/vi/iEPTlhBmwRg/hqdefault.jpg?sqp=-oaymwEWCKgBEF5IWvKriqkDCQgBFQAAiEIYAQ==&rs=AOn4CLDvm45Qo-L32nRTpWrJvAhAen4MaA", Width: 168, Height: 94},
+		{URL: "https://i.ytimg.com/vi/iEPTlhBmwRg/hqdefault.jpg?sqp=-oaymwEWCMQBEG5IWvKriqkDCQgBFQAAiEIYAQ==&rs=AOn4CLDtJb8nd-kW-9_dmWUsC-f5nXhRhQ", Width: 196, Height: 110},
+		{URL: "https://i.ytimg.com/vi/iEPTlhBmwRg/hqdefault.jpg?sqp=-oaymwEXCPYBEIoBSFryq4qpAwkIARUAAIhCGAE=&rs=AOn4CLDFCX4SWzcAx2mid4iB_5oOcITAhw", Width: 246, Height: 138},
+		{URL: "https://i.ytimg.com/vi/iEPTlhBmwRg/hqdefault.jpg?sqp=-oaymwEXCNACELwBSFryq4qpAwkIARUAAIhCGAE=&rs=AOn4CLB1JwET4VPYiUrogmnxR6e_tXNcEQ", Width: 336, Height: 188},
+	}
+	expectedInfo := VideoInfo{
+		ID:            "iEPTlhBmwRg",
+		Title:         title,
+		Author:        author,
+		ChannelID:     "UCN1hnUccO4FD5WfM7ithXaw",
+		Description:   description,
+		Keywords:      []string{"Maroon", "Moves", "Like", "Jagger", "A&M", "Octone", "Records", "Pop"},
+		ViewCount:     559687580,
+		PublishDate:   uploadDate,
+		UploadDate:    uploadDate,
+		Category:      "Music",
+		Duration:      279 * time.Second,
+		AverageRating: 4.83733654022,
+		Thumbnails:    thumbnails,
+	}
+	if !reflect.DeepEqual(*info, expectedInfo) {
+		t.Errorf("got info\n%+v\nexpected\n%+v", *info, expectedInfo)
 	}
 
 	// check streams
@@ -83,7 +165,7 @@ func TestFetchStreamManifests(t *testing.T) {
 		)
 		for i, s := range downloader.Streams {
 			if !s.equal(videoStreams[i]) {
-				t.Errorf("\n%v\nwhile expected\n%v\n", *s, *videoStreams[i])
+				t.Errorf("\n%v\nwhile expected\n%v\n", s, videoStreams[i])
 			}
 		}
 	}
@@ -95,10 +177,10 @@ func TestFetchStreamManifestsForAgeRestricted(t *testing.T) {
 	c := mock.NewMockclient(ctrl)
 
 	gomock.InOrder(
-		c.EXPECT().Get(ageRestrictedURL).Return(getContent(mockAgeRestrictedPagePath)),
-		c.EXPECT().Get(ageRestrictedEmbedURL).Return(getContent(mockAgeRestrictedEmbedPagePath)),
-		c.EXPECT().Get(ageRestrictedVideoInfoURL).Return(getContent(mockAgeRestrictedVideoInfoPath)),
-		c.EXPECT().Get(ageRestrictedJsURL).Return(getContent(mockAgeRestrictedScriptPath)),
+		c.EXPECT().Get(gomock.Any(), ageRestrictedURL).Return(getContent(mockAgeRestrictedPagePath)),
+		c.EXPECT().Get(gomock.Any(), ageRestrictedEmbedURL).Return(getContent(mockAgeRestrictedEmbedPagePath)),
+		c.EXPECT().Get(gomock.Any(), ageRestrictedVideoInfoURL).Return(getContent(mockAgeRestrictedVideoInfoPath)),
+		c.EXPECT().Get(gomock.Any(), ageRestrictedJsURL).Return(getContent(mockAgeRestrictedScriptPath)),
 	)
 
 	downloader := YoutubeDownloader{
@@ -111,8 +193,8 @@ func TestFetchStreamManifestsForAgeRestricted(t *testing.T) {
 	}
 
 	// check title
-	if downloader.title != ageRestrictedTitle {
-		t.Errorf("wrong title, exepected %s, got %s", ageRestrictedTitle, downloader.title)
+	if downloader.metadata.Title != ageRestrictedTitle {
+		t.Errorf("wrong title, exepected %s, got %s", ageRestrictedTitle, downloader.metadata.Title)
 	}
 
 	// check streams
@@ -129,46 +211,53 @@ func TestFetchStreamManifestsForAgeRestricted(t *testing.T) {
 		)
 		for i, s := range downloader.Streams {
 			if !s.equal(restrictedVideoStreams[i]) {
-				t.Errorf("\n%v\nwhile expected\n%v\n", *s, *restrictedVideoStreams[i])
+				t.Errorf("\n%v\nwhile expected\n%v\n", s, restrictedVideoStreams[i])
 			}
 		}
 	}
 
+	// check captions
+	captions, err := downloader.Captions()
+	if err != nil {
+		t.Fatalf("failed to get captions, %s", err)
+	}
+	if len(captions) != 1 {
+		t.Fatalf("got %d caption tracks, expected 1", len(captions))
+	}
+	if c := captions[0]; c.LanguageCode != "en" || !c.AutoGenerated || !c.Translatable || !strings.Contains(c.baseURL, "/api/timedtext?") {
+		t.Errorf("wrong caption track %+v", *c)
+	}
 }
 
-func TestInflateStream(t *testing.T) {
+func TestParseRawStream(t *testing.T) {
 	// case 1 (for noramal video)
 	rawStream := "type=video%2Fmp4%3B+codecs%3D%22avc1.64001F%2C+mp4a.40.2%22\\u0026itag=22\\u0026url=https%3A%2F%2Fr1---sn-ogul7n7s.googlevideo.com%2Fvideoplayback%3Fdur%3D278.778%26pl%3D17%26itag%3D22%26key%3Dyt6%26ip%3D126.2.187.172%26ms%3Dau%252Conr%26source%3Dyoutube%26mv%3Dm%26id%3Do-AIMLylRYsEhoQdkeKYgwTOxaBWNe5BVIMqnuOI5fUZyR%26expire%3D1521061926%26mm%3D31%252C26%26mn%3Dsn-ogul7n7s%252Csn-3pm7snez%26mime%3Dvideo%252Fmp4%26lmt%3D1518448989107051%26ratebypass%3Dyes%26ei%3DxjupWtr7NIifqQG8vp_gBA%26fvip%3D1%26c%3DWEB%26mt%3D1521040211%26ipbits%3D0%26requiressl%3Dyes%26sparams%3Ddur%252Cei%252Cid%252Cinitcwndbps%252Cip%252Cipbits%252Citag%252Clmt%252Cmime%252Cmm%252Cmn%252Cms%252Cmv%252Cpl%252Cratebypass%252Crequiressl%252Csource%252Cexpire%26initcwndbps%3D772500\\u0026quality=hd720\\u0026sp=signature\\u0026s=55B2F7214E041D71337613EA784BC0797F6064EE.6497710E7951322E10ACCD773A9DA4B7A492B6E77"
-	expected, _ := newStream(
-		map[string]string{
-			"itag":     "22",
-			"quality":  "hd720",
-			"s":        "55B2F7214E041D71337613EA784BC0797F6064EE.6497710E7951322E10ACCD773A9DA4B7A492B6E77",
-			"sp":       "signature",
-			"type":     "video/mp4; codecs=\"avc1.64001F, mp4a.40.2\"",
-			"url":      "https://r1---sn-ogul7n7s.googlevideo.com/videoplayback?dur=278.778&pl=17&itag=22&key=yt6&ip=126.2.187.172&ms=au,onr&source=youtube&mv=m&id=o-AIMLylRYsEhoQdkeKYgwTOxaBWNe5BVIMqnuOI5fUZyR&expire=1521061926&mm=31,26&mn=sn-ogul7n7s,sn-3pm7snez&mime=video/mp4&lmt=1518448989107051&ratebypass=yes&ei=xjupWtr7NIifqQG8vp_gBA&fvip=1&c=WEB&mt=1521040211&ipbits=0&requiressl=yes&sparams=dur,ei,id,initcwndbps,ip,ipbits,itag,lmt,mime,mm,mn,ms,mv,pl,ratebypass,requiressl,source,expire&initcwndbps=772500",
-			"duration": "13",
-		}, nil, nil)
-	if stream, err := inflateStream(rawStream, nil, nil, map[string]string{"duration": "13"}); err != nil {
-		t.Error("failed to inflate raw stream", err)
-	} else if !reflect.DeepEqual(stream, expected) {
-		t.Errorf("wrong inflation expected %v, got %v", expected, stream)
+	expected := map[string]string{
+		"itag":    "22",
+		"quality": "hd720",
+		"s":       "55B2F7214E041D71337613EA784BC0797F6064EE.6497710E7951322E10ACCD773A9DA4B7A492B6E77",
+		"sp":      "signature",
+		"type":    "video/mp4; codecs=\"avc1.64001F, mp4a.40.2\"",
+		"url":     "https://r1---sn-ogul7n7s.googlevideo.com/videoplayback?dur=278.778&pl=17&itag=22&key=yt6&ip=126.2.187.172&ms=au,onr&source=youtube&mv=m&id=o-AIMLylRYsEhoQdkeKYgwTOxaBWNe5BVIMqnuOI5fUZyR&expire=1521061926&mm=31,26&mn=sn-ogul7n7s,sn-3pm7snez&mime=video/mp4&lmt=1518448989107051&ratebypass=yes&ei=xjupWtr7NIifqQG8vp_gBA&fvip=1&c=WEB&mt=1521040211&ipbits=0&requiressl=yes&sparams=dur,ei,id,initcwndbps,ip,ipbits,itag,lmt,mime,mm,mn,ms,mv,pl,ratebypass,requiressl,source,expire&initcwndbps=772500",
+	}
+	if values, err := parseRawStream(rawStream); err != nil {
+		t.Error("failed to parse raw stream", err)
+	} else if !reflect.DeepEqual(values, expected) {
+		t.Errorf("wrong parse expected %v, got %v", expected, values)
 	}
 
 	// case 2 (for age restricted video)
 	rawStream = "itag=22&quality=hd720&type=video%2Fmp4%3B+codecs%3D%22avc1.64001F%2C+mp4a.40.2%22&url=https%3A%2F%2Fr1---sn-3pm7sn7r.googlevideo.com%2Fvideoplayback%3Fmt%3D1523922201%26mm%3D31%252C29%26itag%3D22%26requiressl%3Dyes%26ipbits%3D0%26ei%3DdTXVWoC1GNPWqAHZ2JOgCg%26sparams%3Ddur%252Cei%252Cid%252Cinitcwndbps%252Cip%252Cipbits%252Citag%252Clmt%252Cmime%252Cmm%252Cmn%252Cms%252Cmv%252Cpl%252Cratebypass%252Crequiressl%252Csource%252Cexpire%26dur%3D282.192%26ratebypass%3Dyes%26pl%3D17%26fvip%3D5%26ms%3Dau%252Crdu%26source%3Dyoutube%26mv%3Dm%26beids%3D%255B9466593%255D%26ip%3D126.225.83.8%26key%3Dyt6%26lmt%3D1507668791912906%26c%3DWEB%26initcwndbps%3D802500%26id%3Do-AB3avPHYxCHm1GOOiFZiFYPmAKUF-Vr1fUP6xCxawj_X%26mime%3Dvideo%252Fmp4%26signature%3D7C4CF38F629A9E79B784B7F0C5763BBE9EE7E6AB.DC7917FB4F2A270FA8B4FB075F94857ED11B310C%26expire%3D1523943893%26mn%3Dsn-3pm7sn7r%252Csn-3pm76n7s"
-	expected, _ = newStream(
-		map[string]string{
-			"itag":     "22",
-			"quality":  "hd720",
-			"type":     "video/mp4; codecs=\"avc1.64001F, mp4a.40.2\"",
-			"url":      "https://r1---sn-3pm7sn7r.googlevideo.com/videoplayback?mt=1523922201&mm=31,29&itag=22&requiressl=yes&ipbits=0&ei=dTXVWoC1GNPWqAHZ2JOgCg&sparams=dur,ei,id,initcwndbps,ip,ipbits,itag,lmt,mime,mm,mn,ms,mv,pl,ratebypass,requiressl,source,expire&dur=282.192&ratebypass=yes&pl=17&fvip=5&ms=au,rdu&source=youtube&mv=m&beids=[9466593]&ip=126.225.83.8&key=yt6&lmt=1507668791912906&c=WEB&initcwndbps=802500&id=o-AB3avPHYxCHm1GOOiFZiFYPmAKUF-Vr1fUP6xCxawj_X&mime=video/mp4&signature=7C4CF38F629A9E79B784B7F0C5763BBE9EE7E6AB.DC7917FB4F2A270FA8B4FB075F94857ED11B310C&expire=1523943893&mn=sn-3pm7sn7r,sn-3pm76n7s",
-			"duration": "17",
-		}, nil, nil)
-	if stream, err := inflateStream(rawStream, nil, nil, map[string]string{"duration": "17"}); err != nil {
-		t.Error("failed to inflate raw stream", err)
-	} else if !reflect.DeepEqual(stream, expected) {
-		t.Errorf("wrong inflation")
+	expected = map[string]string{
+		"itag":    "22",
+		"quality": "hd720",
+		"type":    "video/mp4; codecs=\"avc1.64001F, mp4a.40.2\"",
+		"url":     "https://r1---sn-3pm7sn7r.googlevideo.com/videoplayback?mt=1523922201&mm=31,29&itag=22&requiressl=yes&ipbits=0&ei=dTXVWoC1GNPWqAHZ2JOgCg&sparams=dur,ei,id,initcwndbps,ip,ipbits,itag,lmt,mime,mm,mn,ms,mv,pl,ratebypass,requiressl,source,expire&dur=282.192&ratebypass=yes&pl=17&fvip=5&ms=au,rdu&source=youtube&mv=m&beids=[9466593]&ip=126.225.83.8&key=yt6&lmt=1507668791912906&c=WEB&initcwndbps=802500&id=o-AB3avPHYxCHm1GOOiFZiFYPmAKUF-Vr1fUP6xCxawj_X&mime=video/mp4&signature=7C4CF38F629A9E79B784B7F0C5763BBE9EE7E6AB.DC7917FB4F2A270FA8B4FB075F94857ED11B310C&expire=1523943893&mn=sn-3pm7sn7r,sn-3pm76n7s",
+	}
+	if values, err := parseRawStream(rawStream); err != nil {
+		t.Error("failed to parse raw stream", err)
+	} else if !reflect.DeepEqual(values, expected) {
+		t.Errorf("wrong parse")
 	}
 
 }
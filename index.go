@@ -0,0 +1,113 @@
+package gotube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteRange is an inclusive range of bytes in a stream, such as init=0-714 of stream info
+type byteRange struct {
+	start int
+	end   int
+}
+
+// parseByteRange parses a range formatted as "start-end"
+func parseByteRange(v string) (byteRange, bool) {
+	parts := strings.Split(v, "-")
+	if len(parts) != 2 {
+		return byteRange{}, false
+	}
+	start, errStart := strconv.Atoi(parts[0])
+	end, errEnd := strconv.Atoi(parts[1])
+	if errStart != nil || errEnd != nil || start < 0 || end < start {
+		return byteRange{}, false
+	}
+	return byteRange{start: start, end: end}, true
+}
+
+// mediaSegment is a part of a stream listed in its container index, sidx of MP4 or Cues of WebM.
+// A segment starts at a keyframe, so it can be decoded with the init range alone.
+type mediaSegment struct {
+	start    int // offset of the first byte
+	end      int // offset next to the last byte
+	time     time.Duration
+	duration time.Duration
+}
+
+// hasIndex reports whether the stream has a container index, which is given to DASH streams
+func (s *Stream) hasIndex() bool {
+	return s.indexRange.end > 0 && (s.Format == "mp4" || s.Format == "webm")
+}
+
+// segments returns media segments of the stream read from its container index.
+// The index is fetched only once.
+func (s *Stream) segments(ctx context.Context, totalSize int) ([]mediaSegment, error) {
+	s.segmentsMu.Lock()
+	defer s.segmentsMu.Unlock()
+	if s.segmentCache != nil {
+		return s.segmentCache, nil
+	}
+	if !s.hasIndex() {
+		return nil, errors.New("stream has no container index")
+	}
+
+	var segments []mediaSegment
+	switch s.Format {
+	case "mp4":
+		index, err := s.download(ctx, rangeQuery(s.indexRange.start, s.indexRange.end), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch index range, %s", err)
+		}
+		if segments, err = mp4Segments(index, s.indexRange.start); err != nil {
+			return nil, err
+		}
+	case "webm":
+		// Cues refer to clusters relative to the Segment, whose header is in the init range
+		if s.initRange.end >= s.indexRange.start {
+			return nil, errors.New("init range does not precede index range")
+		}
+		data, err := s.download(ctx, rangeQuery(s.initRange.start, s.indexRange.end), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch init and index ranges, %s", err)
+		}
+		if len(data) != s.indexRange.end-s.initRange.start+1 {
+			return nil, fmt.Errorf("received %d bytes for init and index ranges", len(data))
+		}
+		initData := data[:s.initRange.end-s.initRange.start+1]
+		indexData := data[s.indexRange.start-s.initRange.start:]
+		if segments, err = webmSegments(initData, indexData, totalSize); err != nil {
+			return nil, err
+		}
+	}
+
+	// segments must follow the index without gaps
+	if len(segments) == 0 {
+		return nil, errors.New("no segment found in the index")
+	}
+	for i, seg := range segments {
+		if seg.start <= s.indexRange.end || seg.end <= seg.start || seg.end > totalSize || (i > 0 && seg.start != segments[i-1].end) {
+			return nil, fmt.Errorf("invalid segment %d-%d in the index", seg.start, seg.end)
+		}
+	}
+	s.segmentCache = segments
+	return segments, nil
+}
+
+// segmentRanges groups consecutive segments so that a group covers at least a given duration.
+// The first range also contains the init and index ranges preceding the segments.
+func segmentRanges(segments []mediaSegment, totalSize int, duration time.Duration) []int {
+	ranges := []int{0}
+	var accumulated time.Duration
+	for i, seg := range segments {
+		if i > 0 && accumulated >= duration {
+			ranges = append(ranges, seg.start)
+			accumulated = 0
+		}
+		accumulated += seg.duration
+	}
+	return append(ranges, totalSize)
+}
@@ -0,0 +1,96 @@
+package gotube
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseByteRange(t *testing.T) {
+	if r, ok := parseByteRange("0-714"); !ok || r != (byteRange{start: 0, end: 714}) {
+		t.Errorf("got %v, %t, expected 0-714", r, ok)
+	}
+	for _, v := range []string{"", "714", "10-5", "a-b", "-1-5"} {
+		if _, ok := parseByteRange(v); ok {
+			t.Errorf("%q should be invalid", v)
+		}
+	}
+}
+
+func TestByteRangesFromIndex(t *testing.T) {
+	initData := makeMP4Box("ftyp", []byte("dash0000iso6mp41"))
+	index := sidxBox(0, []int{100, 100, 100, 100}, []uint32{10000, 10000, 10000, 5000})
+	content := append(append(initData, index...), make([]byte, 400)...)
+	segmentStart := len(initData) + len(index)
+
+	var mu sync.Mutex
+	var requested []int
+	stream := Stream{
+		url:        "https://foobar?itag=137&signature=geho",
+		Format:     "mp4",
+		Retry:      &NoRetry,
+		initRange:  byteRange{start: 0, end: len(initData) - 1},
+		indexRange: byteRange{start: len(initData), end: segmentStart - 1},
+		client:     rangeServingClient(content, nil, &requested, &mu),
+	}
+
+	ranges, err := stream.byteRanges(context.Background(), 20*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []int{0, segmentStart + 200, len(content)}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("got ranges %v, expected %v", ranges, expected)
+	}
+
+	// the index is fetched only once
+	if _, err := stream.byteRanges(context.Background(), 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(requested) != 1 {
+		t.Errorf("index was requested %d times, expected once", len(requested))
+	}
+
+	reader, err := stream.SequentialChunkDownload(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	starts := []int{}
+	for reader.Next() {
+		start, _ := reader.Range()
+		starts = append(starts, start)
+	}
+	if reader.Err() != nil {
+		t.Fatal(reader.Err())
+	}
+	if expected := []int{0, segmentStart + 100, segmentStart + 200, segmentStart + 300}; !reflect.DeepEqual(starts, expected) {
+		t.Errorf("got chunks starting at %v, expected %v", starts, expected)
+	}
+}
+
+func TestByteRangesFallback(t *testing.T) {
+	content := make([]byte, 17)
+	var mu sync.Mutex
+	var requested []int
+	stream := Stream{
+		url:        "https://foobar?itag=137&signature=geho",
+		Format:     "mp4",
+		Duration:   time.Second * time.Duration(20*7.5),
+		Retry:      &NoRetry,
+		initRange:  byteRange{start: 0, end: 3},
+		indexRange: byteRange{start: 4, end: 9}, // no sidx in the range
+		client:     rangeServingClient(content, nil, &requested, &mu),
+	}
+
+	ranges, err := stream.byteRanges(context.Background(), 20*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []int{0, 2, 4, 6, 8, 10, 12, 14, 16, 17}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("got ranges %v, expected %v", ranges, expected)
+	}
+}
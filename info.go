@@ -0,0 +1,101 @@
+package gotube
+
+import (
+	"errors"
+	htmlpkg "html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	channelIDRegex   = regexp.MustCompile(`<meta itemprop="channelId" content="(.+?)">`)
+	viewCountRegex   = regexp.MustCompile(`<meta itemprop="interactionCount" content="(\d+)">`)
+	avgRatingRegex   = regexp.MustCompile(`"avg_rating":"([\d.]+)"`)
+	keywordsRegex    = regexp.MustCompile(`<meta name="keywords" content="(.*?)">`)
+	categoryRegex    = regexp.MustCompile(`<meta itemprop="genre" content="(.+?)">`)
+	publishDateRegex = regexp.MustCompile(`<meta itemprop="datePublished" content="(.+?)">`)
+)
+
+// VideoInfo is information of a video, which is read from the watch page without downloading any stream
+type VideoInfo struct {
+	ID            string
+	Title         string
+	Author        string // name of the channel
+	ChannelID     string
+	Description   string
+	Keywords      []string
+	ViewCount     int64
+	PublishDate   string // in YYYY-MM-DD
+	UploadDate    string // in YYYY-MM-DD
+	Category      string
+	Duration      time.Duration
+	IsLive        bool    // being broadcast live now
+	IsLiveContent bool    // broadcast live, now or in the past
+	IsUpcoming    bool    // scheduled to be broadcast live
+	AverageRating float64 // out of 5, 0 if not given
+	Thumbnails    []Thumbnail
+}
+
+// Thumbnail is an image of a video of a size
+type Thumbnail struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Info returns information of the video.
+// FetchStreams must be called beforehand, information of a video not playable, e.g. upcoming, is given even if it fails.
+func (dl *YoutubeDownloader) Info() (*VideoInfo, error) {
+	if dl.info == nil {
+		return nil, errors.New("streams are not fetched yet")
+	}
+	info := *dl.info
+	info.Keywords = append([]string(nil), dl.info.Keywords...)
+	info.Thumbnails = append([]Thumbnail(nil), dl.info.Thumbnails...)
+	return &info, nil
+}
+
+// newVideoInfo builds information of a video from the player response and values extracted from the page by extractData
+func newVideoInfo(videoData map[string]string, pr *playerResponse) *VideoInfo {
+	details, microformat := pr.VideoDetails, pr.Microformat.Renderer
+	info := &VideoInfo{
+		ID:            videoData["video_id"],
+		Title:         videoData["title"],
+		Author:        videoData["author"],
+		ChannelID:     videoData["channel_id"],
+		Description:   videoData["description"],
+		Keywords:      details.Keywords,
+		PublishDate:   videoData["publish_date"],
+		UploadDate:    videoData["upload_date"],
+		Category:      videoData["category"],
+		IsLive:        details.IsLive || microformat.LiveBroadcastDetails.IsLiveNow,
+		IsLiveContent: details.IsLiveContent,
+		IsUpcoming:    details.IsUpcoming,
+		Thumbnails:    details.Thumbnail.Thumbnails,
+	}
+	if seconds, err := strconv.Atoi(videoData["duration"]); err == nil {
+		info.Duration = time.Duration(seconds) * time.Second
+	}
+	if views, err := strconv.ParseInt(videoData["view_count"], 10, 64); err == nil {
+		info.ViewCount = views
+	}
+	if rating, err := strconv.ParseFloat(videoData["avg_rating"], 64); err == nil {
+		info.AverageRating = rating
+	}
+	if info.Keywords == nil && videoData["keywords"] != "" {
+		info.Keywords = strings.Split(videoData["keywords"], ", ")
+	}
+	return info
+}
+
+// extractMeta returns an unescaped value of the first group of a regex in the page, or "" if not found
+func extractMeta(html []byte, regex *regexp.Regexp, name string) string {
+	value := regex.FindSubmatch(html)
+	if value == nil {
+		logger.printf("no %s is extracted\n", name)
+		return ""
+	}
+	return htmlpkg.UnescapeString(string(value[1]))
+}
@@ -0,0 +1,101 @@
+package gotube
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+const (
+	browseURL = "https://www.youtube.com/youtubei/v1/browse"
+	// defaultClientVersion is sent for continuations when the page does not tell the version of the web client
+	defaultClientVersion = "2.20210101.00.00"
+)
+
+var (
+	initialDataRegex      = regexp.MustCompile(`ytInitialData"?\]?\s*=\s*\{`)
+	innertubeKeyRegex     = regexp.MustCompile(`"INNERTUBE_API_KEY":"(.+?)"`)
+	innertubeVersionRegex = regexp.MustCompile(`"INNERTUBE_CLIENT_VERSION":"(.+?)"`)
+)
+
+// videoLister holds what a playlist and a channel share, requesting pages listing videos and giving downloaders of them
+type videoLister struct {
+	retries     int64 // accessed atomically, kept first for 64-bit alignment
+	client      client
+	Retry       *RetryPolicy // policy for failed requests, also set to downloaders of videos, DefaultRetryPolicy is used if nil
+	Pool        *WorkerPool  // pool set to downloaders of videos
+	RateLimiter *RateLimiter // limiter set to downloaders of videos
+}
+
+// downloader returns a YoutubeDownloader of a listed video, which shares the client, Retry, Pool and RateLimiter
+func (l *videoLister) downloader(id string) (*YoutubeDownloader, error) {
+	videoID, err := ParseVideoID(id)
+	if err != nil {
+		return nil, err
+	}
+	return &YoutubeDownloader{
+		client:      l.client,
+		url:         CanonicalWatchURL(videoID),
+		Retry:       l.Retry,
+		Pool:        l.Pool,
+		RateLimiter: l.RateLimiter,
+	}, nil
+}
+
+// innertubeConfig is the api key and the version of the web client given in a page, which are sent with continuations
+type innertubeConfig struct {
+	key     string
+	version string
+}
+
+// continuationRenderer is the last item of a list, which gives the token to request the following items
+type continuationRenderer struct {
+	ContinuationEndpoint struct {
+		ContinuationCommand struct {
+			Token string `json:"token"`
+		} `json:"continuationCommand"`
+	} `json:"continuationEndpoint"`
+}
+
+// token returns the token of the continuation, "" if r is nil
+func (r *continuationRenderer) token() string {
+	if r == nil {
+		return ""
+	}
+	return r.ContinuationEndpoint.ContinuationCommand.Token
+}
+
+// extractInnertubeConfig returns the config in ytcfg of a page, with the default client version if not found
+func extractInnertubeConfig(html []byte) *innertubeConfig {
+	cfg := &innertubeConfig{
+		key:     extractMeta(html, innertubeKeyRegex, "innertube api key"),
+		version: extractMeta(html, innertubeVersionRegex, "innertube client version"),
+	}
+	if cfg.version == "" {
+		cfg.version = defaultClientVersion
+	}
+	return cfg
+}
+
+// browse requests items following those of a token and returns the response in JSON.
+// Failed requests are retried according to p, and the number of retries is added to retries.
+func (cfg *innertubeConfig) browse(ctx context.Context, c client, p *RetryPolicy, retries *int64, token string) ([]byte, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"context": map[string]interface{}{
+			"client": map[string]string{"clientName": "WEB", "clientVersion": cfg.version},
+		},
+		"continuation": token,
+	})
+	if err != nil {
+		return nil, err
+	}
+	u := browseURL
+	if cfg.key != "" {
+		u += "?key=" + url.QueryEscape(cfg.key)
+	}
+	return readResource(ctx, p, retries, u, func() (*http.Response, error) {
+		return c.Post(ctx, u, "application/json", body)
+	})
+}
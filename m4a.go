@@ -0,0 +1,391 @@
+package gotube
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	tfhdSampleDescriptionIndexPresent = 0x000002
+	tfhdDefaultSampleDurationPresent  = 0x000008
+	tfhdDefaultSampleSizePresent      = 0x000010
+	tfhdDefaultSampleFlagsPresent     = 0x000020
+	trunDataOffsetPresent             = 0x000001
+	trunFirstSampleFlagsPresent       = 0x000004
+	trunSampleDurationPresent         = 0x000100
+	trunSampleSizePresent             = 0x000200
+	trunSampleFlagsPresent            = 0x000400
+	trunSampleCompositionPresent      = 0x000800
+)
+
+// m4aFtyp is ftyp of an m4a file
+var m4aFtyp = makeMP4Box("ftyp", []byte("M4A \x00\x00\x02\x00M4A mp42isom"))
+
+// writeM4A rewraps a fragmented MP4 of a single audio track into a standard m4a,
+// which has all samples in one mdat followed by moov with complete sample tables.
+// If w is not an io.WriteSeeker, samples are held in memory until the size of mdat is known.
+func writeM4A(w io.Writer, r io.Reader) error {
+	in := &fmp4Reader{r: r}
+	if err := in.readInit(1); err != nil {
+		return fmt.Errorf("invalid audio, %s", err)
+	}
+	trex := findMP4Box(in.moov.payload, "mvex", "trex")
+	if trex == nil || len(trex.payload) < 24 {
+		return errors.New("no valid trex box found")
+	}
+	defaults := sampleDefaults{
+		duration: binary.BigEndian.Uint32(trex.payload[12:16]),
+		size:     binary.BigEndian.Uint32(trex.payload[16:20]),
+	}
+
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(m4aFtyp); err != nil {
+		return err
+	}
+
+	// mdat of a 64 bit size is filled afterwards if w is seekable
+	ws, seekable := w.(io.WriteSeeker)
+	var origin int64
+	if seekable {
+		var err error
+		if origin, err = ws.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+		origin -= cw.n
+	}
+	var mdat io.Writer = cw
+	buf := new(bytes.Buffer)
+	mdatPos := cw.n
+	if seekable {
+		if _, err := cw.Write([]byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 0, 0, 0, 0, 0}); err != nil {
+			return err
+		}
+	} else {
+		mdat = buf
+	}
+
+	var sizes, durations []uint32
+	for {
+		f, err := in.readFragment()
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			break
+		}
+		s, d, data, err := fragmentSamples(f, defaults)
+		if err != nil {
+			return err
+		}
+		if _, err := mdat.Write(data); err != nil {
+			return err
+		}
+		sizes = append(sizes, s...)
+		durations = append(durations, d...)
+	}
+	if len(sizes) == 0 {
+		return errors.New("no samples found")
+	}
+
+	var chunkOffset int64
+	if seekable {
+		chunkOffset = mdatPos + 16
+		end := cw.n
+		if _, err := ws.Seek(origin+mdatPos+8, io.SeekStart); err != nil {
+			return err
+		}
+		if err := binary.Write(ws, binary.BigEndian, uint64(end-mdatPos)); err != nil {
+			return err
+		}
+		if _, err := ws.Seek(origin+end, io.SeekStart); err != nil {
+			return err
+		}
+	} else {
+		header := make([]byte, 8)
+		if buf.Len()+8 > math.MaxUint32 {
+			header = make([]byte, 16)
+			binary.BigEndian.PutUint32(header[0:4], 1)
+			binary.BigEndian.PutUint64(header[8:16], uint64(buf.Len()+16))
+		} else {
+			binary.BigEndian.PutUint32(header[0:4], uint32(buf.Len()+8))
+		}
+		copy(header[4:8], "mdat")
+		if _, err := cw.Write(header); err != nil {
+			return err
+		}
+		chunkOffset = cw.n
+		if _, err := buf.WriteTo(cw); err != nil {
+			return err
+		}
+	}
+
+	moov, err := m4aMoov(in, sizes, durations, uint64(chunkOffset))
+	if err != nil {
+		return err
+	}
+	_, err = cw.Write(moov)
+	return err
+}
+
+// sampleDefaults is default duration and size of samples given by trex or tfhd
+type sampleDefaults struct {
+	duration uint32
+	size     uint32
+}
+
+// fragmentSamples returns sizes, durations and data of samples in a fragment following tfhd and trun
+func fragmentSamples(f *fmp4Fragment, trexDefaults sampleDefaults) ([]uint32, []uint32, []byte, error) {
+	boxes, err := parseMP4Boxes(f.moof[8:])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid moof box, %s", err)
+	}
+	mdat, err := parseMP4Boxes(f.mdat)
+	if err != nil || len(mdat) != 1 {
+		return nil, nil, nil, errors.New("invalid mdat box")
+	}
+	// offset of the mdat payload from the start of moof
+	payloadStart := int64(len(f.moof) + len(f.mdat) - len(mdat[0].payload))
+
+	var sizes, durations []uint32
+	data := []byte{}
+	for _, traf := range boxes {
+		if traf.typ != "traf" {
+			continue
+		}
+		tfhd := findMP4Box(traf.payload, "tfhd")
+		if tfhd == nil || len(tfhd.payload) < 8 {
+			return nil, nil, nil, errors.New("no valid tfhd box found")
+		}
+		defaults := trexDefaults
+		base := int64(0) // relative to moof
+		p := tfhd.payload
+		flags := binary.BigEndian.Uint32(p[0:4]) & 0xFFFFFF
+		fields := p[8:]
+		next := func() uint32 {
+			if len(fields) < 4 {
+				return 0
+			}
+			v := binary.BigEndian.Uint32(fields[0:4])
+			fields = fields[4:]
+			return v
+		}
+		if flags&tfhdBaseDataOffsetPresent != 0 {
+			high := int64(next())
+			base = (high<<32 | int64(next())) - f.moofPos
+		}
+		if flags&tfhdSampleDescriptionIndexPresent != 0 {
+			next()
+		}
+		if flags&tfhdDefaultSampleDurationPresent != 0 {
+			defaults.duration = next()
+		}
+		if flags&tfhdDefaultSampleSizePresent != 0 {
+			defaults.size = next()
+		}
+		if flags&tfhdDefaultSampleFlagsPresent != 0 {
+			next()
+		}
+
+		offset := base
+		truns, err := parseMP4Boxes(traf.payload)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, trun := range truns {
+			if trun.typ != "trun" {
+				continue
+			}
+			if len(trun.payload) < 8 {
+				return nil, nil, nil, errors.New("truncated trun box")
+			}
+			flags := binary.BigEndian.Uint32(trun.payload[0:4]) & 0xFFFFFF
+			count := int(binary.BigEndian.Uint32(trun.payload[4:8]))
+			fields = trun.payload[8:]
+			if flags&trunDataOffsetPresent != 0 {
+				offset = base + int64(int32(next()))
+			}
+			if flags&trunFirstSampleFlagsPresent != 0 {
+				next()
+			}
+			perSample := 0
+			for _, flag := range []uint32{trunSampleDurationPresent, trunSampleSizePresent, trunSampleFlagsPresent, trunSampleCompositionPresent} {
+				if flags&flag != 0 {
+					perSample += 4
+				}
+			}
+			if len(fields) < count*perSample {
+				return nil, nil, nil, errors.New("truncated trun box")
+			}
+
+			for i := 0; i < count; i++ {
+				duration, size := defaults.duration, defaults.size
+				if flags&trunSampleDurationPresent != 0 {
+					duration = next()
+				}
+				if flags&trunSampleSizePresent != 0 {
+					size = next()
+				}
+				if flags&trunSampleFlagsPresent != 0 {
+					next()
+				}
+				if flags&trunSampleCompositionPresent != 0 {
+					next()
+				}
+				start := offset - payloadStart
+				if start < 0 || start+int64(size) > int64(len(mdat[0].payload)) {
+					return nil, nil, nil, errors.New("sample out of mdat")
+				}
+				data = append(data, mdat[0].payload[start:start+int64(size)]...)
+				sizes = append(sizes, size)
+				durations = append(durations, duration)
+				offset += int64(size)
+			}
+		}
+	}
+	return sizes, durations, data, nil
+}
+
+// m4aMoov builds moov of an m4a from moov of a fragmented MP4.
+// Samples are in a single chunk at chunkOffset.
+func m4aMoov(in *fmp4Reader, sizes, durations []uint32, chunkOffset uint64) ([]byte, error) {
+	var total uint64
+	for _, d := range durations {
+		total += uint64(d)
+	}
+	boxes, err := parseMP4Boxes(in.moov.payload)
+	if err != nil {
+		return nil, err
+	}
+	mvhd := childBox(boxes, "mvhd")
+	if mvhd == nil {
+		return nil, errors.New("no mvhd box found")
+	}
+	movieTimescale, _, err := movieTime(mvhd)
+	if err != nil {
+		return nil, err
+	}
+	movieDuration := total * uint64(movieTimescale) / uint64(in.timescale)
+	setMovieDuration(mvhd, movieDuration)
+	trak := childBox(boxes, "trak")
+	if trak == nil {
+		return nil, errors.New("no trak box found")
+	}
+	setTrackDuration(trak, movieDuration, in.timescale, movieTimescale)
+	if mdhd := findMP4Box(trak.payload, "mdia", "mdhd"); mdhd != nil {
+		setMovieDuration(mdhd, total) // mdhd has the same layout as mvhd up to duration
+	}
+
+	stsd := findMP4Box(trak.payload, "mdia", "minf", "stbl", "stsd")
+	if stsd == nil {
+		return nil, errors.New("no stsd box found")
+	}
+	stbl := makeMP4Box("stbl",
+		stsd.bytes(),
+		makeMP4Box("stts", timeToSample(durations)),
+		makeMP4Box("stsc", fullBoxPayload(0, 0, uint32(1), uint32(1), uint32(len(sizes)), uint32(1))),
+		makeMP4Box("stsz", fullBoxPayload(0, 0, uint32(0), uint32(len(sizes)), sizes)),
+		chunkOffsetBox(chunkOffset),
+	)
+
+	moov, err := replaceMP4Box(in.moov.payload, []string{"trak", "mdia", "minf", "stbl"}, func(*mp4Box) []byte { return stbl })
+	if err != nil {
+		return nil, err
+	}
+	moov, err = replaceMP4Box(moov, []string{"mvex"}, func(*mp4Box) []byte { return nil })
+	if err != nil {
+		return nil, err
+	}
+	return makeMP4Box("moov", moov), nil
+}
+
+// setTrackDuration sets a duration in the movie timescale to tkhd of a trak.
+// Edits of unspecified durations, which last to the end in a fragmented MP4, are given the rest of the duration.
+func setTrackDuration(trak *mp4Box, duration uint64, mediaTimescale, movieTimescale uint32) {
+	if tkhd := findMP4Box(trak.payload, "tkhd"); tkhd != nil && len(tkhd.payload) >= 4 {
+		p := tkhd.payload
+		if p[0] == 1 && len(p) >= 36 {
+			binary.BigEndian.PutUint64(p[28:36], duration)
+		} else if p[0] == 0 && len(p) >= 24 {
+			binary.BigEndian.PutUint32(p[20:24], uint32(duration))
+		}
+	}
+
+	elst := findMP4Box(trak.payload, "edts", "elst")
+	if elst == nil || len(elst.payload) < 8 || elst.payload[0] != 0 {
+		return
+	}
+	p := elst.payload
+	count := int(binary.BigEndian.Uint32(p[4:8]))
+	for i := 0; i < count && 8+(i+1)*12 <= len(p); i++ {
+		entry := p[8+i*12:]
+		mediaTime := int32(binary.BigEndian.Uint32(entry[4:8]))
+		if binary.BigEndian.Uint32(entry[0:4]) != 0 || mediaTime < 0 {
+			continue
+		}
+		skipped := uint64(mediaTime) * uint64(movieTimescale) / uint64(mediaTimescale)
+		if skipped < duration {
+			binary.BigEndian.PutUint32(entry[0:4], uint32(duration-skipped))
+		}
+	}
+}
+
+// timeToSample encodes a payload of stts compressing runs of the same duration
+func timeToSample(durations []uint32) []byte {
+	var entries []uint32
+	for i, d := range durations {
+		if i > 0 && d == entries[len(entries)-1] {
+			entries[len(entries)-2]++
+			continue
+		}
+		entries = append(entries, 1, d)
+	}
+	return fullBoxPayload(0, 0, uint32(len(entries)/2), entries)
+}
+
+// chunkOffsetBox encodes stco, or co64 if the offset does not fit in 32 bits
+func chunkOffsetBox(offset uint64) []byte {
+	if offset > math.MaxUint32 {
+		return makeMP4Box("co64", fullBoxPayload(0, 0, uint32(1), offset))
+	}
+	return makeMP4Box("stco", fullBoxPayload(0, 0, uint32(1), uint32(offset)))
+}
+
+// fullBoxPayload encodes a version, flags and fields of a full box in big endian
+func fullBoxPayload(version byte, flags uint32, fields ...interface{}) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint32(version)<<24|flags)
+	for _, f := range fields {
+		binary.Write(buf, binary.BigEndian, f)
+	}
+	return buf.Bytes()
+}
+
+// replaceMP4Box rebuilds boxes in data with a box at a given path replaced by the result of replace.
+// The box is removed if replace returns nil.
+func replaceMP4Box(data []byte, path []string, replace func(*mp4Box) []byte) ([]byte, error) {
+	boxes, err := parseMP4Boxes(data)
+	if err != nil {
+		return nil, err
+	}
+	out := []byte{}
+	for _, b := range boxes {
+		if b.typ != path[0] {
+			out = append(out, b.bytes()...)
+			continue
+		}
+		if len(path) == 1 {
+			out = append(out, replace(b)...)
+			continue
+		}
+		payload, err := replaceMP4Box(b.payload, path[1:], replace)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, makeMP4Box(b.typ, payload)...)
+	}
+	return out, nil
+}
@@ -0,0 +1,90 @@
+package gotube
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// m4aSamples returns samples of an m4a following stsz and stco, which has a single chunk
+func m4aSamples(t *testing.T, data []byte) [][]byte {
+	stbl := findMP4Box(data, "moov", "trak", "mdia", "minf", "stbl")
+	if stbl == nil {
+		t.Fatal("no stbl in the output")
+	}
+	stsz := findMP4Box(stbl.payload, "stsz")
+	stco := findMP4Box(stbl.payload, "stco")
+	if stsz == nil || stco == nil {
+		t.Fatal("no stsz or stco in the output")
+	}
+	offset := int(binary.BigEndian.Uint32(stco.payload[8:12]))
+	count := int(binary.BigEndian.Uint32(stsz.payload[8:12]))
+	var samples [][]byte
+	for i := 0; i < count; i++ {
+		size := int(binary.BigEndian.Uint32(stsz.payload[12+i*4:]))
+		samples = append(samples, data[offset:offset+size])
+		offset += size
+	}
+	return samples
+}
+
+func TestWriteM4A(t *testing.T) {
+	audio := testFMP4(1, 1000, 44100, 0, "soun", []testFragment{
+		{0, []byte("audio0")},
+		{1024, []byte("audio1")},
+		{2048, []byte("audio2")},
+	})
+	expected := [][]byte{[]byte("audio0"), []byte("audio1"), []byte("audio2")}
+
+	buf := new(bytes.Buffer)
+	if err := writeM4A(buf, bytes.NewReader(audio)); err != nil {
+		t.Fatalf("rewrap failed, %s", err)
+	}
+	out := buf.Bytes()
+	if ftyp := findMP4Box(out, "ftyp"); ftyp == nil || string(ftyp.payload[:4]) != "M4A " {
+		t.Error("no ftyp of m4a in the output")
+	}
+	if findMP4Box(out, "moov", "mvex") != nil || findMP4Box(out, "moof") != nil {
+		t.Error("boxes of a fragmented mp4 are left in the output")
+	}
+	if samples := m4aSamples(t, out); !reflect.DeepEqual(samples, expected) {
+		t.Errorf("got samples %q, expected %q", samples, expected)
+	}
+
+	stts := findMP4Box(out, "moov", "trak", "mdia", "minf", "stbl", "stts")
+	if !bytes.Equal(stts.payload, fullBoxPayload(0, 0, uint32(1), uint32(3), uint32(1024))) {
+		t.Errorf("got stts %v, expected 3 samples of 1024", stts.payload)
+	}
+	mdhd := findMP4Box(out, "moov", "trak", "mdia", "mdhd")
+	if d := binary.BigEndian.Uint32(mdhd.payload[16:20]); d != 3072 {
+		t.Errorf("got media duration %d, expected 3072", d)
+	}
+	if _, d, _ := movieTime(findMP4Box(out, "moov", "mvhd")); d != 3072*1000/44100 {
+		t.Errorf("got movie duration %d, expected %d", d, 3072*1000/44100)
+	}
+
+	// mdat is written before its size is known if the output is seekable
+	f, err := ioutil.TempFile("", "gotube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := writeM4A(f, bytes.NewReader(audio)); err != nil {
+		t.Fatalf("rewrap into a file failed, %s", err)
+	}
+	f.Close()
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseMP4Boxes(data); err != nil {
+		t.Fatalf("invalid output, %s", err)
+	}
+	if samples := m4aSamples(t, data); !reflect.DeepEqual(samples, expected) {
+		t.Errorf("got samples %q in a file, expected %q", samples, expected)
+	}
+}
@@ -0,0 +1,248 @@
+package gotube
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+// mp4Box is a box of ISO base media file format (MP4).
+type mp4Box struct {
+	typ     string
+	payload []byte // content after the header
+	size    int64  // size in the input including the header, which may differ from the size of bytes()
+}
+
+// readMP4Box reads a whole box from r.
+// io.EOF is returned only when r has no more boxes.
+func readMP4Box(r io.Reader) (*mp4Box, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	size := uint64(binary.BigEndian.Uint32(header[:4]))
+	typ := string(header[4:8])
+	headerSize := uint64(8)
+
+	switch size {
+	case 0:
+		// the box extends to the end of the stream
+		payload, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return &mp4Box{typ: typ, payload: payload, size: int64(headerSize) + int64(len(payload))}, nil
+	case 1:
+		largeSize := make([]byte, 8)
+		if _, err := io.ReadFull(r, largeSize); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		size = binary.BigEndian.Uint64(largeSize)
+		headerSize = 16
+	}
+	if size < headerSize || size > math.MaxInt64 {
+		return nil, fmt.Errorf("invalid size %d of box %s", size, typ)
+	}
+
+	// the buffer grows as the payload arrives, so a broken size does not allocate memory at once
+	payload := new(bytes.Buffer)
+	if _, err := io.CopyN(payload, r, int64(size-headerSize)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return &mp4Box{typ: typ, payload: payload.Bytes(), size: int64(size)}, nil
+}
+
+// parseMP4Boxes splits data into boxes
+func parseMP4Boxes(data []byte) ([]*mp4Box, error) {
+	boxes := []*mp4Box{}
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("truncated box header")
+		}
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errors.New("truncated box header")
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid size %d of box %s", size, data[4:8])
+		}
+		boxes = append(boxes, &mp4Box{typ: string(data[4:8]), payload: data[headerSize:size], size: int64(size)})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// findMP4Box returns the first box following a path of box types in data, or nil if not found.
+func findMP4Box(data []byte, path ...string) *mp4Box {
+	boxes, err := parseMP4Boxes(data)
+	if err != nil {
+		return nil
+	}
+	for _, b := range boxes {
+		if b.typ != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return b
+		}
+		if found := findMP4Box(b.payload, path[1:]...); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// bytes returns an encoded box
+func (b *mp4Box) bytes() []byte {
+	return makeMP4Box(b.typ, b.payload)
+}
+
+// makeMP4Box encodes a box of a given type whose payload is concatenation of payloads.
+// The size is given in the large size field for a box of 4GiB or more.
+func makeMP4Box(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+	var data []byte
+	if uint64(size) > math.MaxUint32 {
+		size += 8
+		data = make([]byte, 16, size)
+		binary.BigEndian.PutUint32(data[:4], 1)
+		binary.BigEndian.PutUint64(data[8:16], uint64(size))
+	} else {
+		data = make([]byte, 8, size)
+		binary.BigEndian.PutUint32(data[:4], uint32(size))
+	}
+	copy(data[4:8], typ)
+	for _, p := range payloads {
+		data = append(data, p...)
+	}
+	return data
+}
+
+// sidxReference is a reference to a subsegment in a segment index box
+type sidxReference struct {
+	size     int
+	duration uint32 // in timescale of the index
+}
+
+// segmentIndex is a parsed segment index box (sidx)
+type segmentIndex struct {
+	timescale    uint32
+	earliestTime uint64
+	firstOffset  uint64
+	references   []sidxReference
+}
+
+// parseSidx parses a payload of a sidx box
+func parseSidx(payload []byte) (*segmentIndex, error) {
+	errTruncated := errors.New("truncated sidx box")
+	if len(payload) < 12 {
+		return nil, errTruncated
+	}
+	version := payload[0]
+	idx := &segmentIndex{timescale: binary.BigEndian.Uint32(payload[8:12])}
+	p := payload[12:]
+	if version == 0 {
+		if len(p) < 8 {
+			return nil, errTruncated
+		}
+		idx.earliestTime = uint64(binary.BigEndian.Uint32(p[0:4]))
+		idx.firstOffset = uint64(binary.BigEndian.Uint32(p[4:8]))
+		p = p[8:]
+	} else {
+		if len(p) < 16 {
+			return nil, errTruncated
+		}
+		idx.earliestTime = binary.BigEndian.Uint64(p[0:8])
+		idx.firstOffset = binary.BigEndian.Uint64(p[8:16])
+		p = p[16:]
+	}
+	if len(p) < 4 {
+		return nil, errTruncated
+	}
+	count := int(binary.BigEndian.Uint16(p[2:4]))
+	p = p[4:]
+	if len(p) < count*12 {
+		return nil, errTruncated
+	}
+	if idx.timescale == 0 {
+		return nil, errors.New("sidx box has zero timescale")
+	}
+
+	for i := 0; i < count; i++ {
+		ref := p[i*12 : (i+1)*12]
+		sizeField := binary.BigEndian.Uint32(ref[0:4])
+		if sizeField>>31 == 1 {
+			return nil, errors.New("hierarchical sidx is not supported")
+		}
+		idx.references = append(idx.references, sidxReference{
+			size:     int(sizeField & 0x7FFFFFFF),
+			duration: binary.BigEndian.Uint32(ref[4:8]),
+		})
+	}
+	return idx, nil
+}
+
+// mp4Segments converts an index range containing a sidx box into media segments.
+// indexStart is an offset of the index range in the stream.
+func mp4Segments(index []byte, indexStart int) ([]mediaSegment, error) {
+	boxes, err := parseMP4Boxes(index)
+	if err != nil {
+		return nil, err
+	}
+	offset := indexStart
+	for _, b := range boxes {
+		offset += int(b.size)
+		if b.typ != "sidx" {
+			continue
+		}
+		idx, err := parseSidx(b.payload)
+		if err != nil {
+			return nil, err
+		}
+
+		// offsets of references are relative to the first byte after the sidx box
+		start := offset + int(idx.firstOffset)
+		t := idx.earliestTime
+		segments := make([]mediaSegment, 0, len(idx.references))
+		for _, ref := range idx.references {
+			segments = append(segments, mediaSegment{
+				start:    start,
+				end:      start + ref.size,
+				time:     scaleTime(t, idx.timescale),
+				duration: scaleTime(uint64(ref.duration), idx.timescale),
+			})
+			start += ref.size
+			t += uint64(ref.duration)
+		}
+		return segments, nil
+	}
+	return nil, errors.New("no sidx box found in the index range")
+}
+
+// scaleTime converts a time in a given timescale (units per second) to time.Duration
+func scaleTime(t uint64, timescale uint32) time.Duration {
+	return time.Duration(float64(t) / float64(timescale) * float64(time.Second))
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
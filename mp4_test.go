@@ -0,0 +1,113 @@
+package gotube
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sidxBox builds a version 0 sidx box with timescale 1000 whose references have given sizes and durations (ms)
+func sidxBox(firstOffset uint32, sizes []int, durations []uint32) []byte {
+	payload := make([]byte, 24, 24+12*len(sizes))
+	binary.BigEndian.PutUint32(payload[4:8], 1)     // reference id
+	binary.BigEndian.PutUint32(payload[8:12], 1000) // timescale
+	binary.BigEndian.PutUint32(payload[16:20], firstOffset)
+	binary.BigEndian.PutUint16(payload[22:24], uint16(len(sizes)))
+	for i := range sizes {
+		ref := make([]byte, 12)
+		binary.BigEndian.PutUint32(ref[0:4], uint32(sizes[i]))
+		binary.BigEndian.PutUint32(ref[4:8], durations[i])
+		binary.BigEndian.PutUint32(ref[8:12], 0x90000000) // starts with SAP of type 1
+		payload = append(payload, ref...)
+	}
+	return makeMP4Box("sidx", payload)
+}
+
+func TestMP4Boxes(t *testing.T) {
+	data := append(makeMP4Box("ftyp", []byte("dash")), makeMP4Box("moov", makeMP4Box("trak", makeMP4Box("tkhd", []byte{1, 2, 3})))...)
+
+	boxes, err := parseMP4Boxes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(boxes) != 2 || boxes[0].typ != "ftyp" || boxes[1].typ != "moov" {
+		t.Fatalf("got unexpected boxes %v", boxes)
+	}
+	if !bytes.Equal(boxes[0].bytes(), data[:12]) {
+		t.Errorf("encoded box %v differs from the original %v", boxes[0].bytes(), data[:12])
+	}
+
+	tkhd := findMP4Box(data, "moov", "trak", "tkhd")
+	if tkhd == nil || !bytes.Equal(tkhd.payload, []byte{1, 2, 3}) {
+		t.Errorf("got tkhd %v, expected payload [1 2 3]", tkhd)
+	}
+	if findMP4Box(data, "moov", "mvex") != nil {
+		t.Error("found a box which does not exist")
+	}
+
+	r := bytes.NewReader(data)
+	for _, typ := range []string{"ftyp", "moov"} {
+		b, err := readMP4Box(r)
+		if err != nil || b.typ != typ {
+			t.Fatalf("read box %v, %v, expected %s", b, err, typ)
+		}
+	}
+	if _, err := readMP4Box(r); err != io.EOF {
+		t.Errorf("got %v at the end, expected EOF", err)
+	}
+
+	if _, err := parseMP4Boxes(data[:len(data)-1]); err == nil {
+		t.Error("truncated data should be an error")
+	}
+}
+
+func TestMP4Segments(t *testing.T) {
+	index := sidxBox(10, []int{100, 200, 150}, []uint32{5000, 5000, 2500})
+	indexStart := 700
+
+	segments, err := mp4Segments(index, indexStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := indexStart + len(index) + 10
+	expected := []mediaSegment{
+		{start: first, end: first + 100, time: 0, duration: 5 * time.Second},
+		{start: first + 100, end: first + 300, time: 5 * time.Second, duration: 5 * time.Second},
+		{start: first + 300, end: first + 450, time: 10 * time.Second, duration: 2500 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Errorf("got segments %v, expected %v", segments, expected)
+	}
+
+	if _, err := mp4Segments(makeMP4Box("free", nil), indexStart); err == nil {
+		t.Error("index without sidx should be an error")
+	}
+	if _, err := mp4Segments(index[:len(index)-4], indexStart); err == nil {
+		t.Error("truncated sidx should be an error")
+	}
+
+	// a box with the large size header before sidx shifts segments by its size in the index
+	large := []byte{0, 0, 0, 1, 'f', 'r', 'e', 'e', 0, 0, 0, 0, 0, 0, 0, 20, 1, 2, 3, 4}
+	segments, err = mp4Segments(append(large, index...), indexStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segments[0].start != first+len(large) {
+		t.Errorf("got the first segment from %d, expected %d", segments[0].start, first+len(large))
+	}
+}
+
+func TestReadMP4BoxOfBrokenSize(t *testing.T) {
+	// a size larger than the input is an error without allocating it
+	data := []byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 1, 2, 3}
+	if _, err := readMP4Box(bytes.NewReader(data)); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, expected %v", err, io.ErrUnexpectedEOF)
+	}
+	data = []byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	if _, err := readMP4Box(bytes.NewReader(data)); err == nil {
+		t.Error("size overflowing int64 is accepted")
+	}
+}
@@ -0,0 +1,432 @@
+package gotube
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	tfhdBaseDataOffsetPresent = 0x000001
+)
+
+// MuxMP4 downloads a video stream and an audio stream in fragmented MP4, such as DASH streams of itag 137 and 140,
+// and writes one fragmented MP4 containing both tracks to w.
+// Fragments of the streams are interleaved in order of their decode time as they arrive,
+// so neither stream is held in memory as a whole.
+func MuxMP4(w io.Writer, video, audio *Stream) error {
+	return MuxMP4Context(context.Background(), w, video, audio)
+}
+
+// MuxMP4Context is MuxMP4 with a context.
+// Downloads of both streams are canceled when ctx is done or muxing fails.
+func MuxMP4Context(ctx context.Context, w io.Writer, video, audio *Stream) error {
+	if video.Format != "mp4" || audio.Format != "mp4" {
+		return fmt.Errorf("cannot mux %s video and %s audio into mp4", video.Format, audio.Format)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	videoReader := video.pipe(ctx)
+	defer videoReader.Close()
+	audioReader := audio.pipe(ctx)
+	defer audioReader.Close()
+
+	if err := muxFragmentedMP4(w, videoReader, audioReader); err != nil {
+		return err
+	}
+	logger.print("mux completed")
+	return nil
+}
+
+// pipe returns a reader of the stream content downloaded in parallel.
+// Closing the reader stops the download.
+func (s *Stream) pipe(ctx context.Context) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(s.ParallelDownloadToContext(ctx, pw, defaultMaxBufferedBytes/2))
+	}()
+	return pr
+}
+
+// muxFragmentedMP4 merges two fragmented MP4 of a single track into one with two tracks.
+// Track ids are renumbered to 1 for video and 2 for audio.
+func muxFragmentedMP4(w io.Writer, video, audio io.Reader) error {
+	inputs := []*fmp4Reader{{r: video}, {r: audio}}
+	for i, in := range inputs {
+		if err := in.readInit(uint32(i + 1)); err != nil {
+			return fmt.Errorf("invalid %s, %s", [...]string{"video", "audio"}[i], err)
+		}
+	}
+
+	cw := &countingWriter{w: w}
+	moov, err := mergeMoov(inputs[0], inputs[1])
+	if err != nil {
+		return err
+	}
+	if _, err := cw.Write(inputs[0].ftyp); err != nil {
+		return err
+	}
+	if _, err := cw.Write(moov); err != nil {
+		return err
+	}
+
+	// write fragments in order of decode time
+	next := make([]*fmp4Fragment, len(inputs))
+	for i, in := range inputs {
+		if next[i], err = in.readFragment(); err != nil {
+			return err
+		}
+	}
+	sequence := uint32(1)
+	for {
+		i := -1
+		for j, f := range next {
+			if f != nil && (i < 0 || f.time < next[i].time) {
+				i = j
+			}
+		}
+		if i < 0 {
+			return nil
+		}
+
+		f := next[i]
+		if err := f.relocate(sequence, inputs[i].trackID, cw.n); err != nil {
+			return err
+		}
+		if _, err := cw.Write(f.moof); err != nil {
+			return err
+		}
+		if _, err := cw.Write(f.mdat); err != nil {
+			return err
+		}
+		sequence++
+		if next[i], err = inputs[i].readFragment(); err != nil {
+			return err
+		}
+	}
+}
+
+// fmp4Reader reads a fragmented MP4 of a single track
+type fmp4Reader struct {
+	r         io.Reader
+	pos       int64 // offset of the next box
+	trackID   uint32
+	timescale uint32 // of the track
+	ftyp      []byte
+	moov      *mp4Box
+	lastTime  float64
+}
+
+// fmp4Fragment is a pair of moof and mdat
+type fmp4Fragment struct {
+	moof    []byte
+	mdat    []byte
+	moofPos int64   // offset of moof in the input
+	time    float64 // decode time in seconds
+}
+
+// readBox reads the next box and its encoded bytes
+func (fr *fmp4Reader) readBox() (*mp4Box, []byte, error) {
+	b, err := readMP4Box(fr.r)
+	if err != nil {
+		return nil, nil, err
+	}
+	fr.pos += b.size
+	return b, b.bytes(), nil
+}
+
+// readInit reads boxes up to moov and gives trackID to the track
+func (fr *fmp4Reader) readInit(trackID uint32) error {
+	fr.trackID = trackID
+	for fr.moov == nil {
+		b, data, err := fr.readBox()
+		if err != nil {
+			return fmt.Errorf("failed to read init boxes, %s", unexpectedEOF(err))
+		}
+		switch b.typ {
+		case "ftyp":
+			fr.ftyp = data
+		case "moov":
+			fr.moov = b
+		}
+	}
+	if fr.ftyp == nil {
+		return errors.New("no ftyp box found")
+	}
+
+	children, err := parseMP4Boxes(fr.moov.payload)
+	if err != nil {
+		return fmt.Errorf("invalid moov box, %s", err)
+	}
+	traks := 0
+	for _, c := range children {
+		if c.typ == "trak" {
+			traks++
+		}
+	}
+	if traks != 1 {
+		return fmt.Errorf("%d tracks found, expected a single track", traks)
+	}
+	if findMP4Box(fr.moov.payload, "mvex") == nil {
+		return errors.New("not a fragmented mp4, no mvex box found")
+	}
+	mdhd := findMP4Box(fr.moov.payload, "trak", "mdia", "mdhd")
+	if mdhd == nil {
+		return errors.New("no mdhd box found")
+	}
+	if len(mdhd.payload) < 4 {
+		return errors.New("truncated mdhd box")
+	}
+	timescaleOffset := 12
+	if mdhd.payload[0] == 1 {
+		timescaleOffset = 20
+	}
+	if len(mdhd.payload) < timescaleOffset+4 {
+		return errors.New("truncated mdhd box")
+	}
+	fr.timescale = binary.BigEndian.Uint32(mdhd.payload[timescaleOffset:])
+	if fr.timescale == 0 {
+		return errors.New("track has zero timescale")
+	}
+	return nil
+}
+
+// readFragment returns the next moof and mdat, or nil at the end of the input
+func (fr *fmp4Reader) readFragment() (*fmp4Fragment, error) {
+	var f *fmp4Fragment
+	for {
+		pos := fr.pos
+		b, data, err := fr.readBox()
+		if err == io.EOF {
+			if f != nil {
+				return nil, errors.New("moof box without mdat box")
+			}
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fragment, %s", err)
+		}
+
+		switch b.typ {
+		case "moof":
+			f = &fmp4Fragment{moof: data, moofPos: pos, time: fr.lastTime}
+			if t, ok := fragmentDecodeTime(b.payload); ok {
+				f.time = float64(t) / float64(fr.timescale)
+				fr.lastTime = f.time
+			}
+		case "mdat":
+			if f == nil {
+				return nil, errors.New("mdat box without moof box")
+			}
+			f.mdat = data
+			return f, nil
+		}
+		// other boxes such as sidx, styp and mfra are dropped
+	}
+}
+
+// fragmentDecodeTime returns baseMediaDecodeTime in tfdt of a moof payload
+func fragmentDecodeTime(moof []byte) (uint64, bool) {
+	tfdt := findMP4Box(moof, "traf", "tfdt")
+	if tfdt == nil || len(tfdt.payload) < 8 {
+		return 0, false
+	}
+	if tfdt.payload[0] == 1 {
+		if len(tfdt.payload) < 12 {
+			return 0, false
+		}
+		return binary.BigEndian.Uint64(tfdt.payload[4:12]), true
+	}
+	return uint64(binary.BigEndian.Uint32(tfdt.payload[4:8])), true
+}
+
+// relocate rewrites the sequence number and track id of the fragment written at a given offset.
+// Sizes of boxes are unchanged, so data offsets relative to moof stay valid.
+func (f *fmp4Fragment) relocate(sequence, trackID uint32, pos int64) error {
+	// the header of moof is 8 bytes since it is encoded by makeMP4Box
+	boxes, err := parseMP4Boxes(f.moof[8:])
+	if err != nil {
+		return fmt.Errorf("invalid moof box, %s", err)
+	}
+	for _, b := range boxes {
+		switch b.typ {
+		case "mfhd":
+			if len(b.payload) < 8 {
+				return errors.New("truncated mfhd box")
+			}
+			binary.BigEndian.PutUint32(b.payload[4:8], sequence)
+		case "traf":
+			tfhd := findMP4Box(b.payload, "tfhd")
+			if tfhd == nil || len(tfhd.payload) < 8 {
+				return errors.New("no valid tfhd box found")
+			}
+			binary.BigEndian.PutUint32(tfhd.payload[4:8], trackID)
+			flags := binary.BigEndian.Uint32(tfhd.payload[0:4]) & 0xFFFFFF
+			if flags&tfhdBaseDataOffsetPresent != 0 {
+				if len(tfhd.payload) < 16 {
+					return errors.New("truncated tfhd box")
+				}
+				// base data offset is an absolute position in the file
+				base := int64(binary.BigEndian.Uint64(tfhd.payload[8:16]))
+				binary.BigEndian.PutUint64(tfhd.payload[8:16], uint64(base-f.moofPos+pos))
+			}
+		}
+	}
+	return nil
+}
+
+// mergeMoov builds moov containing tracks of both inputs.
+// Durations of the audio track are converted to the movie timescale of the video.
+func mergeMoov(video, audio *fmp4Reader) ([]byte, error) {
+	videoBoxes, err := parseMP4Boxes(video.moov.payload)
+	if err != nil {
+		return nil, err
+	}
+	audioBoxes, err := parseMP4Boxes(audio.moov.payload)
+	if err != nil {
+		return nil, err
+	}
+	videoMvhd, audioMvhd := childBox(videoBoxes, "mvhd"), childBox(audioBoxes, "mvhd")
+	if videoMvhd == nil || audioMvhd == nil {
+		return nil, errors.New("no mvhd box found")
+	}
+	videoTimescale, videoDuration, err := movieTime(videoMvhd)
+	if err != nil {
+		return nil, err
+	}
+	audioTimescale, audioDuration, err := movieTime(audioMvhd)
+	if err != nil {
+		return nil, err
+	}
+	rescale := func(d uint64) uint64 {
+		if audioTimescale == videoTimescale {
+			return d
+		}
+		return d * uint64(videoTimescale) / uint64(audioTimescale)
+	}
+
+	// the movie lasts as long as the longer track
+	if d := rescale(audioDuration); d > videoDuration {
+		setMovieDuration(videoMvhd, d)
+	}
+	binary.BigEndian.PutUint32(videoMvhd.payload[len(videoMvhd.payload)-4:], 3) // next_track_ID
+
+	payloads := [][]byte{videoMvhd.bytes()}
+	var trexes [][]byte
+	for i, boxes := range [][]*mp4Box{videoBoxes, audioBoxes} {
+		in := []*fmp4Reader{video, audio}[i]
+		trak := childBox(boxes, "trak")
+		if err := setTrackID(trak, in.trackID); err != nil {
+			return nil, err
+		}
+		if in == audio {
+			rescaleTrack(trak, rescale)
+		}
+		payloads = append(payloads, trak.bytes())
+
+		trex := findMP4Box(childBox(boxes, "mvex").payload, "trex")
+		if trex == nil || len(trex.payload) < 8 {
+			return nil, errors.New("no valid trex box found")
+		}
+		binary.BigEndian.PutUint32(trex.payload[4:8], in.trackID)
+		trexes = append(trexes, trex.bytes())
+	}
+	payloads = append(payloads, makeMP4Box("mvex", trexes...))
+
+	// keep other boxes of the video such as udta
+	for _, b := range videoBoxes {
+		switch b.typ {
+		case "mvhd", "trak", "mvex":
+		default:
+			payloads = append(payloads, b.bytes())
+		}
+	}
+	return makeMP4Box("moov", payloads...), nil
+}
+
+// childBox returns the first box of a given type among boxes, or nil if not found
+func childBox(boxes []*mp4Box, typ string) *mp4Box {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b
+		}
+	}
+	return nil
+}
+
+// movieTime returns timescale and duration in mvhd
+func movieTime(mvhd *mp4Box) (uint32, uint64, error) {
+	p := mvhd.payload
+	if len(p) < 32 {
+		return 0, 0, errors.New("truncated mvhd box")
+	}
+	if p[0] == 1 {
+		return binary.BigEndian.Uint32(p[20:24]), binary.BigEndian.Uint64(p[24:32]), nil
+	}
+	return binary.BigEndian.Uint32(p[12:16]), uint64(binary.BigEndian.Uint32(p[16:20])), nil
+}
+
+// setMovieDuration rewrites the duration in mvhd or mdhd, which are left as they are if truncated
+func setMovieDuration(mvhd *mp4Box, d uint64) {
+	if len(mvhd.payload) < 20 {
+		return
+	}
+	if mvhd.payload[0] == 1 {
+		if len(mvhd.payload) >= 32 {
+			binary.BigEndian.PutUint64(mvhd.payload[24:32], d)
+		}
+	} else {
+		binary.BigEndian.PutUint32(mvhd.payload[16:20], uint32(d))
+	}
+}
+
+// setTrackID rewrites track_ID in tkhd of a trak
+func setTrackID(trak *mp4Box, trackID uint32) error {
+	if trak == nil {
+		return errors.New("no trak box found")
+	}
+	tkhd := findMP4Box(trak.payload, "tkhd")
+	offset := 12
+	if tkhd != nil && len(tkhd.payload) >= 4 && tkhd.payload[0] == 1 {
+		offset = 20
+	}
+	if tkhd == nil || len(tkhd.payload) < offset+4 {
+		return errors.New("no valid tkhd box found")
+	}
+	binary.BigEndian.PutUint32(tkhd.payload[offset:offset+4], trackID)
+	return nil
+}
+
+// rescaleTrack converts durations in the movie timescale, those of tkhd and elst, in a trak
+func rescaleTrack(trak *mp4Box, rescale func(uint64) uint64) {
+	if tkhd := findMP4Box(trak.payload, "tkhd"); tkhd != nil && len(tkhd.payload) >= 4 {
+		p := tkhd.payload
+		if p[0] == 1 && len(p) >= 36 {
+			binary.BigEndian.PutUint64(p[28:36], rescale(binary.BigEndian.Uint64(p[28:36])))
+		} else if p[0] == 0 && len(p) >= 24 {
+			binary.BigEndian.PutUint32(p[20:24], uint32(rescale(uint64(binary.BigEndian.Uint32(p[20:24])))))
+		}
+	}
+
+	elst := findMP4Box(trak.payload, "edts", "elst")
+	if elst == nil || len(elst.payload) < 8 {
+		return
+	}
+	p := elst.payload
+	count := int(binary.BigEndian.Uint32(p[4:8]))
+	entrySize := 12
+	if p[0] == 1 {
+		entrySize = 20
+	}
+	for i := 0; i < count && 8+(i+1)*entrySize <= len(p); i++ {
+		entry := p[8+i*entrySize:]
+		if p[0] == 1 {
+			binary.BigEndian.PutUint64(entry[0:8], rescale(binary.BigEndian.Uint64(entry[0:8])))
+		} else {
+			binary.BigEndian.PutUint32(entry[0:4], uint32(rescale(uint64(binary.BigEndian.Uint32(entry[0:4])))))
+		}
+	}
+}
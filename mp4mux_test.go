@@ -0,0 +1,220 @@
+package gotube
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testFragment is a fragment of a synthetic fragmented mp4 with one sample
+type testFragment struct {
+	decodeTime uint64
+	sample     []byte
+}
+
+// testFMP4 builds a fragmented mp4 of a single track.
+// Fragments of an audio track designate an absolute base data offset.
+func testFMP4(trackID uint32, movieTimescale, timescale uint32, duration uint32, handler string, fragments []testFragment) []byte {
+	ftyp := makeMP4Box("ftyp", []byte("dash\x00\x00\x00\x00iso6mp41"))
+	mvhd := makeMP4Box("mvhd", fullBoxPayload(0, 0, uint32(0), uint32(0), movieTimescale, duration, make([]byte, 76), trackID+1))
+	tkhd := makeMP4Box("tkhd", fullBoxPayload(0, 3, uint32(0), uint32(0), trackID, uint32(0), duration, make([]byte, 60)))
+	elst := makeMP4Box("edts", makeMP4Box("elst", fullBoxPayload(0, 0, uint32(1), duration, uint32(0), uint32(0x10000))))
+	mdhd := makeMP4Box("mdhd", fullBoxPayload(0, 0, uint32(0), uint32(0), timescale, uint32(0), uint32(0x55C40000)))
+	hdlr := makeMP4Box("hdlr", fullBoxPayload(0, 0, uint32(0), []byte(handler), make([]byte, 13)))
+	stbl := makeMP4Box("stbl",
+		makeMP4Box("stsd", fullBoxPayload(0, 0, uint32(1), makeMP4Box("mp4a", make([]byte, 28)))),
+		makeMP4Box("stts", fullBoxPayload(0, 0, uint32(0))),
+		makeMP4Box("stsc", fullBoxPayload(0, 0, uint32(0))),
+		makeMP4Box("stsz", fullBoxPayload(0, 0, uint32(0), uint32(0))),
+		makeMP4Box("stco", fullBoxPayload(0, 0, uint32(0))),
+	)
+	trak := makeMP4Box("trak", tkhd, elst, makeMP4Box("mdia", mdhd, hdlr, makeMP4Box("minf", stbl)))
+	trex := makeMP4Box("trex", fullBoxPayload(0, 0, trackID, uint32(1), uint32(1024), uint32(0), uint32(0)))
+	moov := makeMP4Box("moov", mvhd, trak, makeMP4Box("mvex", trex))
+
+	data := append(ftyp, moov...)
+	data = append(data, makeMP4Box("sidx", fullBoxPayload(0, 0, trackID, timescale, uint32(0), uint32(0), uint32(0)))...)
+	for i, f := range fragments {
+		mfhd := makeMP4Box("mfhd", fullBoxPayload(0, 0, uint32(i+1)))
+		tfdt := makeMP4Box("tfdt", fullBoxPayload(1, 0, f.decodeTime))
+		var tfhd, trun []byte
+		if handler == "soun" {
+			tfhd = makeMP4Box("tfhd", fullBoxPayload(0, tfhdBaseDataOffsetPresent, trackID, uint64(0)))
+			trun = makeMP4Box("trun", fullBoxPayload(0, 0x201, uint32(1), int32(0), uint32(len(f.sample))))
+		} else {
+			tfhd = makeMP4Box("tfhd", fullBoxPayload(0, 0x020000, trackID))
+			trun = makeMP4Box("trun", fullBoxPayload(0, 0x201, uint32(1), int32(0), uint32(len(f.sample))))
+		}
+		moofSize := 8 + len(mfhd) + 8 + len(tfhd) + len(tfdt) + len(trun)
+		if handler == "soun" {
+			// base data offset points the sample in mdat, data offset is 0
+			binary.BigEndian.PutUint64(tfhd[16:24], uint64(len(data)+moofSize+8))
+		} else {
+			binary.BigEndian.PutUint32(trun[16:20], uint32(moofSize+8))
+		}
+		moof := makeMP4Box("moof", mfhd, makeMP4Box("traf", tfhd, tfdt, trun))
+		data = append(data, moof...)
+		data = append(data, makeMP4Box("mdat", f.sample)...)
+	}
+	return data
+}
+
+// samplesOf returns track ids and samples of fragments in a fragmented mp4 following data offsets
+func samplesOf(t *testing.T, data []byte) ([]uint32, [][]byte) {
+	boxes, err := parseMP4Boxes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trackIDs []uint32
+	var samples [][]byte
+	pos := 0
+	for _, b := range boxes {
+		if b.typ == "moof" {
+			tfhd := findMP4Box(b.payload, "traf", "tfhd")
+			trun := findMP4Box(b.payload, "traf", "trun")
+			trackIDs = append(trackIDs, binary.BigEndian.Uint32(tfhd.payload[4:8]))
+			base := pos
+			if binary.BigEndian.Uint32(tfhd.payload[0:4])&tfhdBaseDataOffsetPresent != 0 {
+				base = int(binary.BigEndian.Uint64(tfhd.payload[8:16]))
+			}
+			offset := base + int(int32(binary.BigEndian.Uint32(trun.payload[8:12])))
+			size := int(binary.BigEndian.Uint32(trun.payload[12:16]))
+			samples = append(samples, data[offset:offset+size])
+		}
+		pos += len(b.bytes())
+	}
+	return trackIDs, samples
+}
+
+func TestMuxFragmentedMP4(t *testing.T) {
+	video := testFMP4(1, 1000, 90000, 3000, "vide", []testFragment{
+		{0, []byte("video0")},
+		{90000, []byte("video1")},
+		{180000, []byte("video2")},
+	})
+	audio := testFMP4(1, 44100, 44100, 44100*3+1000, "soun", []testFragment{
+		{0, []byte("audio0")},
+		{66150, []byte("audio1")},
+	})
+
+	// samples of each input are found by data offsets
+	if _, samples := samplesOf(t, audio); !reflect.DeepEqual(samples, [][]byte{[]byte("audio0"), []byte("audio1")}) {
+		t.Fatalf("broken test input, %q", samples)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := muxFragmentedMP4(buf, bytes.NewReader(video), bytes.NewReader(audio)); err != nil {
+		t.Fatalf("mux failed, %s", err)
+	}
+	out := buf.Bytes()
+
+	moov := findMP4Box(out, "moov")
+	if moov == nil {
+		t.Fatal("no moov in the output")
+	}
+	moovBoxes, _ := parseMP4Boxes(moov.payload)
+	var trackIDs []uint32
+	for _, b := range moovBoxes {
+		if b.typ == "trak" {
+			tkhd := findMP4Box(b.payload, "tkhd")
+			trackIDs = append(trackIDs, binary.BigEndian.Uint32(tkhd.payload[12:16]))
+		}
+	}
+	if !reflect.DeepEqual(trackIDs, []uint32{1, 2}) {
+		t.Errorf("got tracks %v, expected [1 2]", trackIDs)
+	}
+	timescale, duration, _ := movieTime(childBox(moovBoxes, "mvhd"))
+	if timescale != 1000 || duration != 3022 {
+		t.Errorf("got movie duration %d in timescale %d, expected 3022 in 1000", duration, timescale)
+	}
+
+	ids, samples := samplesOf(t, out)
+	expectedIDs := []uint32{1, 2, 1, 2, 1}
+	expectedSamples := [][]byte{[]byte("video0"), []byte("audio0"), []byte("video1"), []byte("audio1"), []byte("video2")}
+	if !reflect.DeepEqual(ids, expectedIDs) || !reflect.DeepEqual(samples, expectedSamples) {
+		t.Errorf("got fragments of tracks %v with samples %q, expected %v and %q", ids, samples, expectedIDs, expectedSamples)
+	}
+
+	// sequence numbers are renumbered
+	boxes, _ := parseMP4Boxes(out)
+	sequence := uint32(1)
+	for _, b := range boxes {
+		if b.typ == "moof" {
+			mfhd := findMP4Box(b.payload, "mfhd")
+			if s := binary.BigEndian.Uint32(mfhd.payload[4:8]); s != sequence {
+				t.Errorf("got sequence number %d, expected %d", s, sequence)
+			}
+			sequence++
+		}
+		if b.typ == "sidx" {
+			t.Error("sidx of an input is left in the output")
+		}
+	}
+
+	notFragmented := append(makeMP4Box("ftyp", []byte("isom")), makeMP4Box("moov", makeMP4Box("trak"))...)
+	if err := muxFragmentedMP4(new(bytes.Buffer), bytes.NewReader(notFragmented), bytes.NewReader(audio)); err == nil {
+		t.Error("mp4 without mvex should be rejected")
+	}
+}
+
+func TestFMP4ReaderMalformedBoxes(t *testing.T) {
+	ftyp := makeMP4Box("ftyp", []byte("isom"))
+	mvex := makeMP4Box("mvex", makeMP4Box("trex"))
+	emptyMdhd := makeMP4Box("moov", makeMP4Box("trak", makeMP4Box("mdia", makeMP4Box("mdhd"))), mvex)
+	in := &fmp4Reader{r: bytes.NewReader(append(append([]byte{}, ftyp...), emptyMdhd...))}
+	if err := in.readInit(1); err == nil || !strings.Contains(err.Error(), "truncated mdhd box") {
+		t.Errorf("got %v, expected truncated mdhd box", err)
+	}
+
+	emptyTkhd := makeMP4Box("trak", makeMP4Box("tkhd"))
+	if err := setTrackID(&mp4Box{typ: "trak", payload: emptyTkhd[8:]}, 1); err == nil {
+		t.Error("empty tkhd is accepted")
+	}
+	rescaleTrack(&mp4Box{typ: "trak", payload: emptyTkhd[8:]}, func(d uint64) uint64 { return d })
+
+	// a box with the large size header is 16 bytes longer than its payload
+	large := []byte{0, 0, 0, 1, 'f', 'r', 'e', 'e', 0, 0, 0, 0, 0, 0, 0, 20, 1, 2, 3, 4}
+	in = &fmp4Reader{r: bytes.NewReader(large)}
+	if _, _, err := in.readBox(); err != nil || in.pos != int64(len(large)) {
+		t.Errorf("got position %d, %v after a box of %d bytes", in.pos, err, len(large))
+	}
+}
+
+func TestMuxMP4(t *testing.T) {
+	video := testFMP4(1, 1000, 90000, 2000, "vide", []testFragment{{0, []byte("video0")}, {90000, []byte("video1")}})
+	audio := testFMP4(1, 1000, 44100, 2000, "soun", []testFragment{{0, []byte("audio0")}, {44100, []byte("audio1")}})
+	var mu sync.Mutex
+	var requested []int
+	videoStream := &Stream{
+		url:    "https://foobar?itag=137&signature=geho",
+		Format: "mp4",
+		Retry:  &NoRetry,
+		client: rangeServingClient(video, nil, &requested, &mu),
+	}
+	audioStream := &Stream{
+		url:    "https://foobar?itag=140&signature=geho",
+		Format: "mp4",
+		Retry:  &NoRetry,
+		client: rangeServingClient(audio, nil, &requested, &mu),
+	}
+
+	expected := new(bytes.Buffer)
+	if err := muxFragmentedMP4(expected, bytes.NewReader(video), bytes.NewReader(audio)); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := MuxMP4(buf, videoStream, audioStream); err != nil {
+		t.Fatalf("mux failed, %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected.Bytes()) {
+		t.Error("muxed streams differ from muxed files")
+	}
+
+	audioStream.Format = "webm"
+	if err := MuxMP4(new(bytes.Buffer), videoStream, audioStream); err == nil {
+		t.Error("webm stream should be rejected")
+	}
+}
@@ -0,0 +1,277 @@
+package gotube
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// data types of iTunes style metadata items
+const (
+	itunesUTF8 = 1
+	itunesJPEG = 13
+	itunesPNG  = 14
+)
+
+// mp4BoxHeader is a position of a top level box in a file
+type mp4BoxHeader struct {
+	typ        string
+	offset     int64
+	size       int64 // including the header
+	headerSize int64
+}
+
+// scanMP4Boxes reads headers of top level boxes in a file of a given size without reading their payloads
+func scanMP4Boxes(r io.ReaderAt, size int64) ([]mp4BoxHeader, error) {
+	var headers []mp4BoxHeader
+	for offset := int64(0); offset < size; {
+		buf := make([]byte, 16)
+		n, _ := r.ReadAt(buf, offset)
+		if n < 8 {
+			return nil, fmt.Errorf("truncated box at %d", offset)
+		}
+		h := mp4BoxHeader{typ: string(buf[4:8]), offset: offset, size: int64(binary.BigEndian.Uint32(buf[0:4])), headerSize: 8}
+		switch h.size {
+		case 0:
+			h.size = size - offset
+		case 1:
+			if n < 16 {
+				return nil, fmt.Errorf("truncated box at %d", offset)
+			}
+			h.size, h.headerSize = int64(binary.BigEndian.Uint64(buf[8:16])), 16
+		}
+		if h.size < h.headerSize || offset+h.size > size {
+			return nil, fmt.Errorf("invalid %s box at %d", h.typ, offset)
+		}
+		headers = append(headers, h)
+		offset += h.size
+	}
+	return headers, nil
+}
+
+// tagMP4 replaces metadata in moov of an MP4 file.
+// The new moov is written in place if it fits in the space of the old one and a following free box,
+// otherwise the file is rewritten with offsets to media data shifted.
+func tagMP4(f *os.File, m *Metadata) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	boxes, err := scanMP4Boxes(f, info.Size())
+	if err != nil {
+		return err
+	}
+	i := -1
+	for j, b := range boxes {
+		if b.typ == "moov" {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		return errors.New("no moov box found")
+	}
+	moov := boxes[i]
+	payload := make([]byte, moov.size-moov.headerSize)
+	if _, err := f.ReadAt(payload, moov.offset+moov.headerSize); err != nil {
+		return err
+	}
+	newMoov, err := setMP4Tags(payload, m)
+	if err != nil {
+		return err
+	}
+
+	space := moov.size
+	last := i == len(boxes)-1
+	if !last && boxes[i+1].typ == "free" {
+		space += boxes[i+1].size
+		last = i+1 == len(boxes)-1
+	}
+	if last {
+		if _, err := f.WriteAt(newMoov, moov.offset); err != nil {
+			return err
+		}
+		return f.Truncate(moov.offset + int64(len(newMoov)))
+	}
+	if rest := space - int64(len(newMoov)); rest == 0 || rest >= 8 {
+		if rest > 0 {
+			newMoov = append(newMoov, makeMP4Box("free", make([]byte, rest-8))...)
+		}
+		_, err := f.WriteAt(newMoov, moov.offset)
+		return err
+	}
+	return rewriteMP4(f, boxes, i, newMoov, info.Mode())
+}
+
+// rewriteMP4 writes boxes of a file with moov replaced into a new file, which replaces the file.
+// Chunk offsets in moov and base data offsets in moof after moov are shifted by the growth of moov.
+func rewriteMP4(f *os.File, boxes []mp4BoxHeader, moovIndex int, newMoov []byte, mode os.FileMode) error {
+	moov := boxes[moovIndex]
+	delta := int64(len(newMoov)) - moov.size
+	if err := shiftChunkOffsets(newMoov[8:], moov.offset, delta); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.Name()), ".gotube")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	for i, b := range boxes {
+		switch {
+		case i == moovIndex:
+			_, err = tmp.Write(newMoov)
+		case b.typ == "moof" && i > moovIndex:
+			data := make([]byte, b.size)
+			if _, err := f.ReadAt(data, b.offset); err != nil {
+				return err
+			}
+			if err := shiftBaseDataOffsets(data[b.headerSize:], moov.offset, delta); err != nil {
+				return err
+			}
+			_, err = tmp.Write(data)
+		default:
+			_, err = io.Copy(tmp, io.NewSectionReader(f, b.offset, b.size))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Name())
+}
+
+// shiftChunkOffsets adds delta to chunk offsets after a given offset in stco and co64 of a moov payload
+func shiftChunkOffsets(moov []byte, after, delta int64) error {
+	boxes, err := parseMP4Boxes(moov)
+	if err != nil {
+		return err
+	}
+	for _, trak := range boxes {
+		if trak.typ != "trak" {
+			continue
+		}
+		stbl := findMP4Box(trak.payload, "mdia", "minf", "stbl")
+		if stbl == nil {
+			continue
+		}
+		if stco := findMP4Box(stbl.payload, "stco"); stco != nil && len(stco.payload) >= 8 {
+			count := int(binary.BigEndian.Uint32(stco.payload[4:8]))
+			for i := 0; i < count && 8+(i+1)*4 <= len(stco.payload); i++ {
+				entry := stco.payload[8+i*4:]
+				if offset := int64(binary.BigEndian.Uint32(entry)); offset > after {
+					binary.BigEndian.PutUint32(entry, uint32(offset+delta))
+				}
+			}
+		}
+		if co64 := findMP4Box(stbl.payload, "co64"); co64 != nil && len(co64.payload) >= 8 {
+			count := int(binary.BigEndian.Uint32(co64.payload[4:8]))
+			for i := 0; i < count && 8+(i+1)*8 <= len(co64.payload); i++ {
+				entry := co64.payload[8+i*8:]
+				if offset := int64(binary.BigEndian.Uint64(entry)); offset > after {
+					binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// shiftBaseDataOffsets adds delta to base data offsets after a given offset in tfhd of a moof payload
+func shiftBaseDataOffsets(moof []byte, after, delta int64) error {
+	boxes, err := parseMP4Boxes(moof)
+	if err != nil {
+		return fmt.Errorf("invalid moof box, %s", err)
+	}
+	for _, traf := range boxes {
+		if traf.typ != "traf" {
+			continue
+		}
+		tfhd := findMP4Box(traf.payload, "tfhd")
+		if tfhd == nil || len(tfhd.payload) < 8 {
+			return errors.New("no valid tfhd box found")
+		}
+		if binary.BigEndian.Uint32(tfhd.payload[0:4])&tfhdBaseDataOffsetPresent == 0 {
+			continue
+		}
+		if len(tfhd.payload) < 16 {
+			return errors.New("truncated tfhd box")
+		}
+		if base := int64(binary.BigEndian.Uint64(tfhd.payload[8:16])); base > after {
+			binary.BigEndian.PutUint64(tfhd.payload[8:16], uint64(base+delta))
+		}
+	}
+	return nil
+}
+
+// setMP4Tags returns moov whose udta has metadata in iTunes style items
+func setMP4Tags(moov []byte, m *Metadata) ([]byte, error) {
+	var items [][]byte
+	for _, item := range []struct {
+		typ   string
+		value string
+	}{
+		{"\xa9nam", m.Title},
+		{"\xa9ART", m.Author},
+		{"\xa9day", m.UploadDate},
+		{"desc", m.Description},
+		{"\xa9cmt", m.URL},
+	} {
+		if item.value != "" {
+			items = append(items, itunesItem(item.typ, itunesUTF8, []byte(item.value)))
+		}
+	}
+	if m.Thumbnail != nil {
+		dataType := uint32(itunesJPEG)
+		if isPNG(m.Thumbnail) {
+			dataType = itunesPNG
+		}
+		items = append(items, itunesItem("covr", dataType, m.Thumbnail))
+	}
+	hdlr := makeMP4Box("hdlr", fullBoxPayload(0, 0, uint32(0), []byte("mdirappl"), make([]byte, 9)))
+	meta := makeMP4Box("meta", fullBoxPayload(0, 0), hdlr, makeMP4Box("ilst", items...))
+
+	// keep other boxes in udta
+	udta := [][]byte{}
+	if old := findMP4Box(moov, "udta"); old != nil {
+		children, err := parseMP4Boxes(old.payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid udta box, %s", err)
+		}
+		for _, c := range children {
+			if c.typ != "meta" {
+				udta = append(udta, c.bytes())
+			}
+		}
+	}
+	udta = append(udta, meta)
+
+	boxes, err := parseMP4Boxes(moov)
+	if err != nil {
+		return nil, fmt.Errorf("invalid moov box, %s", err)
+	}
+	payloads := make([][]byte, 0, len(boxes)+1)
+	for _, b := range boxes {
+		if b.typ != "udta" {
+			payloads = append(payloads, b.bytes())
+		}
+	}
+	payloads = append(payloads, makeMP4Box("udta", udta...))
+	return makeMP4Box("moov", payloads...), nil
+}
+
+// itunesItem encodes an item of ilst with a data box
+func itunesItem(typ string, dataType uint32, value []byte) []byte {
+	return makeMP4Box(typ, makeMP4Box("data", fullBoxPayload(0, dataType, uint32(0), value)))
+}
@@ -0,0 +1,235 @@
+package gotube
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	oggHeaderBOS = 0x02
+	oggHeaderEOS = 0x04
+	// oggPageSamples is the duration of audio in a page, 1 second in 48 kHz
+	oggPageSamples = 48000
+	opusVendor     = "gotube"
+)
+
+// oggCRCTable is a table of CRC-32 of polynomial 0x04C11DB7 without reflection, used in Ogg pages
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04C11DB7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// writeOggOpus rewraps Opus packets in a WebM of a single track into an Ogg Opus stream.
+// Granule positions count 48 kHz samples of the packets, and discarded padding at the end is trimmed.
+func writeOggOpus(w io.Writer, r io.Reader) error {
+	in := &webmReader{r: r}
+	if err := in.readInit(); err != nil {
+		return fmt.Errorf("invalid audio, %s", err)
+	}
+	var codec string
+	var head []byte
+	for _, c := range in.track {
+		switch c.id {
+		case ebmlIDCodecID:
+			codec = string(c.data)
+		case ebmlIDCodecPrivate:
+			head = c.data
+		}
+	}
+	if codec != "A_OPUS" {
+		return fmt.Errorf("cannot extract %s audio into ogg", codec)
+	}
+	if !bytes.HasPrefix(head, []byte("OpusHead")) {
+		return errors.New("no OpusHead found in CodecPrivate")
+	}
+
+	ow := &oggWriter{w: w, serial: uint32(in.trackUID)}
+	// each header packet is in a page of its own
+	tags := new(bytes.Buffer)
+	tags.WriteString("OpusTags")
+	binary.Write(tags, binary.LittleEndian, uint32(len(opusVendor)))
+	tags.WriteString(opusVendor)
+	binary.Write(tags, binary.LittleEndian, uint32(0)) // no user comments
+	for _, header := range [][]byte{head, tags.Bytes()} {
+		if err := ow.add(header, 0); err != nil {
+			return err
+		}
+		if err := ow.flush(false); err != nil {
+			return err
+		}
+	}
+
+	for {
+		b, err := in.peekBlock()
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			break
+		}
+		in.pending = in.pending[1:]
+
+		packet, err := opusPacketOf(b)
+		if err != nil {
+			return err
+		}
+		samples, err := opusPacketSamples(packet)
+		if err != nil {
+			return err
+		}
+		samples -= discardedSamples(b)
+		if err := ow.add(packet, samples); err != nil {
+			return err
+		}
+	}
+	return ow.flush(true)
+}
+
+// opusPacketOf returns an Opus packet in a block
+func opusPacketOf(b *webmBlock) ([]byte, error) {
+	block, err := blockOf(b.element)
+	if err != nil {
+		return nil, err
+	}
+	_, _, headerSize, err := parseBlockHeader(block)
+	if err != nil {
+		return nil, err
+	}
+	if block[headerSize]&0x06 != 0 {
+		return nil, errors.New("laced blocks are not supported")
+	}
+	return block[headerSize+1:], nil
+}
+
+// discardedSamples returns 48 kHz samples to be discarded designated by DiscardPadding of a BlockGroup
+func discardedSamples(b *webmBlock) int {
+	if b.element.id != ebmlIDBlockGroup {
+		return 0
+	}
+	children, err := parseEBMLElements(b.element.data)
+	if err != nil {
+		return 0
+	}
+	for _, c := range children {
+		if c.id == ebmlIDDiscardPadding {
+			// in nanoseconds
+			return int(ebmlInt(c.data) * 48000 / 1000000000)
+		}
+	}
+	return 0
+}
+
+// opusPacketSamples returns the number of 48 kHz samples in an Opus packet from its TOC byte
+func opusPacketSamples(packet []byte) (int, error) {
+	if len(packet) == 0 {
+		return 0, errors.New("empty opus packet")
+	}
+	toc := packet[0]
+	config := toc >> 3
+	var frameSize int
+	switch {
+	case config < 12: // SILK
+		frameSize = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16: // hybrid
+		frameSize = []int{480, 960}[config%2]
+	default: // CELT
+		frameSize = []int{120, 240, 480, 960}[config%4]
+	}
+	frames := 1
+	switch toc & 0x03 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0, errors.New("truncated opus packet")
+		}
+		frames = int(packet[1] & 0x3F)
+	}
+	return frames * frameSize, nil
+}
+
+// oggWriter writes packets of a logical bitstream into Ogg pages
+type oggWriter struct {
+	w        io.Writer
+	serial   uint32
+	sequence uint32
+	granule  int64 // at the end of the last packet added
+
+	// the page being built
+	segments    []byte
+	body        []byte
+	pageSamples int
+}
+
+// add adds a packet of given samples, flushing the page being built if it is full
+func (ow *oggWriter) add(packet []byte, samples int) error {
+	lacing := len(packet)/255 + 1
+	if lacing > 255 {
+		return fmt.Errorf("packet of %d bytes is too large for a page", len(packet))
+	}
+	if len(ow.segments)+lacing > 255 || ow.pageSamples >= oggPageSamples {
+		if err := ow.flush(false); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < lacing-1; i++ {
+		ow.segments = append(ow.segments, 255)
+	}
+	ow.segments = append(ow.segments, byte(len(packet)%255))
+	ow.body = append(ow.body, packet...)
+	ow.granule += int64(samples)
+	ow.pageSamples += samples
+	return nil
+}
+
+// flush writes the page being built, which is the last one if eos is true
+func (ow *oggWriter) flush(eos bool) error {
+	if len(ow.segments) == 0 && !eos {
+		return nil
+	}
+	var headerType byte
+	if ow.sequence == 0 {
+		headerType |= oggHeaderBOS
+	}
+	if eos {
+		headerType |= oggHeaderEOS
+	}
+	page := make([]byte, 27, 27+len(ow.segments)+len(ow.body))
+	copy(page, "OggS")
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:14], uint64(ow.granule))
+	binary.LittleEndian.PutUint32(page[14:18], ow.serial)
+	binary.LittleEndian.PutUint32(page[18:22], ow.sequence)
+	page[26] = byte(len(ow.segments))
+	page = append(append(page, ow.segments...), ow.body...)
+	binary.LittleEndian.PutUint32(page[22:26], oggCRC(page))
+
+	if _, err := ow.w.Write(page); err != nil {
+		return err
+	}
+	ow.sequence++
+	ow.segments, ow.body, ow.pageSamples = nil, nil, 0
+	return nil
+}
@@ -0,0 +1,120 @@
+package gotube
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// oggTestPage is a page read from an Ogg stream
+type oggTestPage struct {
+	headerType byte
+	granule    int64
+	packets    [][]byte
+}
+
+// oggPagesOf reads pages of a logical bitstream whose packets are not split across pages
+func oggPagesOf(t *testing.T, data []byte) []oggTestPage {
+	var pages []oggTestPage
+	for sequence := uint32(0); len(data) > 0; sequence++ {
+		if len(data) < 27 || string(data[:4]) != "OggS" {
+			t.Fatalf("no page found at page %d", sequence)
+		}
+		segments := data[27 : 27+int(data[26])]
+		size := 27 + len(segments)
+		for _, s := range segments {
+			size += int(s)
+		}
+		page := append([]byte{}, data[:size]...)
+		crc := binary.LittleEndian.Uint32(page[22:26])
+		binary.LittleEndian.PutUint32(page[22:26], 0)
+		if oggCRC(page) != crc {
+			t.Errorf("invalid CRC of page %d", sequence)
+		}
+		if s := binary.LittleEndian.Uint32(page[18:22]); s != sequence {
+			t.Errorf("got sequence number %d, expected %d", s, sequence)
+		}
+
+		p := oggTestPage{headerType: page[5], granule: int64(binary.LittleEndian.Uint64(page[6:14]))}
+		body := page[27+len(segments):]
+		packet := []byte{}
+		for _, s := range segments {
+			packet = append(packet, body[:s]...)
+			body = body[s:]
+			if s < 255 {
+				p.packets = append(p.packets, packet)
+				packet = []byte{}
+			}
+		}
+		pages = append(pages, p)
+		data = data[size:]
+	}
+	return pages
+}
+
+func TestOggCRC(t *testing.T) {
+	if crc := oggCRC([]byte("123456789")); crc != 0x89A1897F {
+		t.Errorf("got %08X, expected 89A1897F", crc)
+	}
+}
+
+func TestOpusPacketSamples(t *testing.T) {
+	cases := []struct {
+		packet  []byte
+		samples int
+	}{
+		{[]byte{0xF8}, 960},       // CELT 20 ms, a frame
+		{[]byte{0x08}, 960},       // SILK 20 ms, a frame
+		{[]byte{0x79}, 1920},      // hybrid 20 ms, two frames
+		{[]byte{0xE3, 0x03}, 360}, // CELT 2.5 ms, three frames
+	}
+	for _, c := range cases {
+		if samples, err := opusPacketSamples(c.packet); err != nil || samples != c.samples {
+			t.Errorf("got %d, %v for %X, expected %d", samples, err, c.packet, c.samples)
+		}
+	}
+	if _, err := opusPacketSamples([]byte{0x03}); err == nil {
+		t.Error("truncated packet should be an error")
+	}
+}
+
+func TestWriteOggOpus(t *testing.T) {
+	// packets of 20 ms
+	audio := testWebM(1000000, 60, "A_OPUS", true, []testCluster{
+		{0, []int16{0, 20}, []string{"\xf8one", "\xf8two"}},
+		{40, []int16{0}, []string{"\xf8three"}},
+	})
+	buf := new(bytes.Buffer)
+	if err := writeOggOpus(buf, bytes.NewReader(audio)); err != nil {
+		t.Fatalf("rewrap failed, %s", err)
+	}
+	pages := oggPagesOf(t, buf.Bytes())
+	if len(pages) != 3 {
+		t.Fatalf("got %d pages, expected 3", len(pages))
+	}
+	if pages[0].headerType != oggHeaderBOS || !bytes.HasPrefix(pages[0].packets[0], []byte("OpusHead")) || pages[0].granule != 0 {
+		t.Errorf("got first page %v, expected OpusHead", pages[0])
+	}
+	if pages[1].headerType != 0 || !bytes.HasPrefix(pages[1].packets[0], []byte("OpusTags")) || pages[1].granule != 0 {
+		t.Errorf("got second page %v, expected OpusTags", pages[1])
+	}
+	expected := [][]byte{[]byte("\xf8one"), []byte("\xf8two"), []byte("\xf8three")}
+	if pages[2].headerType != oggHeaderEOS || !reflect.DeepEqual(pages[2].packets, expected) || pages[2].granule != 2880 {
+		t.Errorf("got last page %v, expected packets %q at 2880", pages[2], expected)
+	}
+
+	vorbis := testWebM(1000000, 60, "A_VORBIS", true, []testCluster{{0, []int16{0}, []string{"x"}}})
+	if err := writeOggOpus(new(bytes.Buffer), bytes.NewReader(vorbis)); err == nil {
+		t.Error("vorbis should be rejected")
+	}
+}
+
+func TestDiscardedSamples(t *testing.T) {
+	// 10 ms in a signed integer
+	padding := makeEBMLElement(ebmlIDDiscardPadding, []byte{0x00, 0x98, 0x96, 0x80})
+	group := &ebmlElement{id: ebmlIDBlockGroup, data: append(makeEBMLElement(ebmlIDBlock, []byte{0x81, 0x00, 0x00, 0x80, 0xF8}), padding...)}
+	if samples := discardedSamples(&webmBlock{element: group}); samples != 480 {
+		t.Errorf("got %d, expected 480 samples of 10 ms", samples)
+	}
+}
@@ -0,0 +1,209 @@
+package gotube
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+var (
+	initialPlayerResponseRegex = regexp.MustCompile(`ytInitialPlayerResponse\s*=\s*\{`)
+	jsURLKeyRegex              = regexp.MustCompile(`"jsUrl":"(.+?)"`)
+)
+
+// playerResponse is the part of the player response, ytInitialPlayerResponse of watch pages, used to build streams
+type playerResponse struct {
+	playerCaptions
+	PlayabilityStatus struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	} `json:"playabilityStatus"`
+	StreamingData struct {
+		Formats         []playerFormat `json:"formats"`
+		AdaptiveFormats []playerFormat `json:"adaptiveFormats"`
+	} `json:"streamingData"`
+	VideoDetails struct {
+		VideoID          string   `json:"videoId"`
+		Title            string   `json:"title"`
+		LengthSeconds    string   `json:"lengthSeconds"`
+		Keywords         []string `json:"keywords"`
+		ChannelID        string   `json:"channelId"`
+		ShortDescription string   `json:"shortDescription"`
+		Thumbnail        struct {
+			Thumbnails []Thumbnail `json:"thumbnails"`
+		} `json:"thumbnail"`
+		AverageRating float64 `json:"averageRating"`
+		ViewCount     string  `json:"viewCount"`
+		Author        string  `json:"author"`
+		IsLiveContent bool    `json:"isLiveContent"`
+		IsLive        bool    `json:"isLive"`
+		IsUpcoming    bool    `json:"isUpcoming"`
+	} `json:"videoDetails"`
+	Microformat struct {
+		Renderer struct {
+			PublishDate          string `json:"publishDate"`
+			UploadDate           string `json:"uploadDate"`
+			Category             string `json:"category"`
+			LiveBroadcastDetails struct {
+				IsLiveNow bool `json:"isLiveNow"`
+			} `json:"liveBroadcastDetails"`
+		} `json:"playerMicroformatRenderer"`
+	} `json:"microformat"`
+}
+
+// playerFormat is a stream listed in streamingData of the player response
+type playerFormat struct {
+	Itag            int          `json:"itag"`
+	URL             string       `json:"url"`
+	SignatureCipher string       `json:"signatureCipher"`
+	Cipher          string       `json:"cipher"` // former name of signatureCipher
+	MimeType        string       `json:"mimeType"`
+	Bitrate         int          `json:"bitrate"`
+	Width           int          `json:"width"`
+	Height          int          `json:"height"`
+	Fps             int          `json:"fps"`
+	Quality         string       `json:"quality"`
+	ContentLength   string       `json:"contentLength"`
+	InitRange       *playerRange `json:"initRange"`
+	IndexRange      *playerRange `json:"indexRange"`
+}
+
+type playerRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// findInitialPlayerResponse returns the JSON assigned to ytInitialPlayerResponse in a page, or nil if not found
+func findInitialPlayerResponse(html []byte) []byte {
+	return findJSON(html, initialPlayerResponseRegex, "ytInitialPlayerResponse")
+}
+
+// findJSON returns the JSON object starting at the last character of a match of regex, or nil if not found
+func findJSON(html []byte, regex *regexp.Regexp, name string) []byte {
+	loc := regex.FindIndex(html)
+	if loc == nil {
+		return nil
+	}
+	// the JSON is followed by other statements, so only the first value is decoded
+	var raw json.RawMessage
+	if err := json.NewDecoder(bytes.NewReader(html[loc[1]-1:])).Decode(&raw); err != nil {
+		logger.printf("invalid %s, %s", name, err)
+		return nil
+	}
+	return raw
+}
+
+// parsePlayerResponse decodes a player response in JSON, it returns an empty one if invalid
+func parsePlayerResponse(data []byte) *playerResponse {
+	pr := &playerResponse{}
+	if data == nil {
+		return pr
+	}
+	if err := json.Unmarshal(data, pr); err != nil {
+		logger.printf("invalid player response, %s", err)
+		return &playerResponse{}
+	}
+	return pr
+}
+
+// unplayableError is returned for a video which cannot be played, such as a private, removed or upcoming one
+type unplayableError struct {
+	status string
+	reason string
+}
+
+func (e *unplayableError) Error() string {
+	return fmt.Sprintf("video is not playable, %s %s", e.status, e.reason)
+}
+
+// playable tells whether the video can be played, or returns the reason why not
+func (pr *playerResponse) playable() error {
+	switch pr.PlayabilityStatus.Status {
+	case "", "OK":
+		return nil
+	}
+	return &unplayableError{status: pr.PlayabilityStatus.Status, reason: pr.PlayabilityStatus.Reason}
+}
+
+// uploadDate returns the upload date in YYYY-MM-DD, or "" if not given
+func (pr *playerResponse) uploadDate() string {
+	return isoDate(pr.Microformat.Renderer.UploadDate)
+}
+
+// isoDate returns the date part YYYY-MM-DD of a date or a time in ISO 8601, or "" if too short
+func isoDate(t string) string {
+	if len(t) < len("2006-01-02") {
+		return ""
+	}
+	return t[:len("2006-01-02")]
+}
+
+// streamInfos returns infos of streams listed in streamingData in the form newStream takes
+func (pr *playerResponse) streamInfos() []map[string]string {
+	formats := append(append([]playerFormat{}, pr.StreamingData.AdaptiveFormats...), pr.StreamingData.Formats...)
+	infos := make([]map[string]string, 0, len(formats))
+	for _, f := range formats {
+		info, err := f.streamInfo()
+		if err != nil {
+			logger.printf("%s", err)
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// streamInfo converts a format into the form newStream takes.
+// The url and the signature are taken from signatureCipher if the url is not given as it is.
+func (f *playerFormat) streamInfo() (map[string]string, error) {
+	info := map[string]string{
+		"itag": strconv.Itoa(f.Itag),
+		"type": f.MimeType,
+	}
+	cipher := f.SignatureCipher
+	if cipher == "" {
+		cipher = f.Cipher
+	}
+	if cipher != "" {
+		values, err := url.ParseQuery(cipher)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signatureCipher of itag %d, %s", f.Itag, err)
+		}
+		info["url"], info["s"] = values.Get("url"), values.Get("s")
+		if sp := values.Get("sp"); sp != "" {
+			info["sp"] = sp
+		}
+	} else {
+		info["url"] = f.URL
+		info["signed"] = "true"
+	}
+	if info["url"] == "" {
+		return nil, fmt.Errorf("no url of itag %d", f.Itag)
+	}
+
+	if f.Quality != "" {
+		info["quality"] = f.Quality
+	}
+	if f.ContentLength != "" {
+		info["clen"] = f.ContentLength
+	}
+	if f.Bitrate > 0 {
+		info["bitrate"] = strconv.Itoa(f.Bitrate)
+	}
+	if f.Width > 0 && f.Height > 0 {
+		info["size"] = fmt.Sprintf("%dx%d", f.Width, f.Height)
+	}
+	if f.Fps > 0 {
+		info["fps"] = strconv.Itoa(f.Fps)
+	}
+	if f.InitRange != nil {
+		info["init"] = f.InitRange.Start + "-" + f.InitRange.End
+	}
+	if f.IndexRange != nil {
+		info["index"] = f.IndexRange.Start + "-" + f.IndexRange.End
+	}
+	return info, nil
+}
@@ -0,0 +1,233 @@
+package gotube
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPlayerResponse = `{
+	"playabilityStatus": {"status": "OK"},
+	"streamingData": {
+		"formats": [{
+			"itag": 18, "url": "https://rr1.googlevideo.com/videoplayback?itag=18&expire=1700000000&lsig=AG3C",
+			"mimeType": "video/mp4; codecs=\"avc1.42001E, mp4a.40.2\"", "bitrate": 503000, "width": 640, "height": 360,
+			"quality": "medium", "qualityLabel": "360p", "fps": 30, "contentLength": "17588813"
+		}],
+		"adaptiveFormats": [{
+			"itag": 137,
+			"signatureCipher": "s=ABCDEF&sp=sig&url=https%3A%2F%2Frr1.googlevideo.com%2Fvideoplayback%3Fitag%3D137%26expire%3D1700000000",
+			"mimeType": "video/mp4; codecs=\"avc1.640028\"", "bitrate": 4400000, "width": 1920, "height": 1080,
+			"initRange": {"start": "0", "end": "708"}, "indexRange": {"start": "709", "end": "1412"},
+			"quality": "hd1080", "qualityLabel": "1080p", "fps": 30, "contentLength": "87342345"
+		}, {
+			"itag": 251, "url": "https://rr1.googlevideo.com/videoplayback?itag=251&expire=1700000000&lsig=AG3C",
+			"mimeType": "audio/webm; codecs=\"opus\"", "bitrate": 140000,
+			"initRange": {"start": "0", "end": "265"}, "indexRange": {"start": "266", "end": "745"},
+			"quality": "tiny", "contentLength": "4262930"
+		}, {
+			"itag": 400, "mimeType": "video/mp4; codecs=\"av01.0.08M.08\""
+		}]
+	},
+	"videoDetails": {
+		"videoId": "iEPTlhBmwRg", "title": "Maroon 5 - \"Moves Like Jagger\" \u0026 more", "lengthSeconds": "281",
+		"keywords": ["Maroon", "Moves Like Jagger, live"], "channelId": "UCN1hnUccO4FD5WfM7ithXaw",
+		"shortDescription": "UK release: Sept 5th\nOfficial video",
+		"thumbnail": {"thumbnails": [
+			{"url": "https://i.ytimg.com/vi/iEPTlhBmwRg/default.jpg", "width": 120, "height": 90},
+			{"url": "https://i.ytimg.com/vi/iEPTlhBmwRg/maxresdefault.jpg", "width": 1920, "height": 1080}
+		]},
+		"averageRating": 4.8, "viewCount": "3923415688", "author": "Maroon5VEVO", "isLiveContent": false
+	},
+	"microformat": {"playerMicroformatRenderer": {
+		"category": "Music", "publishDate": "2011-08-08T00:00:00-07:00", "uploadDate": "2011-08-09T00:00:00-07:00"
+	}},
+	"captions": {"playerCaptionsTracklistRenderer": {"captionTracks": [
+		{"baseUrl": "https://www.youtube.com/api/timedtext?v=iEPTlhBmwRg&lang=en", "name": {"simpleText": "English"}, "languageCode": "en"}
+	]}}
+}`
+
+// testPage returns a watch page of the current layout embedding a player response
+func testPage(playerResponse string) string {
+	return `<html><head><script>var ytplayer = {};ytcfg.set({"jsUrl":"/s/player/4fbb4d5b/player_ias.vflset/en_US/base.js"});</script></head>` +
+		`<body><script nonce="x">var ytInitialPlayerResponse = ` + playerResponse + `;var meta = document.createElement('meta');</script></body></html>`
+}
+
+// pageClient serves a page at the watch url and 404 for the others
+func pageClient(page string, requested *[]string) *fakeClient {
+	return &fakeClient{fakeGet: func(ctx context.Context, u string) (*http.Response, error) {
+		*requested = append(*requested, u)
+		if u != validURL {
+			return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(page))}, nil
+	}}
+}
+
+type reverseDecipherer struct{}
+
+func (reverseDecipherer) Decipher(s string) (string, error) {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r), nil
+}
+
+func TestFetchStreamsFromPlayerResponse(t *testing.T) {
+	var requested []string
+	dl := &YoutubeDownloader{url: validURL, client: pageClient(testPage(testPlayerResponse), &requested), Retry: &NoRetry}
+	if err := dl.FetchStreams(); err != nil {
+		t.Fatalf("failed to fetch streams, %s", err)
+	}
+
+	// the player script is requested at jsUrl, and neither the embed page nor the video info is requested
+	expectedRequests := []string{validURL, "https://youtube.com/s/player/4fbb4d5b/player_ias.vflset/en_US/base.js"}
+	if strings.Join(requested, " ") != strings.Join(expectedRequests, " ") {
+		t.Errorf("requested %v, expected %v", requested, expectedRequests)
+	}
+
+	m := dl.metadata
+	if m.Title != `Maroon 5 - "Moves Like Jagger" & more` || m.Author != "Maroon5VEVO" || m.UploadDate != "2011-08-09" ||
+		m.Description != "UK release: Sept 5th\nOfficial video" {
+		t.Errorf("wrong metadata %+v", *m)
+	}
+	info, _ := dl.Info()
+	expectedInfo := VideoInfo{
+		ID:            "iEPTlhBmwRg",
+		Title:         `Maroon 5 - "Moves Like Jagger" & more`,
+		Author:        "Maroon5VEVO",
+		ChannelID:     "UCN1hnUccO4FD5WfM7ithXaw",
+		Description:   "UK release: Sept 5th\nOfficial video",
+		Keywords:      []string{"Maroon", "Moves Like Jagger, live"},
+		ViewCount:     3923415688,
+		PublishDate:   "2011-08-08",
+		UploadDate:    "2011-08-09",
+		Category:      "Music",
+		Duration:      281 * time.Second,
+		AverageRating: 4.8,
+		Thumbnails: []Thumbnail{
+			{"https://i.ytimg.com/vi/iEPTlhBmwRg/default.jpg", 120, 90},
+			{"https://i.ytimg.com/vi/iEPTlhBmwRg/maxresdefault.jpg", 1920, 1080},
+		},
+	}
+	if info == nil || !reflect.DeepEqual(*info, expectedInfo) {
+		t.Errorf("got info %+v, expected %+v", info, expectedInfo)
+	}
+	if captions, _ := dl.Captions(); len(captions) != 1 || captions[0].LanguageCode != "en" {
+		t.Errorf("got caption tracks %v", captions)
+	}
+
+	// the format without a url is skipped
+	if len(dl.Streams) != 3 {
+		t.Fatalf("got %d streams, expected 3", len(dl.Streams))
+	}
+	streams := make(map[int]*Stream)
+	for _, s := range dl.Streams {
+		streams[s.itag] = s
+		if s.Duration.Seconds() != 281 {
+			t.Errorf("got duration %s of itag %d, expected 281s", s.Duration, s.itag)
+		}
+	}
+
+	video := streams[137]
+	if video.MediaType != "video" || video.Format != "mp4" || video.VideoCodec != "avc1.640028" || video.Quality != "hd1080" ||
+		video.ContentLength != 87342345 || video.width != 1920 || video.height != 1080 || video.bitrate != 4400000 || video.frameRate != 30 {
+		t.Errorf("wrong stream %+v", video)
+	}
+	if video.initRange != (byteRange{0, 708}) || video.indexRange != (byteRange{709, 1412}) {
+		t.Errorf("got init %v and index %v", video.initRange, video.indexRange)
+	}
+	// the signature of signatureCipher is added as sp designates
+	video.decipherer = reverseDecipherer{}
+	if u, err := video.getDownloadURL(); err != nil || u != "https://rr1.googlevideo.com/videoplayback?itag=137&expire=1700000000&sig=FEDCBA" {
+		t.Errorf("got download url %s, %v", u, err)
+	}
+
+	// urls given as they are need no signature
+	for _, itag := range []int{18, 251} {
+		if u, err := streams[itag].getDownloadURL(); err != nil || u != streams[itag].url {
+			t.Errorf("got download url %s, %v for itag %d", u, err, itag)
+		}
+	}
+	if audio := streams[251]; audio.MediaType != "audio" || audio.Format != "webm" || audio.AudioCodec != "opus" || !audio.hasIndex() {
+		t.Errorf("wrong stream %+v", audio)
+	}
+	if progressive := streams[18]; progressive.VideoCodec != "avc1.42001E" || progressive.AudioCodec != "mp4a.40.2" || progressive.hasIndex() {
+		t.Errorf("wrong stream %+v", progressive)
+	}
+}
+
+func TestFetchStreamsOfUnplayableVideo(t *testing.T) {
+	var requested []string
+	page := testPage(`{"playabilityStatus": {"status": "LOGIN_REQUIRED", "reason": "Sign in to confirm your age"}, "videoDetails": {"videoId": "iEPTlhBmwRg"}}`)
+	dl := &YoutubeDownloader{url: validURL, client: pageClient(page, &requested), Retry: &NoRetry}
+	err := dl.FetchStreams()
+	if err == nil || !strings.Contains(err.Error(), "Sign in to confirm your age") {
+		t.Errorf("got %v, expected the reason of the playability status", err)
+	}
+	if len(requested) != 1 {
+		t.Errorf("requested %v, expected only the page", requested)
+	}
+}
+
+func TestFindInitialPlayerResponse(t *testing.T) {
+	// braces in strings do not end the JSON, and what follows it is ignored
+	raw := findInitialPlayerResponse([]byte(testPage(`{"videoDetails": {"title": "};{"}}`)))
+	if string(raw) != `{"videoDetails": {"title": "};{"}}` {
+		t.Errorf("got %s", raw)
+	}
+	if raw := findInitialPlayerResponse([]byte(`<script>var ytplayer = {};</script>`)); raw != nil {
+		t.Errorf("got %s from a page without the player response", raw)
+	}
+}
+
+func TestInfoOfLiveVideo(t *testing.T) {
+	var requested []string
+	page := testPage(`{"streamingData": {"adaptiveFormats": [{"itag": 140, "url": "https://rr1.googlevideo.com/videoplayback?itag=140", "mimeType": "audio/mp4; codecs=\"mp4a.40.2\""}]},` +
+		`"videoDetails": {"videoId": "iEPTlhBmwRg", "title": "live", "isLiveContent": true},` +
+		`"microformat": {"playerMicroformatRenderer": {"liveBroadcastDetails": {"isLiveNow": true}}}}`)
+	dl := &YoutubeDownloader{url: validURL, client: pageClient(page, &requested), Retry: &NoRetry}
+	if _, err := dl.Info(); err == nil {
+		t.Error("info is returned before streams are fetched")
+	}
+	if err := dl.FetchStreams(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := dl.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsLive || !info.IsLiveContent || info.IsUpcoming {
+		t.Errorf("got live %t, live content %t and upcoming %t", info.IsLive, info.IsLiveContent, info.IsUpcoming)
+	}
+	// the returned info is a copy
+	info.Title = "changed"
+	if again, _ := dl.Info(); again.Title != "live" {
+		t.Errorf("got title %s, expected live", again.Title)
+	}
+}
+
+func TestInfoOfUpcomingVideo(t *testing.T) {
+	var requested []string
+	page := testPage(`{"playabilityStatus": {"status": "LIVE_STREAM_OFFLINE", "reason": "Premieres in 2 hours"},` +
+		`"videoDetails": {"videoId": "iEPTlhBmwRg", "title": "premiere", "author": "Maroon 5", "isLiveContent": true, "isUpcoming": true}}`)
+	dl := &YoutubeDownloader{url: validURL, client: pageClient(page, &requested), Retry: &NoRetry}
+	if err := dl.FetchStreams(); err == nil || !strings.Contains(err.Error(), "Premieres in 2 hours") {
+		t.Errorf("got %v, expected the reason of the playability status", err)
+	}
+	info, err := dl.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsUpcoming || info.IsLive || info.Title != "premiere" || info.Author != "Maroon 5" {
+		t.Errorf("got %+v, expected an upcoming premiere", *info)
+	}
+	if len(dl.Streams) != 0 {
+		t.Errorf("got %d streams of an upcoming video", len(dl.Streams))
+	}
+}
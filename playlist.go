@@ -0,0 +1,314 @@
+package gotube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	playlistIDPattern  = regexp.MustCompile(`^[\w-]{12,}$`)
+	playlistItemsRegex = regexp.MustCompile(`^(\d+)(?:(-)(\d*))?$`)
+)
+
+// PlaylistDownloader lists videos of a playlist and gives a YoutubeDownloader of each of them.
+// Retry is applied to requests for the playlist, DefaultRetryPolicy if nil, and Retry, Pool and RateLimiter are set to downloaders of videos.
+type PlaylistDownloader struct {
+	videoLister                  // kept first for 64-bit alignment of retries
+	Entries     []*PlaylistEntry // accessable after FetchEntries
+	Title       string
+	Author      string // name of the channel owning the playlist
+	id          string
+}
+
+// PlaylistEntry is a video listed in a playlist
+type PlaylistEntry struct {
+	ID        string
+	Title     string
+	Index     int           // position in the playlist starting from 1
+	Duration  time.Duration // 0 if unknown
+	Available bool          // false for private or deleted videos, which cannot be downloaded
+}
+
+// playlistData is the part of ytInitialData of a playlist page, or of a response to a continuation, used to list videos
+type playlistData struct {
+	Contents struct {
+		TwoColumn struct {
+			Tabs []struct {
+				TabRenderer struct {
+					Content struct {
+						SectionList struct {
+							Contents []struct {
+								ItemSection struct {
+									Contents []struct {
+										VideoList *struct {
+											Contents []playlistItem `json:"contents"`
+										} `json:"playlistVideoListRenderer"`
+									} `json:"contents"`
+								} `json:"itemSectionRenderer"`
+							} `json:"contents"`
+						} `json:"sectionListRenderer"`
+					} `json:"content"`
+				} `json:"tabRenderer"`
+			} `json:"tabs"`
+		} `json:"twoColumnBrowseResultsRenderer"`
+	} `json:"contents"`
+	Metadata struct {
+		Renderer struct {
+			Title string `json:"title"`
+		} `json:"playlistMetadataRenderer"`
+	} `json:"metadata"`
+	Header struct {
+		Renderer struct {
+			OwnerText playerString `json:"ownerText"`
+		} `json:"playlistHeaderRenderer"`
+	} `json:"header"`
+	Alerts []struct {
+		Renderer struct {
+			Text playerString `json:"text"`
+		} `json:"alertRenderer"`
+	} `json:"alerts"`
+	OnResponseReceivedActions []struct {
+		AppendContinuationItemsAction struct {
+			ContinuationItems []playlistItem `json:"continuationItems"`
+		} `json:"appendContinuationItemsAction"`
+	} `json:"onResponseReceivedActions"`
+}
+
+// playlistItem is either a video or a continuation to the following videos
+type playlistItem struct {
+	Video *struct {
+		VideoID       string       `json:"videoId"`
+		Title         playerString `json:"title"`
+		Index         playerString `json:"index"`
+		LengthSeconds string       `json:"lengthSeconds"`
+		IsPlayable    bool         `json:"isPlayable"`
+	} `json:"playlistVideoRenderer"`
+	Continuation *continuationRenderer `json:"continuationItemRenderer"`
+}
+
+// NewPlaylistDownloader returns a PlaylistDownloader of a playlist url or a bare playlist id.
+// Any url with the list parameter is accepted, e.g. /playlist?list=ID and /watch?v=VIDEO&list=ID.
+func NewPlaylistDownloader(playlistURL string) (*PlaylistDownloader, error) {
+	id, err := ParsePlaylistID(playlistURL)
+	if err != nil {
+		return nil, err
+	}
+	return &PlaylistDownloader{videoLister: videoLister{client: &youtubeClient{}}, id: id}, nil
+}
+
+// ParsePlaylistID extracts a playlist id from the list parameter of a YouTube url, or accepts a bare playlist id
+func ParsePlaylistID(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if playlistIDPattern.MatchString(s) {
+		return s, nil
+	}
+	raw := s
+	if !schemePattern.MatchString(raw) {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("unexpected URL format %s", s)
+	}
+	if !youtubeHosts[strings.ToLower(u.Hostname())] {
+		return "", fmt.Errorf("%s is not a YouTube playlist URL", s)
+	}
+	id := u.Query().Get("list")
+	if !playlistIDPattern.MatchString(id) {
+		return "", fmt.Errorf("no playlist id found in %s", s)
+	}
+	return id, nil
+}
+
+// PlaylistURL returns the url of the page of a playlist
+func PlaylistURL(playlistID string) string {
+	return fmt.Sprintf("https://www.youtube.com/playlist?list=%s", playlistID)
+}
+
+// ID returns the id of the playlist
+func (pl *PlaylistDownloader) ID() string {
+	return pl.id
+}
+
+// FetchEntries lists every video of the playlist into Entries.
+// Videos beyond the first page are requested by following continuations.
+func (pl *PlaylistDownloader) FetchEntries() error {
+	return pl.FetchEntriesContext(context.Background())
+}
+
+// FetchEntriesContext is FetchEntries with a context.
+// Requests for the page and the continuations are canceled when ctx is done.
+func (pl *PlaylistDownloader) FetchEntriesContext(ctx context.Context) error {
+	html, err := pl.getResource(ctx, PlaylistURL(pl.id))
+	if err != nil {
+		return err
+	}
+	raw := findJSON(html, initialDataRegex, "ytInitialData")
+	if raw == nil {
+		return fmt.Errorf("no ytInitialData found in the page of playlist %s", pl.id)
+	}
+	data := &playlistData{}
+	if err := json.Unmarshal(raw, data); err != nil {
+		return fmt.Errorf("invalid ytInitialData of playlist %s, %s", pl.id, err)
+	}
+	items, ok := data.videoList()
+	if !ok {
+		for _, alert := range data.Alerts {
+			if text := alert.Renderer.Text.String(); text != "" {
+				return fmt.Errorf("playlist %s is not available, %s", pl.id, text)
+			}
+		}
+		return fmt.Errorf("no videos found in the page of playlist %s", pl.id)
+	}
+	pl.Title = data.Metadata.Renderer.Title
+	pl.Author = data.Header.Renderer.OwnerText.String()
+
+	cfg := extractInnertubeConfig(html)
+	var entries []*PlaylistEntry
+	requested := make(map[string]bool)
+	for {
+		var token string
+		entries, token = appendPlaylistEntries(entries, items)
+		// a token seen before would list the same videos again
+		if token == "" || requested[token] {
+			break
+		}
+		requested[token] = true
+		if items, err = pl.continuation(ctx, cfg, token); err != nil {
+			return err
+		}
+	}
+	pl.Entries = entries
+	return nil
+}
+
+// videoList returns items of the video list of a playlist page, ok is false if the page has none
+func (d *playlistData) videoList() (items []playlistItem, ok bool) {
+	for _, tab := range d.Contents.TwoColumn.Tabs {
+		for _, section := range tab.TabRenderer.Content.SectionList.Contents {
+			for _, content := range section.ItemSection.Contents {
+				if content.VideoList != nil {
+					items, ok = append(items, content.VideoList.Contents...), true
+				}
+			}
+		}
+	}
+	return items, ok
+}
+
+// appendPlaylistEntries appends videos of items to entries and returns the token of a continuation, "" if none
+func appendPlaylistEntries(entries []*PlaylistEntry, items []playlistItem) ([]*PlaylistEntry, string) {
+	var token string
+	for _, item := range items {
+		if item.Continuation != nil {
+			token = item.Continuation.token()
+		}
+		v := item.Video
+		if v == nil {
+			continue
+		}
+		entry := &PlaylistEntry{ID: v.VideoID, Title: v.Title.String(), Available: v.IsPlayable}
+		// index is not given to some entries, which are numbered by their order
+		if index, err := strconv.Atoi(v.Index.String()); err == nil {
+			entry.Index = index
+		} else {
+			entry.Index = len(entries) + 1
+		}
+		if seconds, err := strconv.Atoi(v.LengthSeconds); err == nil {
+			entry.Duration = time.Duration(seconds) * time.Second
+		}
+		entries = append(entries, entry)
+	}
+	return entries, token
+}
+
+// continuation requests the items following those of a token
+func (pl *PlaylistDownloader) continuation(ctx context.Context, cfg *innertubeConfig, token string) ([]playlistItem, error) {
+	res, err := cfg.browse(ctx, pl.client, pl.retryPolicy(), &pl.retries, token)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &playlistData{}
+	if err := json.Unmarshal(res, data); err != nil {
+		return nil, fmt.Errorf("invalid continuation of playlist %s, %s", pl.id, err)
+	}
+	var items []playlistItem
+	for _, action := range data.OnResponseReceivedActions {
+		items = append(items, action.AppendContinuationItemsAction.ContinuationItems...)
+	}
+	return items, nil
+}
+
+// Downloader returns a YoutubeDownloader of a video of the playlist, which shares Retry, Pool and RateLimiter.
+// FetchStreams of it must be called to download the video.
+func (pl *PlaylistDownloader) Downloader(entry *PlaylistEntry) (*YoutubeDownloader, error) {
+	if !entry.Available {
+		return nil, fmt.Errorf("video %d of playlist %s is not available", entry.Index, pl.id)
+	}
+	return pl.downloader(entry.ID)
+}
+
+// SelectEntries returns entries at positions given as comma separated indices and ranges, e.g. 1-10,15,20-.
+// A range without the end lasts until the last entry, and every entry is returned for "".
+func (pl *PlaylistDownloader) SelectEntries(items string) ([]*PlaylistEntry, error) {
+	if items == "" {
+		return pl.Entries, nil
+	}
+	selected, err := parsePlaylistItems(items)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*PlaylistEntry
+	for _, e := range pl.Entries {
+		if selected(e.Index) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// parsePlaylistItems returns a function telling whether an index is given by items such as 1-10,15,20-
+func parsePlaylistItems(items string) (func(index int) bool, error) {
+	type itemRange struct {
+		start, end int // end is 0 if open
+	}
+	var ranges []itemRange
+	for _, item := range strings.Split(items, ",") {
+		m := playlistItemsRegex.FindStringSubmatch(strings.TrimSpace(item))
+		if m == nil {
+			return nil, fmt.Errorf("invalid playlist items %s", items)
+		}
+		start, _ := strconv.Atoi(m[1])
+		end := start
+		if m[2] != "" {
+			end, _ = strconv.Atoi(m[3])
+		}
+		if start < 1 || (end != 0 && end < start) {
+			return nil, fmt.Errorf("invalid playlist items %s", items)
+		}
+		ranges = append(ranges, itemRange{start, end})
+	}
+	return func(index int) bool {
+		for _, r := range ranges {
+			if index >= r.start && (r.end == 0 || index <= r.end) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// getResource gets the page of the playlist, failed requests are retried according to the retry policy
+func (pl *PlaylistDownloader) getResource(ctx context.Context, url string) ([]byte, error) {
+	return readResource(ctx, pl.retryPolicy(), &pl.retries, url, func() (*http.Response, error) {
+		return pl.client.Get(ctx, url)
+	})
+}
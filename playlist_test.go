@@ -0,0 +1,191 @@
+package gotube
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testPlaylistID  = "PLx0sYbCqOb8TBPRdmBHs5Iftvv9TPboYG"
+	testPlaylistURL = "https://www.youtube.com/playlist?list=" + testPlaylistID
+)
+
+// testPlaylistPage returns a playlist page listing items, each of which is either a video or a continuation
+func testPlaylistPage(items string) string {
+	return `<html><script>ytcfg.set({"INNERTUBE_API_KEY":"AIzaTestKey","INNERTUBE_CLIENT_VERSION":"2.20230101.00.00"});</script>` +
+		`<script nonce="x">var ytInitialData = {"contents": {"twoColumnBrowseResultsRenderer": {"tabs": [{"tabRenderer": {"content": {"sectionListRenderer": {"contents": [` +
+		`{"itemSectionRenderer": {"contents": [{"playlistVideoListRenderer": {"contents": [` + items + `]}}]}}]}}}}]}},` +
+		`"metadata": {"playlistMetadataRenderer": {"title": "Hits & more"}},` +
+		`"header": {"playlistHeaderRenderer": {"ownerText": {"runs": [{"text": "Maroon5VEVO"}]}}}};</script></html>`
+}
+
+func testPlaylistVideo(id, title string, index int, seconds string, playable bool) string {
+	v := map[string]interface{}{"videoId": id, "title": map[string]interface{}{"runs": []map[string]string{{"text": title}}}, "isPlayable": playable}
+	if index > 0 {
+		v["index"] = map[string]string{"simpleText": strconv.Itoa(index)}
+	}
+	if seconds != "" {
+		v["lengthSeconds"] = seconds
+	}
+	b, _ := json.Marshal(map[string]interface{}{"playlistVideoRenderer": v})
+	return string(b)
+}
+
+func testPlaylistContinuation(token string) string {
+	return `{"continuationItemRenderer": {"continuationEndpoint": {"continuationCommand": {"token": "` + token + `"}}}}`
+}
+
+func TestParsePlaylistID(t *testing.T) {
+	for _, u := range []string{
+		testPlaylistURL,
+		testPlaylistID,
+		"https://www.youtube.com/watch?v=iEPTlhBmwRg&list=" + testPlaylistID + "&index=2",
+		"https://m.youtube.com/playlist?list=" + testPlaylistID,
+		"https://music.youtube.com/playlist?list=" + testPlaylistID,
+		"youtube.com/playlist?list=" + testPlaylistID,
+	} {
+		if id, err := ParsePlaylistID(u); err != nil || id != testPlaylistID {
+			t.Errorf("got %s, %v for %s", id, err, u)
+		}
+	}
+	for _, u := range []string{
+		"",
+		"iEPTlhBmwRg",
+		validURL,
+		"https://www.mytube.com/playlist?list=" + testPlaylistID,
+		"https://www.youtube.com/playlist?list=",
+		"ftp://www.youtube.com/playlist?list=" + testPlaylistID,
+	} {
+		if id, err := ParsePlaylistID(u); err == nil {
+			t.Errorf("got %s for %s, expected an error", id, u)
+		}
+	}
+}
+
+func TestFetchPlaylistEntries(t *testing.T) {
+	var posted []string
+	c := &fakeClient{
+		fakeGet: func(ctx context.Context, u string) (*http.Response, error) {
+			if u != testPlaylistURL {
+				return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+			}
+			page := testPlaylistPage(testPlaylistVideo("iEPTlhBmwRg", "Moves Like Jagger", 1, "281", true) + "," +
+				testPlaylistVideo("aaaaaaaaaaa", "[Private video]", 2, "", false) + "," + testPlaylistContinuation("token1"))
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(page))}, nil
+		},
+		fakePost: func(ctx context.Context, u, contentType string, body []byte) (*http.Response, error) {
+			var req struct {
+				Context struct {
+					Client struct {
+						ClientVersion string `json:"clientVersion"`
+					} `json:"client"`
+				} `json:"context"`
+				Continuation string `json:"continuation"`
+			}
+			if err := json.Unmarshal(body, &req); err != nil || contentType != "application/json" {
+				t.Errorf("invalid continuation request %s of %s, %v", body, contentType, err)
+			}
+			posted = append(posted, u+" "+req.Continuation+" "+req.Context.Client.ClientVersion)
+			// the second continuation repeats the first token, which must not be followed
+			items := testPlaylistVideo("bbbbbbbbbbb", "Payphone", 3, "232", true) + "," + testPlaylistContinuation("token2")
+			if req.Continuation == "token2" {
+				items = testPlaylistVideo("ccccccccccc", "Sugar", 0, "301", true) + "," + testPlaylistContinuation("token1")
+			}
+			res := `{"onResponseReceivedActions": [{"appendContinuationItemsAction": {"continuationItems": [` + items + `]}}]}`
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(res))}, nil
+		},
+	}
+	pl := &PlaylistDownloader{videoLister: videoLister{client: c, Retry: &NoRetry}, id: testPlaylistID}
+	if err := pl.FetchEntries(); err != nil {
+		t.Fatalf("failed to fetch entries, %s", err)
+	}
+
+	expectedPosts := []string{
+		"https://www.youtube.com/youtubei/v1/browse?key=AIzaTestKey token1 2.20230101.00.00",
+		"https://www.youtube.com/youtubei/v1/browse?key=AIzaTestKey token2 2.20230101.00.00",
+	}
+	if strings.Join(posted, "\n") != strings.Join(expectedPosts, "\n") {
+		t.Errorf("posted %v, expected %v", posted, expectedPosts)
+	}
+	if pl.Title != "Hits & more" || pl.Author != "Maroon5VEVO" {
+		t.Errorf("got title %s and author %s", pl.Title, pl.Author)
+	}
+
+	expected := []PlaylistEntry{
+		{"iEPTlhBmwRg", "Moves Like Jagger", 1, 281 * time.Second, true},
+		{"aaaaaaaaaaa", "[Private video]", 2, 0, false},
+		{"bbbbbbbbbbb", "Payphone", 3, 232 * time.Second, true},
+		{"ccccccccccc", "Sugar", 4, 301 * time.Second, true}, // numbered by the order without index
+	}
+	if len(pl.Entries) != len(expected) {
+		t.Fatalf("got %d entries, expected %d", len(pl.Entries), len(expected))
+	}
+	for i, e := range pl.Entries {
+		if *e != expected[i] {
+			t.Errorf("got entry %+v, expected %+v", *e, expected[i])
+		}
+	}
+
+	dl, err := pl.Downloader(pl.Entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.url != validURL || dl.client != c || dl.Retry != &NoRetry {
+		t.Errorf("got downloader of %s", dl.url)
+	}
+	if _, err := pl.Downloader(pl.Entries[1]); err == nil {
+		t.Error("a downloader of an unavailable video is returned")
+	}
+}
+
+func TestFetchUnavailablePlaylist(t *testing.T) {
+	page := `<script>var ytInitialData = {"alerts": [{"alertRenderer": {"type": "ERROR", "text": {"runs": [{"text": "The playlist does not exist."}]}}}]};</script>`
+	c := &fakeClient{fakeGet: func(ctx context.Context, u string) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(page))}, nil
+	}}
+	pl := &PlaylistDownloader{videoLister: videoLister{client: c, Retry: &NoRetry}, id: testPlaylistID}
+	if err := pl.FetchEntries(); err == nil || !strings.Contains(err.Error(), "The playlist does not exist.") {
+		t.Errorf("got %v, expected the alert of the page", err)
+	}
+}
+
+func TestSelectEntries(t *testing.T) {
+	pl := &PlaylistDownloader{}
+	for i := 1; i <= 20; i++ {
+		pl.Entries = append(pl.Entries, &PlaylistEntry{Index: i})
+	}
+	cases := map[string][]int{
+		"":        {1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20},
+		"1-3,15":  {1, 2, 3, 15},
+		"15, 2-3": {2, 3, 15},
+		"18-":     {18, 19, 20},
+		"5-5,30":  {5},
+		"3,3,1-2": {1, 2, 3},
+	}
+	for items, indices := range cases {
+		entries, err := pl.SelectEntries(items)
+		if err != nil {
+			t.Errorf("failed to select %s, %s", items, err)
+			continue
+		}
+		var got []int
+		for _, e := range entries {
+			got = append(got, e.Index)
+		}
+		if !reflect.DeepEqual(got, indices) {
+			t.Errorf("got %v for %s, expected %v", got, items, indices)
+		}
+	}
+	for _, items := range []string{"0", "a", "1-2-3", ",", "-3", "19-25,20-1"} {
+		if _, err := pl.SelectEntries(items); err == nil {
+			t.Errorf("invalid items %s are accepted", items)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package gotube
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+)
+
+// ErrPoolClosed is returned when a job is submitted to a closed WorkerPool.
+var ErrPoolClosed = errors.New("worker pool is closed")
+
+var (
+	sharedPool     *WorkerPool
+	sharedPoolOnce sync.Once
+)
+
+// PoolOptions configures a WorkerPool.
+type PoolOptions struct {
+	Workers      int // number of goroutines running requests, maxSimultaneousRequests if 0 or less
+	QueueDepth   int // number of requests waiting for a free worker, submission blocks when the queue is full
+	PerHostLimit int // maximum number of requests running for one host at once, 0 means no limit
+}
+
+// WorkerPool runs range requests on a fixed number of goroutines.
+// One pool can be shared by streams to limit connections of a whole process.
+// Jobs for a host at PerHostLimit are set aside without holding a worker until a job for the host finishes,
+// so that jobs for other hosts queued behind them are not blocked.
+type WorkerPool struct {
+	jobs         chan poolJob
+	perHostLimit int
+	hostsMu      sync.Mutex
+	hosts        map[string]*poolHost // hosts with running jobs
+	closeMu      sync.RWMutex
+	closed       bool
+	wg           sync.WaitGroup
+}
+
+type poolJob struct {
+	ctx  context.Context
+	host string
+	run  func()
+}
+
+// poolHost is jobs of a host running and waiting for one of them to finish
+type poolHost struct {
+	running int
+	waiting []poolJob
+}
+
+// NewWorkerPool starts workers and returns a pool.
+// Call Close to stop the workers when the pool is no longer used.
+func NewWorkerPool(opts PoolOptions) *WorkerPool {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = maxSimultaneousRequests
+	}
+	queueDepth := opts.QueueDepth
+	if queueDepth < 0 {
+		queueDepth = 0
+	}
+
+	p := &WorkerPool{
+		jobs:         make(chan poolJob, queueDepth),
+		perHostLimit: opts.PerHostLimit,
+		hosts:        make(map[string]*poolHost),
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// DefaultWorkerPool returns a pool shared by streams which have no pool set.
+// It runs maxSimultaneousRequests workers and is never closed.
+func DefaultWorkerPool() *WorkerPool {
+	sharedPoolOnce.Do(func() {
+		sharedPool = NewWorkerPool(PoolOptions{Workers: maxSimultaneousRequests})
+	})
+	return sharedPool
+}
+
+// Close stops accepting jobs, waits until queued jobs finish, and stops the workers.
+func (p *WorkerPool) Close() {
+	p.closeMu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.closeMu.Unlock()
+	p.wg.Wait()
+}
+
+// submit queues fn to run for a request to host.
+// It blocks while the queue is full and returns an error if ctx is done first or the pool is closed.
+func (p *WorkerPool) submit(ctx context.Context, host string, fn func()) error {
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	select {
+	case p.jobs <- poolJob{ctx: ctx, host: host, run: fn}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		p.runJob(job)
+	}
+}
+
+// runJob runs a job, or sets it aside if requests to its host reach the limit.
+// Jobs set aside are run in order after the job, and a job whose ctx is done runs at once to fail fast.
+func (p *WorkerPool) runJob(job poolJob) {
+	if p.perHostLimit <= 0 || job.ctx.Err() != nil {
+		job.run()
+		return
+	}
+	p.hostsMu.Lock()
+	h, ok := p.hosts[job.host]
+	if !ok {
+		h = &poolHost{}
+		p.hosts[job.host] = h
+	}
+	if h.running >= p.perHostLimit {
+		h.waiting = append(h.waiting, job)
+		p.hostsMu.Unlock()
+		return
+	}
+	h.running++
+	p.hostsMu.Unlock()
+
+	for {
+		job.run()
+
+		p.hostsMu.Lock()
+		// canceled jobs need no slot of the host
+		for len(h.waiting) > 0 && h.waiting[0].ctx.Err() != nil {
+			canceled := h.waiting[0]
+			h.waiting = h.waiting[1:]
+			p.hostsMu.Unlock()
+			canceled.run()
+			p.hostsMu.Lock()
+		}
+		if len(h.waiting) == 0 {
+			h.running--
+			if h.running == 0 {
+				delete(p.hosts, job.host)
+			}
+			p.hostsMu.Unlock()
+			return
+		}
+		// the slot is handed to the next job of the host
+		job, h.waiting = h.waiting[0], h.waiting[1:]
+		p.hostsMu.Unlock()
+	}
+}
+
+// workerPool returns a pool which runs range requests of the stream
+func (s *Stream) workerPool() *WorkerPool {
+	if s.Pool != nil {
+		return s.Pool
+	}
+	return DefaultWorkerPool()
+}
+
+// hostOf returns a host of a url, or an empty string if the url is invalid
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,141 @@
+package gotube
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolLimitsConcurrency(t *testing.T) {
+	p := NewWorkerPool(PoolOptions{Workers: 3, QueueDepth: 10})
+	defer p.Close()
+
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		err := p.submit(context.Background(), "example.com", func() {
+			defer wg.Done()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+		if err != nil {
+			t.Fatalf("submit failed, %s", err)
+		}
+	}
+	wg.Wait()
+
+	if maxRunning > 3 {
+		t.Errorf("%d jobs ran at once, expected at most 3", maxRunning)
+	}
+}
+
+func TestWorkerPoolPerHostLimit(t *testing.T) {
+	p := NewWorkerPool(PoolOptions{Workers: 4, QueueDepth: 10, PerHostLimit: 1})
+	defer p.Close()
+
+	var mu sync.Mutex
+	running := make(map[string]int)
+	exceeded := false
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		host := "a.example.com"
+		if i%2 == 0 {
+			host = "b.example.com"
+		}
+		wg.Add(1)
+		err := p.submit(context.Background(), host, func() {
+			defer wg.Done()
+			mu.Lock()
+			running[host]++
+			if running[host] > 1 {
+				exceeded = true
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			running[host]--
+			mu.Unlock()
+		})
+		if err != nil {
+			t.Fatalf("submit failed, %s", err)
+		}
+	}
+	wg.Wait()
+
+	if exceeded {
+		t.Error("more than one job ran for a host at once")
+	}
+}
+
+func TestWorkerPoolPerHostLimitDoesNotBlockOtherHosts(t *testing.T) {
+	p := NewWorkerPool(PoolOptions{Workers: 2, QueueDepth: 10, PerHostLimit: 1})
+	defer p.Close()
+
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		if err := p.submit(context.Background(), "a.example.com", func() {
+			defer wg.Done()
+			<-release
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a job for another host runs while the second job for a.example.com waits for the first one
+	done := make(chan struct{})
+	if err := p.submit(context.Background(), "b.example.com", func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("a job for another host is blocked behind a host at its limit")
+	}
+
+	// a canceled job does not wait for the host, it is queued directly since submit may refuse it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	canceled := make(chan struct{})
+	p.jobs <- poolJob{ctx: ctx, host: "a.example.com", run: func() { close(canceled) }}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("a canceled job waits for the host")
+	}
+
+	close(release)
+	wg.Wait()
+}
+
+func TestWorkerPoolSubmit(t *testing.T) {
+	p := NewWorkerPool(PoolOptions{Workers: 1})
+
+	// occupy the only worker, then submission blocks since the queue has no room
+	release := make(chan struct{})
+	if err := p.submit(context.Background(), "", func() { <-release }); err != nil {
+		t.Fatalf("submit failed, %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.submit(ctx, "", func() {}); err != context.DeadlineExceeded {
+		t.Errorf("got error %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	close(release)
+	p.Close()
+	if err := p.submit(context.Background(), "", func() {}); err != ErrPoolClosed {
+		t.Errorf("got error %v, expected %v", err, ErrPoolClosed)
+	}
+}
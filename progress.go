@@ -0,0 +1,159 @@
+package gotube
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	progressInterval = 200 * time.Millisecond // minimum interval between reports of received bytes
+	speedSmoothing   = 0.3                    // weight of the latest sample in the moving average of speed
+)
+
+// Progress is a snapshot of a download reported to a ProgressFunc.
+type Progress struct {
+	TotalBytes     int           // size of the stream, -1 if unknown
+	CompletedBytes int           // bytes received so far, including ones finished by a previous resumable download
+	ChunksTotal    int           // number of ranges requested
+	ChunksDone     int           // number of ranges received completely
+	ChunksFailed   int           // number of ranges failed after retries
+	Retries        int           // number of requests retried in this download
+	Speed          float64       // current throughput in bytes per second
+	Elapsed        time.Duration // time since the download started
+	ETA            time.Duration // estimated time left, 0 if unknown
+	Done           bool          // true for the last report of a download
+}
+
+// ProgressFunc receives progress of a download.
+// It is called serially from download goroutines, so it should return quickly.
+type ProgressFunc func(Progress)
+
+// progressTracker accumulates progress of one download and reports it.
+// All methods are no-op for a nil tracker, which is used when no ProgressFunc is set.
+type progressTracker struct {
+	mu             sync.Mutex
+	fn             ProgressFunc
+	stream         *Stream
+	retriesAtStart int
+	start          time.Time
+	lastReport     time.Time
+	lastSample     time.Time // time when speed was sampled last
+	lastBytes      int       // completed bytes when speed was sampled last
+	p              Progress
+}
+
+// newProgressTracker returns a tracker for a download of chunks ranges whose size is total,
+// or nil if OnProgress is not set.
+func (s *Stream) newProgressTracker(total, chunks, completed int) *progressTracker {
+	if s.OnProgress == nil {
+		return nil
+	}
+	now := time.Now()
+	t := &progressTracker{
+		fn:             s.OnProgress,
+		stream:         s,
+		retriesAtStart: s.Retries(),
+		start:          now,
+		lastReport:     now,
+		lastSample:     now,
+		lastBytes:      completed,
+		p: Progress{
+			TotalBytes:     total,
+			CompletedBytes: completed,
+			ChunksTotal:    chunks,
+		},
+	}
+	t.mu.Lock()
+	t.report(now)
+	t.mu.Unlock()
+	return t
+}
+
+// addBytes records received bytes, negative n discards bytes of a failed attempt
+func (t *progressTracker) addBytes(n int) {
+	if t == nil || n == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.CompletedBytes += n
+	if now := time.Now(); now.Sub(t.lastReport) >= progressInterval {
+		t.report(now)
+	}
+}
+
+// chunkDone records a range received completely or failed
+func (t *progressTracker) chunkDone(failed bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if failed {
+		t.p.ChunksFailed++
+	} else {
+		t.p.ChunksDone++
+	}
+	t.report(time.Now())
+}
+
+// finish sends the last report
+func (t *progressTracker) finish() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Done = true
+	t.p.ETA = 0
+	t.report(time.Now())
+}
+
+// writer returns a writer which records bytes written through it
+func (t *progressTracker) writer(w io.Writer) io.Writer {
+	if t == nil {
+		return w
+	}
+	return &progressWriter{w: w, t: t}
+}
+
+// report updates speed and ETA and calls the ProgressFunc, t.mu must be held
+func (t *progressTracker) report(now time.Time) {
+	// sample speed at intervals not to be disturbed by bursts of small writes
+	if dt := now.Sub(t.lastSample); dt >= progressInterval {
+		sample := float64(t.p.CompletedBytes-t.lastBytes) / dt.Seconds()
+		if t.p.Speed == 0 {
+			t.p.Speed = sample
+		} else {
+			t.p.Speed = speedSmoothing*sample + (1-speedSmoothing)*t.p.Speed
+		}
+		if t.p.Speed < 0 {
+			t.p.Speed = 0
+		}
+		t.lastSample = now
+		t.lastBytes = t.p.CompletedBytes
+	}
+	t.lastReport = now
+
+	t.p.Elapsed = now.Sub(t.start)
+	t.p.Retries = t.stream.Retries() - t.retriesAtStart
+	if remaining := t.p.TotalBytes - t.p.CompletedBytes; !t.p.Done && t.p.TotalBytes > 0 && remaining > 0 && t.p.Speed > 0 {
+		t.p.ETA = time.Duration(float64(remaining) / t.p.Speed * float64(time.Second))
+	} else {
+		t.p.ETA = 0
+	}
+	t.fn(t.p)
+}
+
+// progressWriter reports bytes written to a tracker
+type progressWriter struct {
+	w io.Writer
+	t *progressTracker
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.t.addBytes(n)
+	return n, err
+}
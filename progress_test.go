@@ -0,0 +1,87 @@
+package gotube
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParallelDownloadProgress(t *testing.T) {
+	content := []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10,
+	}
+	var mu sync.Mutex
+	var requested []int
+	var reports []Progress
+	stream := Stream{
+		url:      "https://foobar?itag=22&signature=geho",
+		Duration: time.Second * time.Duration(20*7.5),
+		client:   rangeServingClient(content, nil, &requested, &mu),
+		OnProgress: func(p Progress) {
+			reports = append(reports, p)
+		},
+	}
+
+	if err := stream.ParallelDownloadTo(new(bytes.Buffer), 100); err != nil {
+		t.Fatalf("stream download failed, %s", err)
+	}
+	if len(reports) < 2 {
+		t.Fatalf("got %d reports, expected at least the first and the last ones", len(reports))
+	}
+
+	for i := 1; i < len(reports); i++ {
+		if reports[i].ChunksDone < reports[i-1].ChunksDone {
+			t.Errorf("done chunks decreased from %d to %d", reports[i-1].ChunksDone, reports[i].ChunksDone)
+		}
+	}
+	last := reports[len(reports)-1]
+	expected := Progress{TotalBytes: 17, CompletedBytes: 17, ChunksTotal: 9, ChunksDone: 9, Done: true}
+	if last.TotalBytes != expected.TotalBytes || last.CompletedBytes != expected.CompletedBytes ||
+		last.ChunksTotal != expected.ChunksTotal || last.ChunksDone != expected.ChunksDone || !last.Done {
+		t.Errorf("got last report %+v, expected %+v", last, expected)
+	}
+	if last.ETA != 0 {
+		t.Errorf("ETA of the last report should be 0, got %s", last.ETA)
+	}
+}
+
+func TestProgressTracker(t *testing.T) {
+	var last Progress
+	stream := &Stream{OnProgress: func(p Progress) { last = p }}
+	tracker := stream.newProgressTracker(1000, 4, 200)
+	if last.CompletedBytes != 200 || last.TotalBytes != 1000 {
+		t.Errorf("got first report %+v", last)
+	}
+
+	// bytes of a failed attempt are discarded
+	tracker.addBytes(100)
+	tracker.addBytes(-100)
+	tracker.chunkDone(true)
+	if last.CompletedBytes != 200 || last.ChunksFailed != 1 {
+		t.Errorf("got report %+v after a failed attempt", last)
+	}
+
+	// speed and ETA are derived from bytes received in an interval
+	tracker.lastSample = tracker.lastSample.Add(-time.Second)
+	tracker.lastReport = tracker.lastSample
+	tracker.addBytes(400)
+	if last.Speed < 390 || last.Speed > 400 {
+		t.Errorf("got speed %f, expected about 400", last.Speed)
+	}
+	if last.ETA < time.Second || last.ETA > 1100*time.Millisecond {
+		t.Errorf("got ETA %s, expected about 1s", last.ETA)
+	}
+
+	tracker.finish()
+	if !last.Done || last.ETA != 0 {
+		t.Errorf("got last report %+v", last)
+	}
+
+	// nil tracker is no-op
+	var nilTracker *progressTracker
+	nilTracker.addBytes(1)
+	nilTracker.chunkDone(false)
+	nilTracker.finish()
+}
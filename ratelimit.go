@@ -0,0 +1,143 @@
+package gotube
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// maxLimitedRead is the maximum bytes read at once from a rate limited body
+	maxLimitedRead = 32 * 1024
+)
+
+var (
+	globalLimiterMu sync.RWMutex
+	globalLimiter   *RateLimiter
+)
+
+// RateLimiter limits throughput in bytes per second with a token bucket.
+// One limiter can be shared by streams to keep their combined throughput under the rate.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64 // maximum tokens accumulated while idle
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter which allows bytesPerSecond bytes per second.
+// Bytes of a quarter second can be consumed at once after an idle period.
+func NewRateLimiter(bytesPerSecond int) *RateLimiter {
+	l := &RateLimiter{last: time.Now()}
+	l.SetRate(bytesPerSecond)
+	return l
+}
+
+// SetRate changes the rate, which affects downloads in progress as well.
+func (l *RateLimiter) SetRate(bytesPerSecond int) {
+	if bytesPerSecond < 1 {
+		bytesPerSecond = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = float64(bytesPerSecond)
+	l.burst = l.rate / 4
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// WaitN blocks until n bytes are allowed or ctx is done.
+// n may exceed the burst, the following calls wait for the excess.
+// The n bytes are given back if ctx is done before they are allowed.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n) // reserve in advance, tokens can be negative
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens += float64(n)
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// SetGlobalRateLimit limits combined throughput of all downloads in the process.
+// 0 or less removes the limit. Limiters set to streams are applied as well.
+func SetGlobalRateLimit(bytesPerSecond int) {
+	globalLimiterMu.Lock()
+	defer globalLimiterMu.Unlock()
+	if bytesPerSecond <= 0 {
+		globalLimiter = nil
+		return
+	}
+	if globalLimiter == nil {
+		globalLimiter = NewRateLimiter(bytesPerSecond)
+		return
+	}
+	globalLimiter.SetRate(bytesPerSecond)
+}
+
+// rateLimiters returns limiters applied to the stream
+func (s *Stream) rateLimiters() []*RateLimiter {
+	limiters := make([]*RateLimiter, 0, 2)
+	globalLimiterMu.RLock()
+	if globalLimiter != nil {
+		limiters = append(limiters, globalLimiter)
+	}
+	globalLimiterMu.RUnlock()
+	if s.RateLimiter != nil {
+		limiters = append(limiters, s.RateLimiter)
+	}
+	return limiters
+}
+
+// limitReader returns a reader whose throughput is limited by the stream's limiters
+func (s *Stream) limitReader(ctx context.Context, r io.Reader) io.Reader {
+	limiters := s.rateLimiters()
+	if len(limiters) == 0 {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, limiters: limiters}
+}
+
+// limitedReader waits for limiters after each read
+type limitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*RateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > maxLimitedRead {
+		p = p[:maxLimitedRead]
+	}
+	n, err := lr.r.Read(p)
+	for _, l := range lr.limiters {
+		if errWait := l.WaitN(lr.ctx, n); errWait != nil {
+			return n, errWait
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,89 @@
+package gotube
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWaitN(t *testing.T) {
+	// 40KB/s allows 10KB at once, then 20KB more take about 0.5 seconds
+	l := NewRateLimiter(40 * 1024)
+	l.tokens = l.burst
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.WaitN(context.Background(), 10*1024); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond || elapsed > time.Second {
+		t.Errorf("30KB took %s, expected about 0.5s", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.WaitN(ctx, 100*1024); err != context.DeadlineExceeded {
+		t.Errorf("got error %v, expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRateLimiterWaitNCanceled(t *testing.T) {
+	l := NewRateLimiter(40 * 1024)
+	l.tokens = l.burst
+
+	// 100KB would keep the following calls waiting for 2.5 seconds if they were not given back
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.WaitN(ctx, 100*1024); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	start := time.Now()
+	if err := l.WaitN(context.Background(), 10*1024); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("10KB took %s after a canceled wait, expected no wait", elapsed)
+	}
+}
+
+func TestLimitedStreamRead(t *testing.T) {
+	content := bytes.Repeat([]byte{0x01}, 30*1024)
+	stream := &Stream{RateLimiter: NewRateLimiter(40 * 1024)}
+	stream.RateLimiter.tokens = stream.RateLimiter.burst
+
+	start := time.Now()
+	data, err := ioutil.ReadAll(stream.limitReader(context.Background(), bytes.NewReader(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data, content) != 0 {
+		t.Error("content is changed by the limited reader")
+	}
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Errorf("30KB took %s, expected about 0.5s", elapsed)
+	}
+}
+
+func TestSetGlobalRateLimit(t *testing.T) {
+	defer SetGlobalRateLimit(0)
+
+	stream := &Stream{}
+	if len(stream.rateLimiters()) != 0 {
+		t.Error("no limiter should be applied by default")
+	}
+
+	SetGlobalRateLimit(1024)
+	stream.RateLimiter = NewRateLimiter(2048)
+	if len(stream.rateLimiters()) != 2 {
+		t.Error("both global and stream limiters should be applied")
+	}
+
+	SetGlobalRateLimit(0)
+	if limiters := stream.rateLimiters(); len(limiters) != 1 || limiters[0] != stream.RateLimiter {
+		t.Error("only the stream limiter should be applied after the global limit is removed")
+	}
+}
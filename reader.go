@@ -0,0 +1,171 @@
+package gotube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	defaultReadAhead = 1024 * 1024
+)
+
+var errReaderClosed = errors.New("stream reader is closed")
+
+// StreamReader reads a stream like a remote file with range requests.
+// It implements io.ReadSeeker, io.ReaderAt and io.Closer.
+// Read fetches at least ReadAhead bytes of the stream at once and serves following reads from them.
+type StreamReader struct {
+	ctx       context.Context
+	stream    *Stream
+	size      int64
+	readAhead int
+	mu        sync.Mutex // guards fields below
+	offset    int64
+	bufStart  int64 // offset of buf in the stream
+	buf       []byte
+	closed    bool
+}
+
+// Open returns a reader of the stream content.
+// No content is downloaded until it is read.
+func (s *Stream) Open() (*StreamReader, error) {
+	return s.OpenContext(context.Background())
+}
+
+// OpenContext is Open with a context.
+// Requests of the reader are canceled when ctx is done.
+func (s *Stream) OpenContext(ctx context.Context) (*StreamReader, error) {
+	size, err := s.GetSizeContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	readAhead := s.ReadAhead
+	if readAhead <= 0 {
+		readAhead = defaultReadAhead
+	}
+	return &StreamReader{ctx: ctx, stream: s, size: int64(size), readAhead: readAhead}, nil
+}
+
+// Size returns the size of the stream
+func (r *StreamReader) Size() int64 {
+	return r.size
+}
+
+// Read reads from the current offset.
+// Data is requested when the offset is out of the range fetched last time.
+func (r *StreamReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, errReaderClosed
+	}
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if r.offset < r.bufStart || r.offset >= r.bufStart+int64(len(r.buf)) {
+		n := len(p)
+		if n < r.readAhead {
+			n = r.readAhead
+		}
+		data, err := r.fetch(r.offset, n)
+		if err != nil {
+			return 0, err
+		}
+		r.bufStart, r.buf = r.offset, data
+	}
+	n := copy(p, r.buf[r.offset-r.bufStart:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+// ReadAt reads len(p) bytes from off regardless of the current offset.
+// It requests exactly the bytes needed unless they have been fetched by Read,
+// and it can be called from multiple goroutines in parallel.
+func (r *StreamReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return 0, errReaderClosed
+	}
+	if len(p) == 0 {
+		r.mu.Unlock()
+		return 0, nil
+	}
+	if off >= r.bufStart && off+int64(len(p)) <= r.bufStart+int64(len(r.buf)) {
+		n := copy(p, r.buf[off-r.bufStart:])
+		r.mu.Unlock()
+		return n, nil
+	}
+	r.mu.Unlock()
+
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	data, err := r.fetch(off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, data)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Seek sets the offset for the next Read, which can be beyond the end of the stream.
+func (r *StreamReader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, errReaderClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Close releases fetched data, the reader cannot be used afterwards
+func (r *StreamReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.buf = nil
+	return nil
+}
+
+// fetch requests n bytes from off, or bytes up to the end of the stream if they are fewer
+func (r *StreamReader) fetch(off int64, n int) ([]byte, error) {
+	end := off + int64(n)
+	if end > r.size {
+		end = r.size
+	}
+	data, err := r.stream.download(r.ctx, rangeQuery(int(off), int(end-1)), nil)
+	if err != nil {
+		return nil, &RangeError{Start: int(off), End: int(end), Err: err}
+	}
+	if int64(len(data)) != end-off {
+		return nil, &RangeError{Start: int(off), End: int(end), Err: io.ErrUnexpectedEOF}
+	}
+	return data, nil
+}
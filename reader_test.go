@@ -0,0 +1,107 @@
+package gotube
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestStreamReader(t *testing.T) {
+	content := []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10,
+	}
+	var mu sync.Mutex
+	var requested []int
+	stream := Stream{
+		url:       "https://foobar?itag=22&signature=geho",
+		Retry:     &NoRetry,
+		ReadAhead: 4,
+		client:    rangeServingClient(content, nil, &requested, &mu),
+	}
+
+	r, err := stream.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Size() != int64(len(content)) {
+		t.Errorf("got size %d, expected %d", r.Size(), len(content))
+	}
+
+	t.Run("read ahead", func(t *testing.T) {
+		p := make([]byte, 2)
+		for i := 0; i < 2; i++ {
+			if n, err := r.Read(p); err != nil || n != 2 || !bytes.Equal(p, content[i*2:i*2+2]) {
+				t.Fatalf("read %v, %d, %v, expected %v", p, n, err, content[i*2:i*2+2])
+			}
+		}
+		if len(requested) != 1 {
+			t.Errorf("got %d requests, expected 1 for the read ahead window", len(requested))
+		}
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		if pos, err := r.Seek(-3, io.SeekEnd); err != nil || pos != 14 {
+			t.Fatalf("seek to %d, %v, expected 14", pos, err)
+		}
+		rest, err := ioutil.ReadAll(r)
+		if err != nil || !bytes.Equal(rest, content[14:]) {
+			t.Errorf("read %v, %v, expected %v", rest, err, content[14:])
+		}
+		if _, err := r.Seek(-1, io.SeekStart); err == nil {
+			t.Error("seek to a negative offset should be an error")
+		}
+	})
+
+	t.Run("read at", func(t *testing.T) {
+		p := make([]byte, 5)
+		if n, err := r.ReadAt(p, 6); err != nil || n != 5 || !bytes.Equal(p, content[6:11]) {
+			t.Errorf("read %v, %d, %v at 6, expected %v", p, n, err, content[6:11])
+		}
+		if n, err := r.ReadAt(p, 15); err != io.EOF || n != 2 || !bytes.Equal(p[:n], content[15:]) {
+			t.Errorf("read %v, %d, %v at 15, expected %v and EOF", p[:n], n, err, content[15:])
+		}
+		// an empty read needs no request even at the end
+		mu.Lock()
+		before := len(requested)
+		mu.Unlock()
+		if n, err := r.ReadAt(nil, 17); err != nil || n != 0 || len(requested) != before {
+			t.Errorf("read %d, %v with %d requests for an empty slice", n, err, len(requested)-before)
+		}
+
+		// works as a section of the stream
+		section, err := ioutil.ReadAll(io.NewSectionReader(r, 3, 8))
+		if err != nil || !bytes.Equal(section, content[3:11]) {
+			t.Errorf("read section %v, %v, expected %v", section, err, content[3:11])
+		}
+	})
+
+	r.Close()
+	if _, err := r.Read(make([]byte, 1)); err == nil {
+		t.Error("read from a closed reader should be an error")
+	}
+}
+
+func TestStreamReaderFailure(t *testing.T) {
+	content := make([]byte, 17)
+	var mu sync.Mutex
+	var requested []int
+	stream := Stream{
+		url:    "https://foobar?itag=22&signature=geho",
+		Retry:  &NoRetry,
+		client: rangeServingClient(content, map[int]bool{8: true}, &requested, &mu),
+	}
+
+	r, err := stream.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	_, err = r.ReadAt(make([]byte, 4), 8)
+	if rangeErr, ok := err.(*RangeError); !ok || rangeErr.Start != 8 || rangeErr.End != 12 {
+		t.Errorf("got %v, expected *RangeError of 8-12", err)
+	}
+}
@@ -0,0 +1,130 @@
+package gotube
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// urlExpiryMargin is time before expiry when a download url is refreshed in advance
+	urlExpiryMargin = 30 * time.Second
+)
+
+// ExpiresAt returns time when the signed download url of the stream expires.
+// The zero time is returned if the url has no expire parameter.
+func (s *Stream) ExpiresAt() time.Time {
+	s.urlMu.Lock()
+	defer s.urlMu.Unlock()
+	return expiryOf(s.url)
+}
+
+// expiryOf parses expire parameter of a url, which is a unix time
+func expiryOf(rawURL string) time.Time {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return time.Time{}
+	}
+	expire, err := strconv.ParseInt(u.Query().Get("expire"), 10, 64)
+	if err != nil || expire <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(expire, 0)
+}
+
+// rangeQuery returns a query appended to the download url to request bytes from start to end (inclusive)
+func rangeQuery(start, end int) string {
+	return fmt.Sprintf("&range=%d-%d", start, end)
+}
+
+// request sends a request to the download url followed by query.
+// The url is refreshed before the request when it is about to expire,
+// and once more when the request is rejected with 403 Forbidden.
+// It returns the url requested along with the response.
+func (s *Stream) request(ctx context.Context, method, query string) (*http.Response, string, error) {
+	downloadURL, err := s.freshURL(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := s.send(ctx, method, downloadURL+query)
+	if err != nil || res.StatusCode != http.StatusForbidden || s.refresh == nil {
+		return res, downloadURL + query, err
+	}
+
+	// the url may have expired during the download
+	if res.Body != nil {
+		res.Body.Close()
+	}
+	logger.printf("request to %s is forbidden, refresh the url", downloadURL+query)
+	if downloadURL, err = s.refreshURL(ctx, downloadURL); err != nil {
+		return nil, "", err
+	}
+	res, err = s.send(ctx, method, downloadURL+query)
+	return res, downloadURL + query, err
+}
+
+func (s *Stream) send(ctx context.Context, method, url string) (*http.Response, error) {
+	if method == http.MethodHead {
+		return s.client.Head(ctx, url)
+	}
+	return s.client.Get(ctx, url)
+}
+
+// freshURL returns the download url, refreshing it if it expires within urlExpiryMargin
+func (s *Stream) freshURL(ctx context.Context) (string, error) {
+	downloadURL, err := s.getDownloadURL()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := expiryOf(downloadURL)
+	if expiresAt.IsZero() || time.Until(expiresAt) > urlExpiryMargin {
+		return downloadURL, nil
+	}
+	if s.refresh == nil {
+		logger.printf("download url expires at %s and cannot be refreshed", expiresAt)
+		return downloadURL, nil
+	}
+	return s.refreshURL(ctx, downloadURL)
+}
+
+// refreshURL fetches the stream again and replaces the stale download url with a new one.
+// When requests running in parallel find the same stale url, the stream is fetched only once.
+func (s *Stream) refreshURL(ctx context.Context, stale string) (string, error) {
+	s.urlMu.Lock()
+	defer s.urlMu.Unlock()
+	if s.downloadURL != stale {
+		// already refreshed by another request
+		return s.buildDownloadURL()
+	}
+
+	fresh, err := s.refresh(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh download url, %s", err)
+	}
+	fresh.urlMu.Lock()
+	s.url, s.signature, s.decipherer = fresh.url, fresh.signature, fresh.decipherer
+	s.sigParam, s.signed = fresh.sigParam, fresh.signed
+	fresh.urlMu.Unlock()
+	s.downloadURL = ""
+	logger.printf("download url refreshed, expires at %s", expiryOf(s.url))
+	return s.buildDownloadURL()
+}
+
+// refresher returns a function which fetches streams of the video again and picks the one of a given itag
+func (dl *YoutubeDownloader) refresher(itag int) func(ctx context.Context) (*Stream, error) {
+	return func(ctx context.Context) (*Stream, error) {
+		fresh := &YoutubeDownloader{client: dl.client, url: dl.url, Retry: dl.Retry}
+		if err := fresh.FetchStreamsContext(ctx); err != nil {
+			return nil, err
+		}
+		for _, s := range fresh.Streams {
+			if s.itag == itag {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("stream of itag %d is no longer available", itag)
+	}
+}
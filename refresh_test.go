@@ -0,0 +1,150 @@
+package gotube
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	mock "github.com/matthewlujp/gotube/mocks"
+)
+
+// expiringClient serves content for urls with a valid signature and rejects others with 403
+func expiringClient(content []byte, validSignature string, requested *[]string, mu *sync.Mutex) *fakeClient {
+	header := make(http.Header)
+	header.Set("Content-Length", strconv.Itoa(len(content)))
+	forbidden := func() *http.Response {
+		return &http.Response{StatusCode: http.StatusForbidden, Status: "403 Forbidden", Body: ioutil.NopCloser(bytes.NewReader(nil))}
+	}
+	return &fakeClient{
+		fakeHead: func(ctx context.Context, url string) (*http.Response, error) {
+			if !strings.Contains(url, "&signature="+validSignature) {
+				return forbidden(), nil
+			}
+			return &http.Response{StatusCode: 200, Header: header}, nil
+		},
+		fakeGet: func(ctx context.Context, url string) (*http.Response, error) {
+			mu.Lock()
+			*requested = append(*requested, url)
+			mu.Unlock()
+			if !strings.Contains(url, "&signature="+validSignature) {
+				return forbidden(), nil
+			}
+			res := rangeParamRegex.FindStringSubmatch(url)
+			start, _ := strconv.Atoi(res[1])
+			end, _ := strconv.Atoi(res[2])
+			return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewReader(content[start : end+1]))}, nil
+		},
+	}
+}
+
+func TestExpiresAt(t *testing.T) {
+	stream := Stream{url: "https://foobar?itag=22&expire=1521061926&signature=geho"}
+	if expiresAt := stream.ExpiresAt(); !expiresAt.Equal(time.Unix(1521061926, 0)) {
+		t.Errorf("got expiry %s, expected %s", expiresAt, time.Unix(1521061926, 0))
+	}
+	stream = Stream{url: "https://foobar?itag=22&signature=geho"}
+	if expiresAt := stream.ExpiresAt(); !expiresAt.IsZero() {
+		t.Errorf("got expiry %s for a url without expire", expiresAt)
+	}
+}
+
+func TestRefreshForbiddenURL(t *testing.T) {
+	content := []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10,
+	}
+	future := time.Now().Add(time.Hour).Unix()
+	var mu sync.Mutex
+	var requested []string
+	var refreshes int32
+	stream := Stream{
+		url:      fmt.Sprintf("https://foobar?itag=22&expire=%d&signature=old", future),
+		Duration: time.Second * time.Duration(20*7.5),
+		Retry:    &NoRetry,
+		client:   expiringClient(content, "new", &requested, &mu),
+		refresh: func(ctx context.Context) (*Stream, error) {
+			atomic.AddInt32(&refreshes, 1)
+			return &Stream{url: fmt.Sprintf("https://foobar?itag=22&expire=%d&signature=new", future)}, nil
+		},
+	}
+
+	data, err := stream.ParallelDownload()
+	if err != nil {
+		t.Fatalf("download failed, %s", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("got %v, expected %v", data, content)
+	}
+	if refreshes != 1 {
+		t.Errorf("url was refreshed %d times, expected once", refreshes)
+	}
+}
+
+func TestRefreshExpiredURL(t *testing.T) {
+	content := []byte{0x00, 0x01, 0x02, 0x03}
+	var mu sync.Mutex
+	var requested []string
+	stream := Stream{
+		url:    fmt.Sprintf("https://foobar?itag=22&expire=%d&signature=old", time.Now().Add(-time.Minute).Unix()),
+		Retry:  &NoRetry,
+		client: expiringClient(content, "new", &requested, &mu),
+	}
+
+	// without a way to refresh, the expired url is requested and rejected
+	if _, err := stream.ParallelDownload(); err == nil {
+		t.Fatal("download with an expired url should fail")
+	}
+
+	requested = nil
+	stream.refresh = func(ctx context.Context) (*Stream, error) {
+		return &Stream{url: fmt.Sprintf("https://foobar?itag=22&expire=%d&signature=new", time.Now().Add(time.Hour).Unix())}, nil
+	}
+	data, err := stream.ParallelDownload()
+	if err != nil {
+		t.Fatalf("download failed, %s", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("got %v, expected %v", data, content)
+	}
+	for _, u := range requested {
+		if strings.Contains(u, "signature=old") {
+			t.Errorf("expired url %s is requested", u)
+		}
+	}
+}
+
+func TestStreamRefresher(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	c := mock.NewMockclient(ctrl)
+
+	gomock.InOrder(
+		c.EXPECT().Get(gomock.Any(), validURL).Return(getMockPage()),
+		c.EXPECT().Get(gomock.Any(), jsURL).Return(getContent(mockScriptPath)),
+		c.EXPECT().Get(gomock.Any(), validURL).Return(getMockPage()),
+		c.EXPECT().Get(gomock.Any(), jsURL).Return(getContent(mockScriptPath)),
+	)
+
+	downloader := YoutubeDownloader{url: validURL, client: c}
+	if err := downloader.FetchStreams(); err != nil {
+		t.Fatalf("error while fetching stream manifests, %s", err)
+	}
+
+	stream := downloader.Streams[0]
+	fresh, err := stream.refresh(context.Background())
+	if err != nil {
+		t.Fatalf("failed to refresh stream, %s", err)
+	}
+	if !fresh.equal(stream) {
+		t.Errorf("refreshed stream %v differs from %v", fresh, stream)
+	}
+}
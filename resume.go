@@ -0,0 +1,230 @@
+package gotube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	manifestSuffix = ".gotube"
+)
+
+// rangeManifest records which ranges of a stream have already been written to a file.
+// It is saved next to the file as a sidecar so that an interrupted download can be resumed.
+type rangeManifest struct {
+	VideoID       string          `json:"video_id"`
+	Itag          int             `json:"itag"`
+	ContentLength int             `json:"content_length"`
+	Chunks        []manifestChunk `json:"chunks"`
+}
+
+// manifestChunk is a byte range [Start, End) of a stream
+type manifestChunk struct {
+	Start    int  `json:"start"`
+	End      int  `json:"end"`
+	Finished bool `json:"finished"`
+}
+
+// ManifestPath returns a path of the sidecar manifest used by ResumableDownload for a file at path
+func ManifestPath(path string) string {
+	return path + manifestSuffix
+}
+
+// ResumableDownload downloads the stream into a file at path fetching ranges in parallel.
+// Finished ranges are recorded in a sidecar manifest (see ManifestPath),
+// so calling it again for the same stream after a failure fetches only missing ranges.
+// The manifest does not hold download urls, so a stream obtained by a new FetchStreams
+// can resume the download after the old signed url has expired.
+// The manifest is removed when the download completes.
+func (s *Stream) ResumableDownload(path string) error {
+	return s.ResumableDownloadContext(context.Background(), path)
+}
+
+// ResumableDownloadContext is ResumableDownload with a context.
+// Ranges finished before ctx is done are kept in the manifest.
+func (s *Stream) ResumableDownloadContext(ctx context.Context, path string) error {
+	ranges, errRanges := s.byteRanges(ctx, time.Second*20) // chunkSize of 20 seconds
+	if errRanges != nil {
+		return errRanges
+	}
+	contentLength := ranges[len(ranges)-1]
+
+	manifestPath := ManifestPath(path)
+	manifest, errLoad := loadManifest(manifestPath)
+	if errLoad != nil || !manifest.matches(s, contentLength) || !fileHasSize(path, contentLength) {
+		if errLoad != nil && !os.IsNotExist(errLoad) {
+			logger.printf("discard manifest %s, %s", manifestPath, errLoad)
+		}
+		manifest = newRangeManifest(s, ranges)
+	}
+
+	f, errOpen := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if errOpen != nil {
+		return fmt.Errorf("failed to create or open %s, %s", path, errOpen)
+	}
+	defer f.Close()
+	if err := f.Truncate(int64(contentLength)); err != nil {
+		return fmt.Errorf("failed to allocate %s, %s", path, err)
+	}
+	if err := manifest.save(manifestPath); err != nil {
+		return err
+	}
+
+	// decipher and get basic url
+	downloadURL, errURLBuild := s.getDownloadURL()
+	if errURLBuild != nil {
+		return errURLBuild
+	}
+	logger.printf("download url prepared: %s", downloadURL)
+
+	// cancel in-flight requests when one of them fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	finishedBytes := 0
+	for _, c := range manifest.Chunks {
+		if c.Finished {
+			finishedBytes += c.End - c.Start
+		}
+	}
+	t := s.newProgressTracker(contentLength, len(manifest.Chunks), finishedBytes)
+	defer t.finish()
+
+	var mu sync.Mutex // guards manifest and firstErr
+	var firstErr error
+	var wg sync.WaitGroup
+	pool, host := s.workerPool(), hostOf(downloadURL)
+	for i := range manifest.Chunks {
+		if manifest.Chunks[i].Finished {
+			continue
+		}
+
+		idx := i
+		wg.Add(1)
+		errSubmit := pool.submit(ctx, host, func() {
+			defer wg.Done()
+			chunk := manifest.Chunks[idx]
+			w := &offsetWriter{f: f, offset: int64(chunk.Start)}
+			errDL := s.downloadTo(ctx, chunk.Start, chunk.End-1, w, t)
+			t.chunkDone(errDL != nil)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if errDL != nil {
+				if firstErr == nil {
+					firstErr = &RangeError{Start: chunk.Start, End: chunk.End, Err: errDL}
+					cancel()
+				}
+				return
+			}
+			manifest.Chunks[idx].Finished = true
+			if err := manifest.save(manifestPath); err != nil {
+				logger.printf("%s", err)
+			}
+		})
+		if errSubmit != nil {
+			wg.Done()
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = errSubmit
+			}
+			mu.Unlock()
+			break
+		}
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to flush %s, %s", path, err)
+	}
+	if err := os.Remove(manifestPath); err != nil {
+		return fmt.Errorf("failed to remove manifest %s, %s", manifestPath, err)
+	}
+	logger.print("download completed")
+	return nil
+}
+
+func newRangeManifest(s *Stream, ranges []int) *rangeManifest {
+	chunks := make([]manifestChunk, 0, len(ranges)-1)
+	for i := range ranges[:len(ranges)-1] {
+		chunks = append(chunks, manifestChunk{Start: ranges[i], End: ranges[i+1]})
+	}
+	return &rangeManifest{
+		VideoID:       s.videoID,
+		Itag:          s.itag,
+		ContentLength: ranges[len(ranges)-1],
+		Chunks:        chunks,
+	}
+}
+
+func loadManifest(path string) (*rangeManifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &rangeManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest, %s", err)
+	}
+	return m, nil
+}
+
+// matches checks whether the manifest was recorded for the stream
+func (m *rangeManifest) matches(s *Stream, contentLength int) bool {
+	if m.VideoID != s.videoID || m.Itag != s.itag || m.ContentLength != contentLength {
+		return false
+	}
+	// chunks must cover the whole content without gaps
+	next := 0
+	for _, c := range m.Chunks {
+		if c.Start != next || c.End <= c.Start {
+			return false
+		}
+		next = c.End
+	}
+	return next == contentLength
+}
+
+// save writes the manifest to a temporary file and renames it not to leave a broken manifest
+func (m *rangeManifest) save(path string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest %s, %s", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to save manifest %s, %s", path, err)
+	}
+	return nil
+}
+
+func fileHasSize(path string, size int) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Size() == int64(size)
+}
+
+// offsetWriter writes to a file successively from a given offset
+type offsetWriter struct {
+	f      *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
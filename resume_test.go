@@ -0,0 +1,133 @@
+package gotube
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+var rangeParamRegex = regexp.MustCompile(`&range=(\d+)-(\d+)`)
+
+// rangeServingClient returns a fake client which serves a given content for range requests
+// requests whose start is in failStarts fail
+func rangeServingClient(content []byte, failStarts map[int]bool, requested *[]int, mu *sync.Mutex) *fakeClient {
+	header := make(http.Header)
+	header.Set("Content-Length", strconv.Itoa(len(content)))
+	return &fakeClient{
+		fakeHead: func(ctx context.Context, url string) (*http.Response, error) {
+			return &http.Response{StatusCode: 200, Header: header}, nil
+		},
+		fakeGet: func(ctx context.Context, url string) (*http.Response, error) {
+			res := rangeParamRegex.FindStringSubmatch(url)
+			start, _ := strconv.Atoi(res[1])
+			end, _ := strconv.Atoi(res[2])
+			mu.Lock()
+			*requested = append(*requested, start)
+			mu.Unlock()
+			if failStarts[start] {
+				return nil, errors.New("connection reset by peer")
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewReader(content[start : end+1])),
+			}, nil
+		},
+	}
+}
+
+func TestResumableDownload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "video.mp4")
+
+	content := []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10,
+	}
+	var mu sync.Mutex
+	var requested []int
+
+	// the first attempt fails for the range starting from 6
+	stream := Stream{
+		videoID:  "iEPTlhBmwRg",
+		itag:     22,
+		url:      "https://foobar?itag=22&signature=geho",
+		Duration: time.Second * time.Duration(20*7.5),
+		Retry:    &NoRetry,
+		client:   rangeServingClient(content, map[int]bool{6: true}, &requested, &mu),
+	}
+	if err := stream.ResumableDownload(path); err == nil {
+		t.Fatal("download should fail")
+	}
+	manifest, errLoad := loadManifest(ManifestPath(path))
+	if errLoad != nil {
+		t.Fatalf("manifest should remain after failure, %s", errLoad)
+	}
+	for _, c := range manifest.Chunks {
+		if c.Start == 6 && c.Finished {
+			t.Error("failed range is recorded as finished")
+		}
+	}
+
+	// the second attempt with a fresh url fetches only unfinished ranges
+	requested = nil
+	stream = Stream{
+		videoID:  "iEPTlhBmwRg",
+		itag:     22,
+		url:      "https://foobar?itag=22&signature=fresh",
+		Duration: time.Second * time.Duration(20*7.5),
+		client:   rangeServingClient(content, nil, &requested, &mu),
+	}
+	if err := stream.ResumableDownload(path); err != nil {
+		t.Fatalf("resumed download failed, %s", err)
+	}
+	for _, start := range requested {
+		for _, c := range manifest.Chunks {
+			if c.Start == start && c.Finished {
+				t.Errorf("finished range starting from %d is requested again", start)
+			}
+		}
+	}
+
+	data, errRead := ioutil.ReadFile(path)
+	if errRead != nil {
+		t.Fatal(errRead)
+	}
+	if bytes.Compare(data, content) != 0 {
+		t.Errorf("got file content %v, expected %v", data, content)
+	}
+	if _, err := os.Stat(ManifestPath(path)); !os.IsNotExist(err) {
+		t.Error("manifest should be removed after completion")
+	}
+}
+
+func TestRangeManifestMatches(t *testing.T) {
+	stream := &Stream{videoID: "iEPTlhBmwRg", itag: 22}
+	m := newRangeManifest(stream, []int{0, 5, 10, 12})
+
+	if !m.matches(stream, 12) {
+		t.Error("manifest should match the stream it is created for")
+	}
+	if m.matches(&Stream{videoID: "iEPTlhBmwRg", itag: 18}, 12) {
+		t.Error("manifest should not match a stream of another itag")
+	}
+	if m.matches(stream, 13) {
+		t.Error("manifest should not match a different content length")
+	}
+	m.Chunks = m.Chunks[1:]
+	if m.matches(stream, 12) {
+		t.Error("manifest with a gap should not match")
+	}
+}
@@ -0,0 +1,196 @@
+package gotube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed request is retried.
+// It is applied to range requests, head requests, and requests for a watch page and a player script.
+type RetryPolicy struct {
+	MaxAttempts    int              // total number of attempts including the first one, 1 or less means no retry
+	InitialBackoff time.Duration    // wait before the first retry
+	MaxBackoff     time.Duration    // upper limit of a wait, 0 means no limit
+	Multiplier     float64          // backoff is multiplied by this every retry, less than 1 is regarded as 1
+	Jitter         float64          // ratio of backoff randomized, e.g. 0.2 makes a wait 80%-120% of backoff
+	RetryStatuses  []int            // http statuses which are retried
+	RetryError     func(error) bool // decides whether an error other than a status is retried, nil means transient network errors
+}
+
+// DefaultRetryPolicy is used when no policy is set to a Stream or a YoutubeDownloader.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	RetryStatuses: []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+}
+
+// NoRetry is a policy which never retries.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// StatusError is returned when a server responds with an unexpected status.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	retryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("request to %s got status %s", e.URL, e.Status)
+}
+
+func newStatusError(url string, res *http.Response) *StatusError {
+	e := &StatusError{URL: url, StatusCode: res.StatusCode, Status: res.Status}
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		e.retryAfter = time.Duration(seconds) * time.Second
+	}
+	return e
+}
+
+// noRetryError marks an error which must not be retried regardless of a policy
+type noRetryError struct {
+	err error
+}
+
+func (e *noRetryError) Error() string {
+	return e.err.Error()
+}
+
+// do calls fn until it succeeds, fails with an error not to be retried, or attempts run out.
+// The error of the last attempt is returned unchanged, and the number of retries is added to retries.
+func (p *RetryPolicy) do(ctx context.Context, retries *int64, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if e, ok := err.(*noRetryError); ok {
+			return e.err
+		}
+		if attempt >= p.MaxAttempts || !p.retryable(err) {
+			// returned as it is so that callers can inspect it, the retries are counted in retries
+			return err
+		}
+
+		wait := p.backoff(attempt)
+		if e, ok := err.(*StatusError); ok && e.retryAfter > wait {
+			wait = e.retryAfter
+		}
+		logger.printf("%s, retry in %s", err, wait)
+		atomic.AddInt64(retries, 1)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// retryable checks whether an error is worth retrying
+func (p *RetryPolicy) retryable(err error) bool {
+	if e, ok := err.(*StatusError); ok {
+		for _, code := range p.RetryStatuses {
+			if e.StatusCode == code {
+				return true
+			}
+		}
+		return false
+	}
+	if p.RetryError != nil {
+		return p.RetryError(err)
+	}
+	return isTransientError(err)
+}
+
+// backoff returns a wait before the given attempt is retried
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := math.Max(p.Multiplier, 1)
+	wait := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 {
+		wait = math.Min(wait, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		wait *= 1 - p.Jitter + 2*p.Jitter*rand.Float64()
+	}
+	return time.Duration(wait)
+}
+
+// isTransientError checks whether an error is caused by a temporary network failure
+func isTransientError(err error) bool {
+	if err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range []string{"connection reset", "broken pipe", "timed out", "timeout", "unexpected EOF", "connection refused"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryPolicy returns a policy applied to requests of the stream
+func (s *Stream) retryPolicy() *RetryPolicy {
+	if s.Retry != nil {
+		return s.Retry
+	}
+	return &DefaultRetryPolicy
+}
+
+// Retries returns the number of requests retried so far for this stream
+func (s *Stream) Retries() int {
+	return int(atomic.LoadInt64(&s.retries))
+}
+
+// retryPolicy returns a policy applied to requests of the downloader
+func (dl *YoutubeDownloader) retryPolicy() *RetryPolicy {
+	if dl.Retry != nil {
+		return dl.Retry
+	}
+	return &DefaultRetryPolicy
+}
+
+// Retries returns the number of requests for a watch page and a player script retried so far
+func (dl *YoutubeDownloader) Retries() int {
+	return int(atomic.LoadInt64(&dl.retries))
+}
+
+// retryPolicy returns a policy applied to requests of the pages listing videos
+func (l *videoLister) retryPolicy() *RetryPolicy {
+	if l.Retry != nil {
+		return l.Retry
+	}
+	return &DefaultRetryPolicy
+}
+
+// Retries returns the number of requests for pages listing videos and their continuations retried so far
+func (l *videoLister) Retries() int {
+	return int(atomic.LoadInt64(&l.retries))
+}
@@ -0,0 +1,205 @@
+package gotube
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var fastRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	Multiplier:     2,
+	RetryStatuses:  []int{http.StatusServiceUnavailable},
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, e := range expected {
+		if b := p.backoff(i + 1); b != e {
+			t.Errorf("backoff for attempt %d is %s, expected %s", i+1, b, e)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if b := p.backoff(1); b < 500*time.Millisecond || b > 1500*time.Millisecond {
+			t.Fatalf("backoff with jitter %s is out of range", b)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	t.Run("retry until success", func(t *testing.T) {
+		var retries int64
+		calls := 0
+		err := fastRetryPolicy.do(context.Background(), &retries, func() error {
+			calls++
+			if calls < 3 {
+				return &StatusError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("unexpected error, %s", err)
+		}
+		if calls != 3 || retries != 2 {
+			t.Errorf("got %d calls and %d retries, expected 3 calls and 2 retries", calls, retries)
+		}
+	})
+
+	t.Run("give up after max attempts", func(t *testing.T) {
+		var retries int64
+		calls := 0
+		err := fastRetryPolicy.do(context.Background(), &retries, func() error {
+			calls++
+			return errors.New("read: connection reset by peer")
+		})
+		if err == nil {
+			t.Error("error should be returned after attempts run out")
+		}
+		if calls != 3 || retries != 2 {
+			t.Errorf("got %d calls and %d retries, expected 3 calls and 2 retries", calls, retries)
+		}
+	})
+
+	t.Run("status of the last attempt", func(t *testing.T) {
+		var retries int64
+		errUnavailable := &StatusError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}
+		err := fastRetryPolicy.do(context.Background(), &retries, func() error {
+			return errUnavailable
+		})
+		if e, ok := err.(*StatusError); !ok || e != errUnavailable || retries != 2 {
+			t.Errorf("got error %v after %d retries, expected %v after 2", err, retries, errUnavailable)
+		}
+	})
+
+	t.Run("not retryable", func(t *testing.T) {
+		var retries int64
+		calls := 0
+		errNotFound := &StatusError{StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+		err := fastRetryPolicy.do(context.Background(), &retries, func() error {
+			calls++
+			return errNotFound
+		})
+		if err != errNotFound {
+			t.Errorf("got error %v, expected %v", err, errNotFound)
+		}
+		if calls != 1 || retries != 0 {
+			t.Errorf("got %d calls and %d retries, expected no retry", calls, retries)
+		}
+	})
+
+	t.Run("canceled while waiting", func(t *testing.T) {
+		var retries int64
+		p := fastRetryPolicy
+		p.InitialBackoff = time.Hour
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := p.do(ctx, &retries, func() error {
+			return &StatusError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}
+		})
+		if err != context.DeadlineExceeded {
+			t.Errorf("got error %v, expected %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestStreamDownloadRetry(t *testing.T) {
+	content := []byte{0x00, 0x01, 0x02, 0x03}
+	calls := 0
+	c := &fakeClient{
+		fakeGet: func(ctx context.Context, url string) (*http.Response, error) {
+			calls++
+			if calls == 1 {
+				return &http.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Status:     "503 Service Unavailable",
+					Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewReader(content)),
+			}, nil
+		},
+	}
+	stream := Stream{
+		url:    "https://foobar?itag=22&signature=geho",
+		Retry:  &fastRetryPolicy,
+		client: c,
+	}
+
+	data, err := stream.Download()
+	if err != nil {
+		t.Fatalf("stream download failed, %s", err)
+	}
+	if bytes.Compare(data, content) != 0 {
+		t.Errorf("got stream data %v, expected %v", data, content)
+	}
+	if stream.Retries() != 1 {
+		t.Errorf("got %d retries, expected 1", stream.Retries())
+	}
+}
+
+// brokenReader reads n bytes of content then fails
+type brokenReader struct {
+	content []byte
+	n       int
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, errors.New("connection reset by peer")
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	n := copy(p, r.content)
+	r.content, r.n = r.content[n:], r.n-n
+	return n, nil
+}
+
+func TestStreamDownloadRetryRest(t *testing.T) {
+	content := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07}
+	var requested []string
+	c := &fakeClient{
+		fakeGet: func(ctx context.Context, url string) (*http.Response, error) {
+			requested = append(requested, url)
+			if len(requested) == 1 {
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(&brokenReader{content: content, n: 3})}, nil
+			}
+			m := rangeParamRegex.FindStringSubmatch(url)
+			if m == nil {
+				t.Fatalf("rest is requested without range, %s", url)
+			}
+			start, _ := strconv.Atoi(m[1])
+			end, _ := strconv.Atoi(m[2])
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(content[start : end+1]))}, nil
+		},
+	}
+	stream := Stream{
+		url:           "https://foobar?itag=22&signature=geho",
+		Retry:         &fastRetryPolicy,
+		ContentLength: len(content),
+		client:        c,
+	}
+
+	data, err := stream.Download()
+	if err != nil {
+		t.Fatalf("stream download failed, %s", err)
+	}
+	if bytes.Compare(data, content) != 0 {
+		t.Errorf("got stream data %v, expected %v", data, content)
+	}
+	if len(requested) != 2 || !strings.HasSuffix(requested[1], "&range=3-7") {
+		t.Errorf("requested %v, expected the rest from 3", requested)
+	}
+}
@@ -0,0 +1,342 @@
+package gotube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serverPathPrefix = "/v/"
+	// serverManifestName is the last element of the path of a DASH manifest of a video
+	serverManifestName = "manifest.mpd"
+	// proxyChunkSize is the maximum bytes requested to upstream at once while serving a range
+	proxyChunkSize = 8 * 1024 * 1024
+	// DefaultServerCacheTTL is how long a Server keeps streams of a video by default
+	DefaultServerCacheTTL = time.Hour
+	// serverFetchTimeout limits a fetch of streams, which does not stop when the request waiting for it is canceled
+	serverFetchTimeout = time.Minute
+)
+
+var errInvalidRange = errors.New("invalid range")
+
+// Server is an http.Handler which serves streams at /v/{videoID}/{itag} with range support.
+// Range headers of requests are forwarded as range requests to YouTube,
+// and expired download urls are refreshed transparently.
+// A DASH manifest of adaptive streams of a video is served at /v/{videoID}/manifest.mpd,
+// whose representations refer to the streams served by the Server, so that a DASH player can play the video through it.
+// Streams of a video are fetched at the first request for the video and kept for CacheTTL.
+// A failed fetch is not kept, and tried again at the next request.
+// Requests with an invalid video id are answered with 400, and those for a video not available with 404.
+type Server struct {
+	CacheTTL time.Duration // DefaultServerCacheTTL is used if 0
+	mu       sync.Mutex
+	videos   map[string]*serverVideo
+	fetch    func(ctx context.Context, videoID string) ([]*Stream, error)
+}
+
+// serverVideo holds streams of a video, fetched once for the first request
+type serverVideo struct {
+	done    chan struct{} // closed when the fetch finishes
+	streams []*Stream
+	err     error
+	expires time.Time // zero while being fetched, guarded by mu of the Server
+}
+
+// NewServer returns a Server.
+// Retry, Pool and RateLimiter of downloader are set to fetched streams if it is not nil.
+func NewServer(downloader *YoutubeDownloader) *Server {
+	s := &Server{videos: make(map[string]*serverVideo)}
+	s.fetch = func(ctx context.Context, videoID string) ([]*Stream, error) {
+		dl, err := NewDownloader(CanonicalWatchURL(videoID))
+		if err != nil {
+			return nil, err
+		}
+		if downloader != nil {
+			dl.Retry, dl.Pool, dl.RateLimiter = downloader.Retry, downloader.Pool, downloader.RateLimiter
+		}
+		if err := dl.FetchStreamsContext(ctx); err != nil {
+			return nil, err
+		}
+		return dl.Streams, nil
+	}
+	return s
+}
+
+// ServeHTTP serves a whole stream or a range of it
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if videoID, ok := parseManifestPath(r.URL.Path); ok {
+		s.serveManifest(w, r, videoID)
+		return
+	}
+	videoID, itag, ok := parseServerPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !videoIDPattern.MatchString(videoID) {
+		http.Error(w, fmt.Sprintf("invalid video id %s", videoID), http.StatusBadRequest)
+		return
+	}
+
+	stream, err := s.stream(r.Context(), videoID, itag)
+	if err != nil {
+		logger.printf("failed to get stream %d of %s, %s", itag, videoID, err)
+		http.Error(w, err.Error(), fetchErrorStatus(err))
+		return
+	}
+	if stream == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	size, err := stream.GetSizeContext(r.Context())
+	if err != nil {
+		logger.printf("failed to get size of stream %d of %s, %s", itag, videoID, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	start, end, partial, err := parseRangeHeader(r.Header.Get("Range"), size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Content-Length", strconv.Itoa(end-start))
+	if stream.MediaType != "" && stream.Format != "" {
+		header.Set("Content-Type", fmt.Sprintf("%s/%s", stream.MediaType, stream.Format))
+	}
+	status := http.StatusOK
+	if partial {
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, size))
+		status = http.StatusPartialContent
+	}
+	if r.Method == http.MethodHead || start == end {
+		w.WriteHeader(status)
+		return
+	}
+
+	// the status is sent with the first bytes, so that a failure before them can be reported as 502
+	lw := &lazyHeaderWriter{w: w, status: status}
+	for chunkStart := start; chunkStart < end; chunkStart += proxyChunkSize {
+		chunkEnd := chunkStart + proxyChunkSize
+		if chunkEnd > end {
+			chunkEnd = end
+		}
+		if err := stream.downloadTo(r.Context(), chunkStart, chunkEnd-1, lw, nil); err != nil {
+			logger.printf("failed to serve range %d-%d of stream %d of %s, %s", chunkStart, chunkEnd-1, itag, videoID, err)
+			if !lw.wrote {
+				header.Del("Content-Length")
+				header.Del("Content-Range")
+				http.Error(w, err.Error(), http.StatusBadGateway)
+			}
+			return // the connection is closed without the rest of the content
+		}
+	}
+}
+
+// serveManifest serves a DASH manifest whose BaseURLs are paths of streams relative to the manifest
+func (s *Server) serveManifest(w http.ResponseWriter, r *http.Request, videoID string) {
+	if !videoIDPattern.MatchString(videoID) {
+		http.Error(w, fmt.Sprintf("invalid video id %s", videoID), http.StatusBadRequest)
+		return
+	}
+	streams, err := s.streams(r.Context(), videoID)
+	if err != nil {
+		logger.printf("failed to get streams of %s, %s", videoID, err)
+		http.Error(w, err.Error(), fetchErrorStatus(err))
+		return
+	}
+	manifest, err := dashManifest(streams, func(stream *Stream) (string, error) {
+		return strconv.Itoa(stream.itag), nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	header := w.Header()
+	header.Set("Content-Type", "application/dash+xml")
+	header.Set("Content-Length", strconv.Itoa(len(manifest)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(manifest)
+}
+
+// stream returns a stream of a video of a given itag, or nil if the video does not have it
+func (s *Server) stream(ctx context.Context, videoID string, itag int) (*Stream, error) {
+	streams, err := s.streams(ctx, videoID)
+	if err != nil {
+		return nil, err
+	}
+	for _, stream := range streams {
+		if stream.itag == itag {
+			return stream, nil
+		}
+	}
+	return nil, nil
+}
+
+// streams returns streams of a video, fetching them at the first call for the video or after they expire.
+// The fetch is shared by requests for the video and goes on when one of them is canceled,
+// while each request stops waiting for it when its ctx is done.
+func (s *Server) streams(ctx context.Context, videoID string) ([]*Stream, error) {
+	s.mu.Lock()
+	s.evict(time.Now())
+	v, ok := s.videos[videoID]
+	if !ok {
+		v = &serverVideo{done: make(chan struct{})}
+		s.videos[videoID] = v
+		go s.fetchVideo(videoID, v)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-v.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if v.err != nil {
+		return nil, v.err
+	}
+	return v.streams, nil
+}
+
+// fetchVideo fetches streams of a video into v, which is removed on failure so that it is tried again at the next request
+func (s *Server) fetchVideo(videoID string, v *serverVideo) {
+	ctx, cancel := context.WithTimeout(context.Background(), serverFetchTimeout)
+	defer cancel()
+	v.streams, v.err = s.fetch(ctx, videoID)
+
+	ttl := s.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultServerCacheTTL
+	}
+	s.mu.Lock()
+	v.expires = time.Now().Add(ttl)
+	if v.err != nil && s.videos[videoID] == v {
+		delete(s.videos, videoID)
+	}
+	s.mu.Unlock()
+	close(v.done)
+}
+
+// fetchErrorStatus returns the status answered for a failure of fetching streams,
+// 404 for a video which is not available and 502 for other failures of upstream
+func fetchErrorStatus(err error) int {
+	if _, ok := err.(*unplayableError); ok {
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// evict removes videos expired at now, s.mu must be held
+func (s *Server) evict(now time.Time) {
+	for id, v := range s.videos {
+		if !v.expires.IsZero() && now.After(v.expires) {
+			delete(s.videos, id)
+		}
+	}
+}
+
+// parseManifestPath extracts a video id from a path /v/{videoID}/manifest.mpd
+func parseManifestPath(path string) (string, bool) {
+	if !strings.HasPrefix(path, serverPathPrefix) {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(path, serverPathPrefix), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != serverManifestName {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// parseServerPath extracts a video id and an itag from a path /v/{videoID}/{itag}
+func parseServerPath(path string) (string, int, bool) {
+	if !strings.HasPrefix(path, serverPathPrefix) {
+		return "", 0, false
+	}
+	parts := strings.Split(strings.TrimPrefix(path, serverPathPrefix), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		return "", 0, false
+	}
+	itag, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, false
+	}
+	return parts[0], itag, true
+}
+
+// parseRangeHeader returns a range [start, end) designated by a Range header.
+// partial is false if the header is empty and the whole content is requested.
+// Only a single range is supported.
+func parseRangeHeader(value string, size int) (start, end int, partial bool, err error) {
+	if value == "" {
+		return 0, size, false, nil
+	}
+	if !strings.HasPrefix(value, "bytes=") || strings.Contains(value, ",") {
+		return 0, 0, false, errInvalidRange
+	}
+	spec := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(value, "bytes=")), "-", 2)
+	if len(spec) != 2 {
+		return 0, 0, false, errInvalidRange
+	}
+
+	if spec[0] == "" {
+		// suffix range, the last n bytes
+		n, err := strconv.Atoi(spec[1])
+		if err != nil || n <= 0 {
+			return 0, 0, false, errInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size, true, nil
+	}
+
+	start, errStart := strconv.Atoi(spec[0])
+	if errStart != nil || start < 0 || start >= size {
+		return 0, 0, false, errInvalidRange
+	}
+	end = size
+	if spec[1] != "" {
+		last, errEnd := strconv.Atoi(spec[1])
+		if errEnd != nil || last < start {
+			return 0, 0, false, errInvalidRange
+		}
+		if last+1 < size {
+			end = last + 1
+		}
+	}
+	return start, end, true, nil
+}
+
+// lazyHeaderWriter writes a status code right before the first bytes of the body
+type lazyHeaderWriter struct {
+	w      http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (lw *lazyHeaderWriter) Write(p []byte) (int, error) {
+	if !lw.wrote {
+		lw.w.WriteHeader(lw.status)
+		lw.wrote = true
+	}
+	return lw.w.Write(p)
+}
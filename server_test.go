@@ -0,0 +1,284 @@
+package gotube
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeUpstream serves content for range requests whose url has a valid signature
+func fakeUpstream(content []byte, validSignature string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("signature") != validSignature {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		start, end := 0, len(content)-1
+		if res := rangeParamRegex.FindStringSubmatch(r.URL.String()); res != nil {
+			start, _ = strconv.Atoi(res[1])
+			end, _ = strconv.Atoi(res[2])
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		if r.Method == http.MethodGet {
+			w.Write(content[start : end+1])
+		}
+	}))
+}
+
+func TestServer(t *testing.T) {
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	upstream := fakeUpstream(content, "new")
+	defer upstream.Close()
+
+	stream, _ := newStream(map[string]string{
+		"url":  upstream.URL + "/videoplayback?itag=140&signature=old",
+		"itag": "140",
+		"type": "audio/mp4; codecs=\"mp4a.40.2\"",
+	}, &youtubeClient{}, nil)
+	stream.Retry = &NoRetry
+	stream.refresh = func(ctx context.Context) (*Stream, error) {
+		return &Stream{url: upstream.URL + "/videoplayback?itag=140&signature=new"}, nil
+	}
+
+	fetches := 0
+	handler := &Server{
+		videos: make(map[string]*serverVideo),
+		fetch: func(ctx context.Context, videoID string) ([]*Stream, error) {
+			fetches++
+			return []*Stream{stream}, nil
+		},
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	cases := []struct {
+		path         string
+		rangeHeader  string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"/v/09R8_2nJtjg/140", "", http.StatusOK, string(content), ""},
+		{"/v/09R8_2nJtjg/140", "bytes=10-19", http.StatusPartialContent, "abcdefghij", "bytes 10-19/36"},
+		{"/v/09R8_2nJtjg/140", "bytes=30-", http.StatusPartialContent, "uvwxyz", "bytes 30-35/36"},
+		{"/v/09R8_2nJtjg/140", "bytes=-3", http.StatusPartialContent, "xyz", "bytes 33-35/36"},
+		{"/v/09R8_2nJtjg/140", "bytes=34-100", http.StatusPartialContent, "yz", "bytes 34-35/36"},
+		{"/v/09R8_2nJtjg/140", "bytes=40-50", http.StatusRequestedRangeNotSatisfiable, "", "bytes */36"},
+		{"/v/09R8_2nJtjg/22", "", http.StatusNotFound, "", ""},
+		{"/v/09R8_2nJtjg", "", http.StatusNotFound, "", ""},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+c.path, nil)
+		if c.rangeHeader != "" {
+			req.Header.Set("Range", c.rangeHeader)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if res.StatusCode != c.status {
+			t.Errorf("%s %s: got status %d, expected %d", c.path, c.rangeHeader, res.StatusCode, c.status)
+			continue
+		}
+		if c.status < 300 && string(body) != c.body {
+			t.Errorf("%s %s: got body %q, expected %q", c.path, c.rangeHeader, body, c.body)
+		}
+		if cr := res.Header.Get("Content-Range"); cr != c.contentRange {
+			t.Errorf("%s %s: got Content-Range %q, expected %q", c.path, c.rangeHeader, cr, c.contentRange)
+		}
+		if c.status == http.StatusOK && res.Header.Get("Content-Type") != "audio/mp4" {
+			t.Errorf("got Content-Type %q, expected audio/mp4", res.Header.Get("Content-Type"))
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("streams are fetched %d times, expected once", fetches)
+	}
+	if !strings.Contains(stream.downloadURL, "signature=new") {
+		t.Errorf("download url %s is not refreshed", stream.downloadURL)
+	}
+}
+
+func TestServerCache(t *testing.T) {
+	fetches := 0
+	handler := &Server{
+		CacheTTL: time.Hour,
+		videos:   make(map[string]*serverVideo),
+		fetch: func(ctx context.Context, videoID string) ([]*Stream, error) {
+			fetches++
+			if fetches == 1 {
+				return nil, errors.New("connection reset by peer")
+			}
+			return []*Stream{{itag: 140}}, nil
+		},
+	}
+	ctx := context.Background()
+
+	// a failure is not kept
+	if _, err := handler.streams(ctx, "09R8_2nJtjg"); err == nil {
+		t.Error("the first fetch should fail")
+	}
+	for i := 0; i < 2; i++ {
+		if streams, err := handler.streams(ctx, "09R8_2nJtjg"); err != nil || len(streams) != 1 {
+			t.Errorf("got %d streams, %v", len(streams), err)
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("streams are fetched %d times, expected 2", fetches)
+	}
+
+	// streams are fetched again after they expire
+	handler.videos["09R8_2nJtjg"].expires = time.Now().Add(-time.Second)
+	if _, err := handler.streams(ctx, "09R8_2nJtjg"); err != nil || fetches != 3 {
+		t.Errorf("streams are fetched %d times after expired, %v", fetches, err)
+	}
+}
+
+func TestServerFetchOutlivesCanceledRequest(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := &Server{
+		videos: make(map[string]*serverVideo),
+		fetch: func(ctx context.Context, videoID string) ([]*Stream, error) {
+			close(started)
+			select {
+			case <-release:
+				return []*Stream{{itag: 140}}, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		},
+	}
+
+	// the first request is canceled while the fetch goes on
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := handler.streams(ctx, "09R8_2nJtjg")
+		first <- err
+	}()
+	<-started
+	second := make(chan error, 1)
+	go func() {
+		streams, err := handler.streams(context.Background(), "09R8_2nJtjg")
+		if err == nil && len(streams) != 1 {
+			err = errors.New("no stream")
+		}
+		second <- err
+	}()
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("got %v for the canceled request, expected %v", err, context.Canceled)
+	}
+	close(release)
+	select {
+	case err := <-second:
+		if err != nil {
+			t.Errorf("the waiting request failed, %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the waiting request is not answered")
+	}
+}
+
+func TestServerErrorStatus(t *testing.T) {
+	fetches := 0
+	handler := &Server{
+		videos: make(map[string]*serverVideo),
+		fetch: func(ctx context.Context, videoID string) ([]*Stream, error) {
+			fetches++
+			if videoID == "aaaaaaaaaaa" {
+				return nil, &unplayableError{status: "ERROR", reason: "Video unavailable"}
+			}
+			return nil, errors.New("connection reset by peer")
+		},
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	cases := []struct {
+		path   string
+		status int
+	}{
+		{"/v/not-an-id/140", http.StatusBadRequest},
+		{"/v/not-an-id/manifest.mpd", http.StatusBadRequest},
+		{"/v/aaaaaaaaaaa/140", http.StatusNotFound},
+		{"/v/aaaaaaaaaaa/manifest.mpd", http.StatusNotFound},
+		{"/v/09R8_2nJtjg/140", http.StatusBadGateway},
+	}
+	for _, c := range cases {
+		res, err := http.Get(server.URL + c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != c.status {
+			t.Errorf("%s: got status %d, expected %d", c.path, res.StatusCode, c.status)
+		}
+	}
+	if fetches != 3 {
+		t.Errorf("streams are fetched %d times, expected 3 for valid ids", fetches)
+	}
+}
+
+func TestParseServerPath(t *testing.T) {
+	if videoID, itag, ok := parseServerPath("/v/09R8_2nJtjg/140"); !ok || videoID != "09R8_2nJtjg" || itag != 140 {
+		t.Errorf("got %s, %d, %t, expected 09R8_2nJtjg and 140", videoID, itag, ok)
+	}
+	for _, path := range []string{"/", "/v/09R8_2nJtjg", "/v//140", "/v/09R8_2nJtjg/mp4", "/x/09R8_2nJtjg/140"} {
+		if _, _, ok := parseServerPath(path); ok {
+			t.Errorf("%s should be rejected", path)
+		}
+	}
+}
+
+func TestServerManifest(t *testing.T) {
+	handler := &Server{
+		videos: make(map[string]*serverVideo),
+		fetch: func(ctx context.Context, videoID string) ([]*Stream, error) {
+			return dashTestStreams(t), nil
+		},
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/v/09R8_2nJtjg/manifest.mpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, expected 200", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/dash+xml" {
+		t.Errorf("got Content-Type %q, expected application/dash+xml", ct)
+	}
+	// representations refer to streams served next to the manifest
+	for _, baseURL := range []string{"<BaseURL>137</BaseURL>", "<BaseURL>140</BaseURL>", "<BaseURL>248</BaseURL>"} {
+		if !strings.Contains(string(body), baseURL) {
+			t.Errorf("no %s in the manifest", baseURL)
+		}
+	}
+	if strings.Contains(string(body), "foobar") {
+		t.Error("upstream url is exposed in the manifest")
+	}
+}
+
+func TestParseManifestPath(t *testing.T) {
+	if videoID, ok := parseManifestPath("/v/09R8_2nJtjg/manifest.mpd"); !ok || videoID != "09R8_2nJtjg" {
+		t.Errorf("got %s, %t, expected 09R8_2nJtjg", videoID, ok)
+	}
+	for _, path := range []string{"/v/09R8_2nJtjg/140", "/v//manifest.mpd", "/x/09R8_2nJtjg/manifest.mpd", "/v/09R8_2nJtjg/manifest.mpd/140"} {
+		if _, ok := parseManifestPath(path); ok {
+			t.Errorf("%s should be rejected", path)
+		}
+	}
+}
@@ -2,21 +2,25 @@ package gotube
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
-
-	"golang.org/x/sync/errgroup"
 )
 
 const (
 	maxSimultaneousRequests = 20
+	defaultMaxBufferedBytes = 64 * 1024 * 1024
+	// defaultSignatureParam is the url parameter a deciphered signature is added as unless sp designates another
+	defaultSignatureParam = "signature"
 )
 
 var (
@@ -26,34 +30,87 @@ var (
 // Stream represents a video data of a specific format.
 // This structure is responsible for downloading video.
 type Stream struct {
-	itag         int
-	Abr          string
-	Fps          string
-	Resolution   string
-	MediaType    string // video, audio
-	Quality      string // hd720
-	Format       string // mp4
-	VideoCodec   string
-	AudioCodec   string
-	Is3D         bool
-	IsLive       bool
-	Duration     time.Duration
-	signature    string
-	url          string
-	downloadURL  string
-	buildURLOnce sync.Once
-	client       client
-	decipherer   decipherer
+	retries       int64 // accessed atomically, kept first for 64-bit alignment
+	videoID       string
+	itag          int
+	Abr           string
+	Fps           string
+	Resolution    string
+	MediaType     string // video, audio
+	Quality       string // hd720
+	Format        string // mp4
+	VideoCodec    string
+	AudioCodec    string
+	Is3D          bool
+	IsLive        bool
+	Duration      time.Duration
+	ContentLength int          // size in bytes given with stream info, 0 if unknown
+	Retry         *RetryPolicy // policy for failed requests, DefaultRetryPolicy is used if nil
+	Pool          *WorkerPool  // pool running range requests, DefaultWorkerPool is used if nil
+	OnProgress    ProgressFunc // called with progress of downloads if set
+	RateLimiter   *RateLimiter // limits throughput of downloads, applied together with SetGlobalRateLimit
+	ReadAhead     int          // bytes fetched at once by a reader from Open, defaultReadAhead if 0
+	signature     string
+	sigParam      string // name of the url parameter of the deciphered signature, "signature" if empty
+	signed        bool   // the url is usable without a signature added
+	url           string
+	downloadURL   string
+	urlMu         sync.Mutex // guards url, signature and the others replaced by refresh
+	initRange     byteRange  // range of the container header, given to DASH streams
+	indexRange    byteRange  // range of the container index, sidx or Cues
+	bitrate       int        // in bits per second given to DASH streams, 0 if unknown
+	width         int        // given to DASH video streams, 0 if unknown
+	height        int
+	frameRate     int
+	segmentsMu    sync.Mutex
+	segmentCache  []mediaSegment
+	refresh       func(ctx context.Context) (*Stream, error) // fetches the same stream again to renew an expired url
+	client        client
+	decipherer    decipherer
 }
 
 // Download returns a byte slice of video content
 func (s *Stream) Download() ([]byte, error) {
+	return s.DownloadContext(context.Background())
+}
+
+// DownloadContext is Download with a context.
+// The request is canceled when ctx is done.
+func (s *Stream) DownloadContext(ctx context.Context) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := s.DownloadToContext(ctx, buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DownloadTo writes video content to w as it arrives
+// The whole content is never held in memory.
+func (s *Stream) DownloadTo(w io.Writer) error {
+	return s.DownloadToContext(context.Background(), w)
+}
+
+// DownloadToContext is DownloadTo with a context.
+// The request is canceled when ctx is done.
+func (s *Stream) DownloadToContext(ctx context.Context, w io.Writer) error {
 	downloadURL, errURLBuild := s.getDownloadURL()
 	if errURLBuild != nil {
-		return nil, errURLBuild
+		return errURLBuild
 	}
 	logger.printf("download url prepared: %s", downloadURL)
-	return s.download(downloadURL)
+
+	var t *progressTracker
+	if s.OnProgress != nil {
+		size, err := s.GetSizeContext(ctx)
+		if err != nil {
+			size = -1 // progress is reported without total size
+		}
+		t = s.newProgressTracker(size, 1, 0)
+		defer t.finish()
+	}
+	err := s.downloadTo(ctx, 0, -1, w, t)
+	t.chunkDone(err != nil)
+	return err
 }
 
 // ParallelDownload returns a byte slice of video content
@@ -62,56 +119,124 @@ func (s *Stream) Download() ([]byte, error) {
 // Bytes for 20 seconds are calculated based on video duration and the bytes length.
 // Bytes length are checked before the get request by sending head rewquest.
 func (s *Stream) ParallelDownload() ([]byte, error) {
-	ranges, errRanges := s.byteRanges(time.Second * 20) // chunkSize of 20 seconds
-	if errRanges != nil {
-		return nil, errRanges
+	return s.ParallelDownloadContext(context.Background())
+}
+
+// ParallelDownloadContext is ParallelDownload with a context.
+// In-flight requests are canceled when ctx is done.
+func (s *Stream) ParallelDownloadContext(ctx context.Context) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := s.ParallelDownloadToContext(ctx, buf, defaultMaxBufferedBytes); err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), nil
+}
+
+// ParallelDownloadTo writes video content to w in order while fetching ranges in parallel.
+// A video is separated every 20 seconds and they are requested in parallel (see byteRanges).
+// At most maxBufferedBytes of fetched but not yet written data are held in memory,
+// so new requests wait until preceding chunks are written to w.
+// A chunk larger than maxBufferedBytes is fetched alone.
+// 64MiB are buffered at most if maxBufferedBytes is 0 or less.
+func (s *Stream) ParallelDownloadTo(w io.Writer, maxBufferedBytes int) error {
+	return s.ParallelDownloadToContext(context.Background(), w, maxBufferedBytes)
+}
 
-	collectedData := make([][]byte, len(ranges)-1)
+// ParallelDownloadToContext is ParallelDownloadTo with a context.
+// In-flight requests are canceled when ctx is done or one of them fails.
+func (s *Stream) ParallelDownloadToContext(ctx context.Context, w io.Writer, maxBufferedBytes int) error {
+	ranges, errRanges := s.byteRanges(ctx, time.Second*20) // chunkSize of 20 seconds
+	if errRanges != nil {
+		return errRanges
+	}
 
 	// decipher and get basic url
 	downloadURL, errURLBuild := s.getDownloadURL()
 	if errURLBuild != nil {
-		return nil, errURLBuild
+		return errURLBuild
 	}
 	logger.printf("download url prepared: %s", downloadURL)
 
-	// parallel download
-	eg := errgroup.Group{}
-	for i := range ranges[:len(ranges)-1] {
-		idx := i
-		eg.Go(func() error {
-			data, err := s.download(fmt.Sprintf("%s&range=%d-%d", downloadURL, ranges[idx], ranges[idx+1]-1))
-			if err != nil {
-				return err
-			}
+	// cancel in-flight requests when returned early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-			collectedData[idx] = data
-			return nil
-		})
+	chunkCount := len(ranges) - 1
+	collectedData := make([][]byte, chunkCount)
+	// each channel receives the result of a fetch, buffered so that a fetch never blocks
+	doneChans := make([]chan error, chunkCount)
+	for i := range doneChans {
+		doneChans[i] = make(chan error, 1)
 	}
-	if err := eg.Wait(); err != nil {
-		return nil, err
+
+	t := s.newProgressTracker(ranges[chunkCount], chunkCount, 0)
+	defer t.finish()
+
+	if maxBufferedBytes <= 0 {
+		maxBufferedBytes = defaultMaxBufferedBytes
 	}
-	logger.print("download completed")
+	budget := newByteBudget(maxBufferedBytes)
+	defer budget.abort() // stop starting new requests when returned early
+
+	// submit requests to the worker pool in order as long as buffered bytes are within the budget
+	pool, host := s.workerPool(), hostOf(downloadURL)
+	go func() {
+		for i := 0; i < chunkCount; i++ {
+			if !budget.acquire(ranges[i+1] - ranges[i]) {
+				return
+			}
+			idx := i
+			errSubmit := pool.submit(ctx, host, func() {
+				var errDL error
+				collectedData[idx], errDL = s.download(ctx, rangeQuery(ranges[idx], ranges[idx+1]-1), t)
+				t.chunkDone(errDL != nil)
+				doneChans[idx] <- errDL
+			})
+			if errSubmit != nil {
+				doneChans[idx] <- errSubmit
+				return
+			}
+		}
+	}()
 
-	// concatenate downloaded data
-	for _, data := range collectedData[1:] {
-		collectedData[0] = append(collectedData[0], data...)
+	// write fetched chunks in order and release their bytes from the budget
+	for i, ch := range doneChans {
+		select {
+		case err := <-ch:
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if _, err := w.Write(collectedData[i]); err != nil {
+			return fmt.Errorf("failed to write range %d-%d, %s", ranges[i], ranges[i+1]-1, err)
+		}
+		collectedData[i] = nil
+		budget.release(ranges[i+1] - ranges[i])
 	}
-	return collectedData[0], nil
+	logger.print("download completed")
+	return nil
 }
 
-// SequentialChunkDownload returns a channel from which you can receive data chunks successively.
-// Download is conducted in a goroutine (parallel) and []byte is sent through the returned channel in order.
+// SequentialChunkDownload returns a ChunkReader from which you can receive data chunks successively.
+// Download is conducted in goroutines (parallel) and chunks are received in order.
+// If a chunk fails to be downloaded after retries, the download stops and the reader reports
+// the failed range as *RangeError.
 //
-// A video is separated every {chunkDuration} seconds and they are requested in parallel
-// Bytes for {chunkDuration} seconds are calculated based on video duration and the bytes length.
-// Bytes length are checked before the get request by sending head rewquest.
-// TODO: return a channel for error as well
-// TODO: receive signal and stop goroutines
-func (s *Stream) SequentialChunkDownload(chunkDuration time.Duration) (<-chan []byte, error) {
-	ranges, errRanges := s.byteRanges(chunkDuration) // byte size of chunkDuration
+// A video is separated every {chunkDuration} seconds and they are requested in parallel.
+// For DASH streams, chunks are split on segment boundaries read from the container index,
+// so each chunk starts at a keyframe and covers at least {chunkDuration} except for the last one.
+// Otherwise bytes for {chunkDuration} seconds are estimated based on video duration and the bytes length.
+func (s *Stream) SequentialChunkDownload(chunkDuration time.Duration) (*ChunkReader, error) {
+	return s.SequentialChunkDownloadContext(context.Background(), chunkDuration)
+}
+
+// SequentialChunkDownloadContext is SequentialChunkDownload with a context.
+// When ctx is done, in-flight requests are canceled, no more requests are started
+// and the reader reports ctx.Err().
+func (s *Stream) SequentialChunkDownloadContext(ctx context.Context, chunkDuration time.Duration) (*ChunkReader, error) {
+	ranges, errRanges := s.byteRanges(ctx, chunkDuration) // byte size of chunkDuration
 	if errRanges != nil {
 		return nil, errRanges
 	}
@@ -123,78 +248,161 @@ func (s *Stream) SequentialChunkDownload(chunkDuration time.Duration) (<-chan []
 	}
 	logger.printf("download url prepared: %s", downloadURL)
 
-	// create a slice of channels to notify completion of data fetch by sending empty struct
-	doneChans := []chan struct{}{}
+	// canceled by the reader on failure or Close
+	ctx, cancel := context.WithCancel(ctx)
+
+	// create a slice of channels to receive a result of data fetch
+	// they are buffered so that a fetch never blocks after cancellation
+	doneChans := []chan chunkResult{}
 	for i := 0; i < len(ranges)-1; i++ {
-		doneChans = append(doneChans, make(chan struct{}))
+		doneChans = append(doneChans, make(chan chunkResult, 1))
 	}
-	// slice of []byte to save arriced data
-	collectedData := make([][]byte, len(ranges)-1)
 
-	// create another data channel for output
-	outputChan := make(chan []byte)
+	t := s.newProgressTracker(ranges[len(ranges)-1], len(ranges)-1, 0)
+
+	// create another channel for output
+	outputChan := make(chan chunkResult)
 	// reorder arrived data and resend to outputChan in a goroutine
+	// the first failure is sent and the rest are discarded
 	go func() {
-		for i, ch := range doneChans {
-			<-ch // wait data fetch completion notification
-			outputChan <- collectedData[i]
+		defer close(outputChan)
+		defer t.finish()
+		for _, ch := range doneChans {
+			var res chunkResult
+			select {
+			case res = <-ch: // wait data fetch completion
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case outputChan <- res:
+			case <-ctx.Done():
+				return
+			}
+			if res.err != nil {
+				return
+			}
 		}
-		close(outputChan)
 	}()
 
-	// use semaphore to limit simultaneous request to YouTube
-	semaphore := make(chan struct{}, maxSimultaneousRequests)
-
-	// parallel download
-	// TODO: use worker & dispatcher model to reuse goroutines
+	// parallel download on the worker pool which limits simultaneous requests to YouTube
+	// on a failure, requests of the following chunks are canceled, while those before it are still delivered
+	var mu sync.Mutex // guards failedAt and cancels
+	failedAt := len(ranges) - 1
+	cancels := make([]context.CancelFunc, len(ranges)-1)
+	pool, host := s.workerPool(), hostOf(downloadURL)
 	go func() {
 		for i := range ranges[:len(ranges)-1] {
-			semaphore <- struct{}{} // block if requests count exceeds the limit
 			idx := i
-			go func() {
-				defer func() {
-					<-semaphore // release one slot
-				}()
-				var errDL error
-				collectedData[idx], errDL = s.download(fmt.Sprintf("%s&range=%d-%d", downloadURL, ranges[idx], ranges[idx+1]-1))
-				if errDL != nil {
-					// TODO: retry 3 times
-					if strings.Contains(errDL.Error(), "operation timed out") {
-						logger.printf("range %d-%d, timeout -> retry", ranges[idx], ranges[idx+1]-1)
-						// retry for timeout
-						collectedData[idx], errDL = s.download(fmt.Sprintf("%s&range=%d-%d", downloadURL, ranges[idx], ranges[idx+1]-1))
-						if errDL != nil {
-							logger.printf("range %d-%d, retry failed, %s", ranges[idx], ranges[idx+1]-1, errDL)
+			chunkCtx, cancelChunk := context.WithCancel(ctx)
+			mu.Lock()
+			if idx > failedAt {
+				mu.Unlock()
+				cancelChunk()
+				return
+			}
+			cancels[idx] = cancelChunk
+			mu.Unlock()
+			errSubmit := pool.submit(ctx, host, func() {
+				defer cancelChunk()
+				res := chunkResult{start: ranges[idx], end: ranges[idx+1]}
+				res.data, res.err = s.download(chunkCtx, rangeQuery(ranges[idx], ranges[idx+1]-1), t)
+				t.chunkDone(res.err != nil)
+				if res.err != nil {
+					logger.printf("range %d-%d, %s", ranges[idx], ranges[idx+1]-1, res.err)
+					mu.Lock()
+					if idx < failedAt {
+						failedAt = idx
+						for _, c := range cancels[idx+1:] {
+							if c != nil {
+								c()
+							}
 						}
-					} else {
-						logger.printf("range %d-%d, %s", ranges[idx], ranges[idx+1]-1, errDL)
 					}
+					mu.Unlock()
 				}
-				doneChans[idx] <- struct{}{}
-			}()
+				doneChans[idx] <- res
+			})
+			if errSubmit != nil {
+				cancelChunk()
+				doneChans[idx] <- chunkResult{start: ranges[idx], end: ranges[idx+1], err: errSubmit}
+				return
+			}
 		}
 	}()
 
-	// a caller of this method receives data via outputChan
-	return outputChan, nil
+	// a caller of this method receives data via the reader
+	return &ChunkReader{
+		ctx:       ctx,
+		cancel:    cancel,
+		results:   outputChan,
+		remaining: len(ranges) - 1,
+	}, nil
 }
 
 // download get resource and return byte slice
-func (s *Stream) download(url string) ([]byte, error) {
-	res, errGet := s.client.Get(url)
+// query such as a range is appended to the download url.
+// Failed requests are retried according to the retry policy.
+// Received bytes are recorded to t.
+func (s *Stream) download(ctx context.Context, query string, t *progressTracker) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := s.retryPolicy().do(ctx, &s.retries, func() error {
+		t.addBytes(-buf.Len()) // discard bytes received by a failed attempt
+		buf.Reset()
+		return s.fetch(ctx, query, t.writer(buf))
+	})
+	if err != nil {
+		t.addBytes(-buf.Len())
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// downloadTo get bytes from start to end, both inclusive, and copy them to w
+// end is negative for the rest of the stream, which is requested without a range when start is 0.
+// Failed requests are retried according to the retry policy.
+// A request failed after some content has been written to w is retried for the remaining bytes.
+// Received bytes are recorded to t.
+func (s *Stream) downloadTo(ctx context.Context, start, end int, w io.Writer, t *progressTracker) error {
+	cw := &countingWriter{w: t.writer(w)}
+	return s.retryPolicy().do(ctx, &s.retries, func() error {
+		offset := start + int(cw.n)
+		var query string
+		if end >= 0 {
+			if offset > end {
+				return nil // failed after the last byte
+			}
+			query = rangeQuery(offset, end)
+		} else if offset > 0 {
+			// the end of the rest is needed to request it by range
+			size, err := s.GetSizeContext(ctx)
+			if err != nil {
+				return &noRetryError{fmt.Errorf("failed to get the size to download the rest from %d, %s", offset, err)}
+			}
+			if offset >= size {
+				return nil
+			}
+			query = rangeQuery(offset, size-1)
+		}
+		return s.fetch(ctx, query, cw)
+	})
+}
+
+// fetch sends a get request and copy its body to w
+func (s *Stream) fetch(ctx context.Context, query string, w io.Writer) error {
+	res, url, errGet := s.request(ctx, http.MethodGet, query)
 	if errGet != nil {
-		return nil, errGet
+		return errGet
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("get request to %s got status %s", url, res.Status)
+		return newStatusError(url, res)
 	}
 
-	buf := new(bytes.Buffer)
-	if _, err := buf.ReadFrom(res.Body); err != nil {
-		return nil, fmt.Errorf("failed to read content downloaded from %s, %s", url, err)
+	if _, err := io.Copy(w, s.limitReader(ctx, res.Body)); err != nil {
+		return fmt.Errorf("failed to read content downloaded from %s, %s", url, err)
 	}
-	return buf.Bytes(), nil
+	return nil
 }
 
 func (s *Stream) String() string {
@@ -208,15 +416,28 @@ func (s *Stream) String() string {
 	return base + ">"
 }
 
-// byteRanges returns a slice of indexes that split the video data evenly except for the last chunk.
-// One chunk is less or equivalent to a given duration.
-// [0, 1*chunkSize, 2*chunkSize, ..., size], is used to designate start and end of a stream
-func (s *Stream) byteRanges(duration time.Duration) ([]int, error) {
-	totalSize, err := s.GetSize()
+// byteRanges returns a slice of indexes that split the video data into chunks of a given duration.
+// [0, start of 2nd chunk, start of 3rd chunk, ..., size], is used to designate start and end of a stream
+// If the stream has a container index, chunks are split on segment boundaries listed in it.
+// Otherwise the data is split evenly except for the last chunk assuming a constant bitrate,
+// and one chunk is less or equivalent to a given duration.
+func (s *Stream) byteRanges(ctx context.Context, duration time.Duration) ([]int, error) {
+	totalSize, err := s.GetSizeContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to split video data, %s", err)
 	}
 
+	if s.hasIndex() {
+		segments, errSegments := s.segments(ctx, totalSize)
+		if errSegments == nil {
+			return segmentRanges(segments, totalSize, duration), nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logger.printf("failed to read container index, ranges are estimated from duration, %s", errSegments)
+	}
+
 	chunkSize := s.bytesForDuration(totalSize, duration)
 	ranges := make([]int, 0, totalSize/chunkSize+1)
 	for i := 0; i*chunkSize < totalSize; i++ {
@@ -235,27 +456,45 @@ func (s *Stream) bytesForDuration(dataSize int, fetchDuration time.Duration) int
 }
 
 // GetSize returns content size of this stream
+// ContentLength is returned if known, otherwise the size is checked by a head request.
 func (s *Stream) GetSize() (int, error) {
-	sURL, errURL := s.getDownloadURL()
-	if errURL != nil {
-		return -1, errURL
-	}
-	res, err := s.client.Head(sURL)
-	if err != nil {
-		return -1, err
+	return s.GetSizeContext(context.Background())
+}
+
+// GetSizeContext is GetSize with a context.
+// The head request is canceled when ctx is done.
+func (s *Stream) GetSizeContext(ctx context.Context) (int, error) {
+	if s.ContentLength > 0 {
+		return s.ContentLength, nil
 	}
-	defer func() {
-		if res.Body != nil {
-			res.Body.Close()
+
+	var size int
+	errHead := s.retryPolicy().do(ctx, &s.retries, func() error {
+		res, sURL, err := s.request(ctx, http.MethodHead, "")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if res.Body != nil {
+				res.Body.Close()
+			}
+		}()
+		if res.StatusCode != http.StatusOK {
+			return newStatusError(sURL, res)
 		}
-	}()
 
-	if res.Header.Get("Content-Length") == "" {
-		return -1, errors.New("GetSize failed, header does not contain Content-Length")
-	}
-	size, errConvert := strconv.Atoi(res.Header.Get("Content-Length"))
-	if errConvert != nil {
-		return -1, fmt.Errorf("GetSize failed, invalid size %s, %s", res.Header.Get("Content-Length"), errConvert)
+		if res.Header.Get("Content-Length") == "" {
+			return &noRetryError{errors.New("GetSize failed, header does not contain Content-Length")}
+		}
+		var errConvert error
+		size, errConvert = strconv.Atoi(res.Header.Get("Content-Length"))
+		if errConvert != nil {
+			return &noRetryError{fmt.Errorf("GetSize failed, invalid size %s, %s", res.Header.Get("Content-Length"), errConvert)}
+		}
+		return nil
+	})
+	if errHead != nil {
+		return -1, errHead
 	}
 	return size, nil
 }
@@ -268,6 +507,12 @@ func (s *Stream) GetSize() (int, error) {
 // type: "video/mp4; codecs=\"avc1.64001F, mp4a.40.2\"", "audio/webm; codecs=\"opus\""
 // itag:
 // duration: video duration in seconds
+// video_id: id of the video which the stream belongs to
+// init, index: byte ranges of the container header and index, such as 0-714
+// clen, contentLength: size in bytes, taken from clen parameter of url if not given
+// bitrate, size, fps: bits per second, width x height and frame rate of DASH streams
+// sp: name of the url parameter of the deciphered signature, signature by default
+// signed: true if url is usable as it is without s
 func newStream(streamInfo map[string]string, c client, d decipherer) (*Stream, error) {
 	s := Stream{}
 
@@ -280,6 +525,12 @@ func newStream(streamInfo map[string]string, c client, d decipherer) (*Stream, e
 	if v, ok := streamInfo["s"]; ok {
 		s.signature = v
 	}
+	if v, ok := streamInfo["sp"]; ok && v != defaultSignatureParam {
+		s.sigParam = v
+	}
+	if v, ok := streamInfo["signed"]; ok {
+		s.signed = v == "true"
+	}
 
 	if v, ok := streamInfo["quality"]; ok {
 		s.Quality = v
@@ -335,30 +586,93 @@ func newStream(streamInfo map[string]string, c client, d decipherer) (*Stream, e
 		}
 	}
 
+	if v, ok := streamInfo["video_id"]; ok {
+		s.videoID = v
+	}
+
+	if v, ok := streamInfo["clen"]; ok {
+		s.ContentLength = parseContentLength(v)
+	} else if v, ok := streamInfo["contentLength"]; ok {
+		s.ContentLength = parseContentLength(v)
+	} else {
+		s.ContentLength = contentLengthInURL(s.url)
+	}
+
+	if v, ok := streamInfo["init"]; ok {
+		if r, ok := parseByteRange(v); ok {
+			s.initRange = r
+		}
+	}
+	if v, ok := streamInfo["index"]; ok {
+		if r, ok := parseByteRange(v); ok {
+			s.indexRange = r
+		}
+	}
+
+	if v, ok := streamInfo["bitrate"]; ok {
+		s.bitrate, _ = strconv.Atoi(v)
+	}
+	if v, ok := streamInfo["size"]; ok {
+		if size := strings.Split(v, "x"); len(size) == 2 {
+			s.width, _ = strconv.Atoi(size[0])
+			s.height, _ = strconv.Atoi(size[1])
+		}
+	}
+	if v, ok := streamInfo["fps"]; ok {
+		s.frameRate, _ = strconv.Atoi(v)
+	}
+
 	s.client = c
 	s.decipherer = d
 	return &s, nil
 }
 
+// parseContentLength converts a size given as a string, it returns 0 if invalid
+func parseContentLength(v string) int {
+	size, err := strconv.Atoi(v)
+	if err != nil || size < 0 {
+		logger.printf("invalid content length %s", v)
+		return 0
+	}
+	return size
+}
+
+// contentLengthInURL returns a value of clen parameter of a stream url, or 0 if not found
+func contentLengthInURL(streamURL string) int {
+	u, err := url.Parse(streamURL)
+	if err != nil {
+		return 0
+	}
+	if v := u.Query().Get("clen"); v != "" {
+		return parseContentLength(v)
+	}
+	return 0
+}
+
 // getDownloadURL returns a url with a deciphered signature added
-// Building url is only conducted once.
+// Building url is only conducted once until the url is refreshed.
 func (s *Stream) getDownloadURL() (string, error) {
-	// decipher signature and build download url only once
-	s.buildURLOnce.Do(
-		func() {
-			if strings.Contains(s.url, "&signature=") {
-				// signature has already been included
-				s.downloadURL = s.url
-				return
-			}
+	s.urlMu.Lock()
+	defer s.urlMu.Unlock()
+	return s.buildDownloadURL()
+}
 
-			if s.signature != "" && s.decipherer != nil {
-				if decipheredSig, err := s.decipherer.Decipher(s.signature); err == nil {
-					s.downloadURL = fmt.Sprintf("%s&signature=%s", s.url, decipheredSig)
-				}
+// buildDownloadURL deciphers signature and builds download url if not built yet, s.urlMu must be held
+func (s *Stream) buildDownloadURL() (string, error) {
+	if s.downloadURL == "" {
+		param := s.sigParam
+		if param == "" {
+			param = defaultSignatureParam
+		}
+		if s.signed || strings.Contains(s.url, "&"+param+"=") {
+			// signature has already been included
+			s.downloadURL = s.url
+		} else if s.signature != "" && s.decipherer != nil {
+			if decipheredSig, err := s.decipherer.Decipher(s.signature); err == nil {
+				s.downloadURL = fmt.Sprintf("%s&%s=%s", s.url, param, decipheredSig)
 			}
-		},
-	)
+		}
+	}
 
 	if s.downloadURL == "" {
 		return "", errors.New("failed to decipher signature")
@@ -382,3 +696,67 @@ func (s *Stream) equal(other *Stream) bool {
 		s.url == other.url &&
 		s.Duration == other.Duration
 }
+
+// byteBudget limits the number of bytes held in memory at once.
+type byteBudget struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   int
+	used    int
+	aborted bool
+}
+
+func newByteBudget(limit int) *byteBudget {
+	b := &byteBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire blocks until n bytes are available and reserves them.
+// A request larger than the limit waits until nothing else is reserved.
+// It returns false if the budget is aborted.
+func (b *byteBudget) acquire(n int) bool {
+	if n > b.limit {
+		n = b.limit
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for !b.aborted && b.used > 0 && b.used+n > b.limit {
+		b.cond.Wait()
+	}
+	if b.aborted {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+func (b *byteBudget) release(n int) {
+	if n > b.limit {
+		n = b.limit
+	}
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// abort wakes up and rejects all waiting and future acquisitions.
+func (b *byteBudget) abort() {
+	b.mu.Lock()
+	b.aborted = true
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// countingWriter counts bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
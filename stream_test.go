@@ -2,12 +2,16 @@ package gotube
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -51,8 +55,8 @@ func TestNewStream(t *testing.T) {
 	}
 	if err != nil {
 		t.Errorf("failed to build stream, %s", err)
-	} else if !reflect.DeepEqual(*stream, expected) {
-		t.Errorf("wrong stream is built,\ngot: %v,\nexpected: %v", *stream, expected)
+	} else if !reflect.DeepEqual(stream, &expected) {
+		t.Errorf("wrong stream is built,\ngot: %v,\nexpected: %v", stream, &expected)
 	}
 }
 
@@ -72,7 +76,7 @@ func TestDownload(t *testing.T) {
 	content := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
 	gomock.InOrder(
 		d.EXPECT().Decipher("hoge").Return("geho", nil),
-		c.EXPECT().Get("https://foobar?itag=22&signature=geho").Return(
+		c.EXPECT().Get(gomock.Any(), "https://foobar?itag=22&signature=geho").Return(
 			&http.Response{
 				StatusCode: 200,
 				Body:       ioutil.NopCloser(bytes.NewReader(content)),
@@ -115,7 +119,7 @@ func TestParallelDownload(t *testing.T) {
 	d.EXPECT().Decipher("hoge").Return("geho", nil)
 	header := make(http.Header)
 	header.Set("Content-Length", "17")
-	c.EXPECT().Head("https://foobar?itag=22&signature=geho").Return(
+	c.EXPECT().Head(gomock.Any(), "https://foobar?itag=22&signature=geho").Return(
 		&http.Response{
 			Header:     header,
 			StatusCode: 200,
@@ -124,7 +128,7 @@ func TestParallelDownload(t *testing.T) {
 	)
 	for d := 0; d < 8; {
 		requestURL := fmt.Sprintf("https://foobar?itag=22&signature=geho&range=%d-%d", 2*d, 2*d+1)
-		c.EXPECT().Get(requestURL).Return(
+		c.EXPECT().Get(gomock.Any(), requestURL).Return(
 			&http.Response{
 				StatusCode: 200,
 				Body:       ioutil.NopCloser(bytes.NewReader(content[2*d : 2*d+2])),
@@ -133,7 +137,7 @@ func TestParallelDownload(t *testing.T) {
 		)
 		d++
 	}
-	c.EXPECT().Get("https://foobar?itag=22&signature=geho&range=16-16").Return(
+	c.EXPECT().Get(gomock.Any(), "https://foobar?itag=22&signature=geho&range=16-16").Return(
 		&http.Response{
 			StatusCode: 200,
 			Body:       ioutil.NopCloser(bytes.NewReader(content[16:17])),
@@ -149,6 +153,122 @@ func TestParallelDownload(t *testing.T) {
 		t.Errorf("got stream data %v, expected %v", data, content)
 	}
 }
+
+func TestParallelDownloadTo(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	c := mock.NewMockclient(ctrl)
+	d := mock.NewMockdecipherer(ctrl)
+
+	stream := Stream{
+		signature:  "hoge",
+		url:        "https://foobar?itag=22",
+		Duration:   time.Second * time.Duration(20*7.5),
+		client:     c,
+		decipherer: d,
+	}
+
+	content := []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10,
+	}
+	d.EXPECT().Decipher("hoge").Return("geho", nil)
+	header := make(http.Header)
+	header.Set("Content-Length", "17")
+	c.EXPECT().Head(gomock.Any(), "https://foobar?itag=22&signature=geho").Return(
+		&http.Response{
+			Header:     header,
+			StatusCode: 200,
+		},
+		nil,
+	)
+	for d := 0; d < 8; d++ {
+		requestURL := fmt.Sprintf("https://foobar?itag=22&signature=geho&range=%d-%d", 2*d, 2*d+1)
+		c.EXPECT().Get(gomock.Any(), requestURL).Return(
+			&http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewReader(content[2*d : 2*d+2])),
+			},
+			nil,
+		)
+	}
+	c.EXPECT().Get(gomock.Any(), "https://foobar?itag=22&signature=geho&range=16-16").Return(
+		&http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader(content[16:17])),
+		},
+		nil,
+	)
+
+	// only 3 bytes, i.e. one 2 bytes chunk, can be buffered at once
+	buf := new(bytes.Buffer)
+	if err := stream.ParallelDownloadTo(buf, 3); err != nil {
+		t.Fatalf("stream donwload failed, %s", err)
+	}
+	if bytes.Compare(buf.Bytes(), content) != 0 {
+		t.Errorf("got stream data %v, expected %v", buf.Bytes(), content)
+	}
+}
+
+func TestParallelDownloadToDefaultBuffer(t *testing.T) {
+	content := []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10,
+	}
+	var mu sync.Mutex
+	var requested []int
+	stream := Stream{
+		url:      "https://foobar?itag=22&signature=geho",
+		Duration: time.Second * time.Duration(20*7.5),
+		Retry:    &NoRetry,
+		client:   rangeServingClient(content, nil, &requested, &mu),
+	}
+	// 0 is not a budget of no bytes but the default one
+	buf := new(bytes.Buffer)
+	if err := stream.ParallelDownloadTo(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("got %v, expected %v", buf.Bytes(), content)
+	}
+}
+
+func TestByteBudget(t *testing.T) {
+	b := newByteBudget(4)
+	if !b.acquire(3) {
+		t.Fatal("acquire within the limit failed")
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- b.acquire(2)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquire exceeding the limit should block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.release(3)
+	if !<-acquired {
+		t.Error("acquire should succeed after release")
+	}
+
+	// a request larger than the limit is accepted when nothing else is reserved
+	b.release(2)
+	if !b.acquire(10) {
+		t.Error("acquire larger than the limit should succeed when the budget is empty")
+	}
+
+	go func() {
+		acquired <- b.acquire(1)
+	}()
+	b.abort()
+	if <-acquired {
+		t.Error("acquire should fail after abort")
+	}
+}
+
 func TestSequentialChunkDownload(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -174,7 +294,7 @@ func TestSequentialChunkDownload(t *testing.T) {
 	d.EXPECT().Decipher("hoge").Return("geho", nil)
 	header := make(http.Header)
 	header.Set("Content-Length", "17")
-	exploreCall := c.EXPECT().Head("https://foobar?itag=22&signature=geho").Return(
+	exploreCall := c.EXPECT().Head(gomock.Any(), "https://foobar?itag=22&signature=geho").Return(
 		&http.Response{
 			Header:     header,
 			StatusCode: 200,
@@ -184,7 +304,7 @@ func TestSequentialChunkDownload(t *testing.T) {
 
 	for d := 0; d < 8; {
 		requestURL := fmt.Sprintf("https://foobar?itag=22&signature=geho&range=%d-%d", 2*d, 2*d+1)
-		c.EXPECT().Get(requestURL).Return(
+		c.EXPECT().Get(gomock.Any(), requestURL).Return(
 			&http.Response{
 				StatusCode: 200,
 				Body:       ioutil.NopCloser(bytes.NewReader(content[2*d : 2*d+2])),
@@ -193,7 +313,7 @@ func TestSequentialChunkDownload(t *testing.T) {
 		).After(exploreCall)
 		d++
 	}
-	c.EXPECT().Get("https://foobar?itag=22&signature=geho&range=16-16").Return(
+	c.EXPECT().Get(gomock.Any(), "https://foobar?itag=22&signature=geho&range=16-16").Return(
 		&http.Response{
 			StatusCode: 200,
 			Body:       ioutil.NopCloser(bytes.NewReader(content[16:17])),
@@ -202,10 +322,11 @@ func TestSequentialChunkDownload(t *testing.T) {
 	).After(exploreCall)
 
 	log.Printf("start chunk download\n")
-	dataChan, errDownload := stream.SequentialChunkDownload(20 * time.Second)
+	reader, errDownload := stream.SequentialChunkDownload(20 * time.Second)
 	if errDownload != nil {
 		t.Fatalf("stream donwload failed, %s", errDownload)
 	}
+	defer reader.Close()
 
 	expectedDataChunks := [][]byte{
 		[]byte{0x00, 0x01},
@@ -219,14 +340,177 @@ func TestSequentialChunkDownload(t *testing.T) {
 		[]byte{0x10},
 	}
 	i := 0
-	for d := range dataChan {
+	for reader.Next() {
+		d := reader.Chunk()
 		log.Printf("data arrived %v\n", d)
 		if bytes.Compare(d, expectedDataChunks[i]) != 0 {
 			t.Errorf("got stream chunk data %v, expected %v", d, expectedDataChunks[i])
 		}
+		if start, end := reader.Range(); start != 2*i || end != 2*i+len(expectedDataChunks[i]) {
+			t.Errorf("got range %d-%d for chunk %d", start, end, i)
+		}
 		i++
 	}
+	if err := reader.Err(); err != nil {
+		t.Errorf("unexpected error, %s", err)
+	}
+	if i != len(expectedDataChunks) {
+		t.Errorf("got %d chunks, expected %d", i, len(expectedDataChunks))
+	}
+
+}
+
+func TestSequentialChunkDownloadCancel(t *testing.T) {
+	header := make(http.Header)
+	header.Set("Content-Length", "17")
+	requested := make(chan struct{}, 9)
+	c := &fakeClient{
+		fakeHead: func(ctx context.Context, url string) (*http.Response, error) {
+			return &http.Response{StatusCode: 200, Header: header}, nil
+		},
+		fakeGet: func(ctx context.Context, url string) (*http.Response, error) {
+			// block until the request is canceled
+			requested <- struct{}{}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	stream := Stream{
+		url:      "https://foobar?itag=22&signature=geho",
+		Duration: time.Second * time.Duration(20*7.5),
+		client:   c,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader, errDownload := stream.SequentialChunkDownloadContext(ctx, 20*time.Second)
+	if errDownload != nil {
+		t.Fatalf("stream donwload failed, %s", errDownload)
+	}
+	<-requested
+	cancel()
+
+	done := make(chan bool)
+	go func() {
+		done <- reader.Next()
+	}()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("no chunk should be received after cancellation")
+		}
+		if reader.Err() != context.Canceled {
+			t.Errorf("got error %v, expected %v", reader.Err(), context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Error("reader is not stopped after cancellation")
+	}
+}
+
+func TestSequentialChunkDownloadFailure(t *testing.T) {
+	content := []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10,
+	}
+	var mu sync.Mutex
+	var requested []int
+	stream := Stream{
+		url:      "https://foobar?itag=22&signature=geho",
+		Duration: time.Second * time.Duration(20*7.5),
+		Retry:    &NoRetry,
+		client:   rangeServingClient(content, map[int]bool{4: true}, &requested, &mu),
+	}
+
+	reader, errDownload := stream.SequentialChunkDownload(20 * time.Second)
+	if errDownload != nil {
+		t.Fatalf("stream donwload failed, %s", errDownload)
+	}
+	defer reader.Close()
+
+	received := 0
+	for reader.Next() {
+		received++
+	}
+	if received != 2 {
+		t.Errorf("got %d chunks before the failed one, expected 2", received)
+	}
+	rangeErr, ok := reader.Err().(*RangeError)
+	if !ok {
+		t.Fatalf("got error %v, expected *RangeError", reader.Err())
+	}
+	if rangeErr.Start != 4 || rangeErr.End != 6 {
+		t.Errorf("got failed range %d-%d, expected 4-6", rangeErr.Start, rangeErr.End)
+	}
+	if reader.Next() {
+		t.Error("Next should keep returning false after a failure")
+	}
+}
+
+func TestSequentialChunkDownloadFailureCancelsFollowing(t *testing.T) {
+	header := make(http.Header)
+	header.Set("Content-Length", "17")
+	canceled := make(chan struct{}, 9)
+	c := &fakeClient{
+		fakeHead: func(ctx context.Context, url string) (*http.Response, error) {
+			return &http.Response{StatusCode: 200, Header: header}, nil
+		},
+		fakeGet: func(ctx context.Context, url string) (*http.Response, error) {
+			if strings.Contains(url, "&range=0-") {
+				return nil, errors.New("connection reset by peer")
+			}
+			// the following chunks wait until they are canceled
+			<-ctx.Done()
+			canceled <- struct{}{}
+			return nil, ctx.Err()
+		},
+	}
+	stream := Stream{
+		url:      "https://foobar?itag=22&signature=geho",
+		Duration: time.Second * time.Duration(20*7.5),
+		Retry:    &NoRetry,
+		client:   c,
+	}
+
+	reader, errDownload := stream.SequentialChunkDownload(20 * time.Second)
+	if errDownload != nil {
+		t.Fatalf("stream donwload failed, %s", errDownload)
+	}
+	defer reader.Close()
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("a following chunk is not canceled after the failure")
+	}
+	if reader.Next() {
+		t.Error("a chunk is received after the failure")
+	}
+	if rangeErr, ok := reader.Err().(*RangeError); !ok || rangeErr.Start != 0 {
+		t.Errorf("got error %v, expected *RangeError from 0", reader.Err())
+	}
+}
+
+func TestParallelDownloadToCancel(t *testing.T) {
+	header := make(http.Header)
+	header.Set("Content-Length", "17")
+	c := &fakeClient{
+		fakeHead: func(ctx context.Context, url string) (*http.Response, error) {
+			return &http.Response{StatusCode: 200, Header: header}, nil
+		},
+		fakeGet: func(ctx context.Context, url string) (*http.Response, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	stream := Stream{
+		url:      "https://foobar?itag=22&signature=geho",
+		Duration: time.Second * time.Duration(20*7.5),
+		client:   c,
+	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := stream.ParallelDownloadToContext(ctx, new(bytes.Buffer), 100); err != context.DeadlineExceeded {
+		t.Errorf("got error %v, expected %v", err, context.DeadlineExceeded)
+	}
 }
 
 func TestGetSize(t *testing.T) {
@@ -247,7 +531,7 @@ func TestGetSize(t *testing.T) {
 
 	gomock.InOrder(
 		d.EXPECT().Decipher("hoge").Return("geho", nil),
-		c.EXPECT().Head("https://foobar?itag=22&signature=geho").Return(
+		c.EXPECT().Head(gomock.Any(), "https://foobar?itag=22&signature=geho").Return(
 			&http.Response{
 				StatusCode: 200,
 				Header:     header,
@@ -264,6 +548,43 @@ func TestGetSize(t *testing.T) {
 	}
 }
 
+func TestStreamContentLength(t *testing.T) {
+	cases := []struct {
+		info     map[string]string
+		expected int
+	}{
+		{map[string]string{"url": "https://foobar?itag=140&clen=3433514"}, 3433514},
+		{map[string]string{"url": "https://foobar?itag=140", "clen": "2839609"}, 2839609},
+		{map[string]string{"url": "https://foobar?itag=140", "contentLength": "7859105"}, 7859105},
+		{map[string]string{"url": "https://foobar?itag=140&clen=invalid"}, 0},
+		{map[string]string{"url": "https://foobar?itag=140"}, 0},
+	}
+	for _, c := range cases {
+		stream, err := newStream(c.info, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to build stream, %s", err)
+		}
+		if stream.ContentLength != c.expected {
+			t.Errorf("got content length %d from %v, expected %d", stream.ContentLength, c.info, c.expected)
+		}
+	}
+
+	// a known length is returned without a head request
+	stream := Stream{
+		url:           "https://foobar?itag=140&signature=geho",
+		ContentLength: 3433514,
+		client: &fakeClient{
+			fakeHead: func(ctx context.Context, url string) (*http.Response, error) {
+				t.Errorf("unexpected head request to %s", url)
+				return nil, errors.New("unexpected request")
+			},
+		},
+	}
+	if size, err := stream.GetSize(); err != nil || size != 3433514 {
+		t.Errorf("got size %d, %v, expected 3433514", size, err)
+	}
+}
+
 func TestBuildDownloadURL(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
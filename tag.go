@@ -0,0 +1,100 @@
+package gotube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	thumbnailURLFormat = "https://i.ytimg.com/vi/%s/hqdefault.jpg"
+)
+
+// Metadata is information of a video written into saved files as tags by Tag
+type Metadata struct {
+	Title       string
+	Author      string // name of the channel
+	UploadDate  string // in YYYY-MM-DD
+	Description string
+	URL         string // of the watch page
+	Thumbnail   []byte // cover art in JPEG, nil if not fetched
+	videoID     string
+}
+
+// Metadata returns metadata of the video without the thumbnail.
+// FetchStreams must be called beforehand.
+func (dl *YoutubeDownloader) Metadata() (*Metadata, error) {
+	if dl.metadata == nil {
+		return nil, errors.New("streams are not fetched yet")
+	}
+	m := *dl.metadata
+	return &m, nil
+}
+
+// MetadataWithThumbnail returns metadata of the video with the thumbnail fetched.
+// A failure of fetching the thumbnail is not an error, Thumbnail is nil then.
+func (dl *YoutubeDownloader) MetadataWithThumbnail() (*Metadata, error) {
+	return dl.MetadataWithThumbnailContext(context.Background())
+}
+
+// MetadataWithThumbnailContext is MetadataWithThumbnail with a context.
+// The request for the thumbnail is canceled when ctx is done.
+func (dl *YoutubeDownloader) MetadataWithThumbnailContext(ctx context.Context) (*Metadata, error) {
+	m, err := dl.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	thumbnail, err := dl.getResource(ctx, fmt.Sprintf(thumbnailURLFormat, m.videoID))
+	if err != nil {
+		logger.printf("failed to fetch the thumbnail, %s", err)
+		return m, nil
+	}
+	m.Thumbnail = thumbnail
+	return m, nil
+}
+
+// Tag writes metadata as tags into a saved file.
+// MP4 (mp4, m4a) gets iTunes style atoms in moov/udta/meta, and Matroska (webm, mkv) gets Tags and the cover art attached.
+// The format is detected from the content.
+func Tag(path string, m *Metadata) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return fmt.Errorf("failed to read %s, %s", path, unexpectedEOF(err))
+	}
+	switch {
+	case bytes.Equal(head[:4], ebmlIDBytes(ebmlIDHeader)):
+		err = tagMatroska(f, m)
+	case string(head[4:8]) == "ftyp":
+		err = tagMP4(f, m)
+	default:
+		return fmt.Errorf("tagging %s is not supported", path)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// isPNG tells whether an image is in PNG, otherwise it is assumed to be in JPEG
+func isPNG(image []byte) bool {
+	return bytes.HasPrefix(image, []byte("\x89PNG"))
+}
+
+// unescapeJSON decodes escape sequences of a string in JSON, or returns it as it is if invalid
+func unescapeJSON(s string) string {
+	var unescaped string
+	if err := json.Unmarshal([]byte(`"`+s+`"`), &unescaped); err != nil {
+		return s
+	}
+	return unescaped
+}
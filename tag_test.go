@@ -0,0 +1,309 @@
+package gotube
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testMetadata = &Metadata{
+	Title:       "Moves Like Jagger",
+	Author:      "Maroon5VEVO",
+	UploadDate:  "2011-08-09",
+	Description: "UK release: Sept 5th",
+	URL:         "https://www.youtube.com/watch?v=iEPTlhBmwRg",
+	Thumbnail:   []byte("\xff\xd8\xff\xe0cover"),
+}
+
+// tagFile writes data into a temporary file, tags it and returns the tagged content
+func tagFile(t *testing.T, data []byte, m *Metadata) []byte {
+	f, err := ioutil.TempFile("", "gotube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Tag(f.Name(), m); err != nil {
+		t.Fatalf("tagging failed, %s", err)
+	}
+	tagged, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tagged
+}
+
+// itunesValue returns a data type and a value of an iTunes style item in moov, or nil if not found
+func itunesValue(t *testing.T, data []byte, typ string) (uint32, []byte) {
+	meta := findMP4Box(data, "moov", "udta", "meta")
+	if meta == nil {
+		t.Fatal("no meta box in the output")
+	}
+	item := findMP4Box(meta.payload[4:], "ilst", typ, "data")
+	if item == nil {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint32(item.payload[0:4]), item.payload[8:]
+}
+
+func TestTagMP4(t *testing.T) {
+	audio := testFMP4(1, 1000, 44100, 0, "soun", []testFragment{
+		{0, []byte("audio0")},
+		{1024, []byte("audio1")},
+	})
+	expected := [][]byte{[]byte("audio0"), []byte("audio1")}
+	buf := new(bytes.Buffer)
+	if err := writeM4A(buf, bytes.NewReader(audio)); err != nil {
+		t.Fatal(err)
+	}
+
+	// moov at the end of an m4a is replaced in place
+	out := tagFile(t, buf.Bytes(), testMetadata)
+	if samples := m4aSamples(t, out); !reflect.DeepEqual(samples, expected) {
+		t.Errorf("got samples %q, expected %q", samples, expected)
+	}
+	for typ, value := range map[string]string{
+		"\xa9nam": testMetadata.Title,
+		"\xa9ART": testMetadata.Author,
+		"\xa9day": testMetadata.UploadDate,
+		"desc":    testMetadata.Description,
+		"\xa9cmt": testMetadata.URL,
+	} {
+		if dataType, v := itunesValue(t, out, typ); dataType != itunesUTF8 || string(v) != value {
+			t.Errorf("got %s of type %d %q, expected %q", typ, dataType, v, value)
+		}
+	}
+	if dataType, v := itunesValue(t, out, "covr"); dataType != itunesJPEG || !bytes.Equal(v, testMetadata.Thumbnail) {
+		t.Errorf("got cover art of type %d %q", dataType, v)
+	}
+
+	// tagging again replaces old items
+	out = tagFile(t, out, &Metadata{Title: "retitled"})
+	if _, v := itunesValue(t, out, "\xa9nam"); string(v) != "retitled" {
+		t.Errorf("got title %q, expected retitled", v)
+	}
+	if _, v := itunesValue(t, out, "covr"); v != nil {
+		t.Error("old cover art is left")
+	}
+	if boxes, _ := parseMP4Boxes(out); len(boxes) != 3 {
+		t.Errorf("got %d boxes, expected ftyp, mdat and moov", len(boxes))
+	}
+}
+
+func TestTagMP4BeforeMediaData(t *testing.T) {
+	// fragments of the audio have absolute base data offsets
+	audio := testFMP4(1, 1000, 44100, 0, "soun", []testFragment{
+		{0, []byte("audio0")},
+		{1024, []byte("audio1")},
+	})
+	_, expected := samplesOf(t, audio)
+
+	// moov grows and media data after it is shifted
+	out := tagFile(t, audio, testMetadata)
+	if len(out) <= len(audio) {
+		t.Fatalf("got %d bytes, expected more than %d", len(out), len(audio))
+	}
+	if _, samples := samplesOf(t, out); !reflect.DeepEqual(samples, expected) {
+		t.Errorf("got samples %q, expected %q", samples, expected)
+	}
+	if _, v := itunesValue(t, out, "\xa9nam"); string(v) != testMetadata.Title {
+		t.Errorf("got title %q, expected %q", v, testMetadata.Title)
+	}
+
+	// moov shrinks and the rest is filled with free
+	shrunk := tagFile(t, out, &Metadata{Title: "retitled"})
+	if len(shrunk) != len(out) {
+		t.Errorf("got %d bytes, expected %d", len(shrunk), len(out))
+	}
+	boxes, _ := parseMP4Boxes(shrunk)
+	if len(boxes) < 3 || boxes[1].typ != "moov" || boxes[2].typ != "free" {
+		t.Error("no free box after moov")
+	}
+	if _, samples := samplesOf(t, shrunk); !reflect.DeepEqual(samples, expected) {
+		t.Errorf("got samples %q, expected %q", samples, expected)
+	}
+}
+
+// matroskaTargets returns children of the Segment pointed by entries of SeekHeads, following a SeekHead pointed by another
+func matroskaTargets(t *testing.T, data []byte) map[uint32]*ebmlElement {
+	wi, err := parseWebMInit(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := make(map[uint32]*ebmlElement)
+	var follow func(pos int)
+	follow = func(pos int) {
+		elements, err := parseEBMLElements(data[wi.segmentStart+pos:])
+		if err != nil && len(elements) == 0 {
+			t.Fatalf("invalid element at %d, %s", pos, err)
+		}
+		if elements[0].id != ebmlIDSeekHead {
+			t.Fatalf("got element %X, expected a SeekHead", elements[0].id)
+		}
+		entries, err := parseSeekHead(makeEBMLElement(ebmlIDSeekHead, elements[0].data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			target, _ := parseEBMLElements(data[wi.segmentStart+int(e.position):])
+			if len(target) == 0 || target[0].id != e.id {
+				t.Fatalf("entry of %X does not point the element", e.id)
+			}
+			targets[e.id] = target[0]
+			if e.id == ebmlIDSeekHead {
+				follow(int(e.position))
+			}
+		}
+	}
+	follow(0)
+	return targets
+}
+
+// matroskaTagValues returns names and values of SimpleTags in Tags
+func matroskaTagValues(t *testing.T, tags *ebmlElement) map[string]string {
+	values := make(map[string]string)
+	tag, err := parseEBMLElements(tags.data)
+	if err != nil || len(tag) != 1 {
+		t.Fatalf("invalid Tags, %v", err)
+	}
+	children, _ := parseEBMLElements(tag[0].data)
+	for _, c := range children {
+		if c.id != ebmlIDSimpleTag {
+			continue
+		}
+		fields, _ := parseEBMLElements(c.data)
+		values[string(fields[0].data)] = string(fields[1].data)
+	}
+	return values
+}
+
+func TestTagMatroska(t *testing.T) {
+	video := testWebM(1000000, 80, "V_VP9", false, []testCluster{{0, []int16{0, 40}, []string{"v0", "v1"}}})
+	audio := testWebM(1000000, 80, "A_OPUS", true, []testCluster{{0, []int16{0, 40}, []string{"a0", "a1"}}})
+	buf := new(bytes.Buffer)
+	if err := muxWebM(buf, bytes.NewReader(video), bytes.NewReader(audio)); err != nil {
+		t.Fatal(err)
+	}
+	_, expected := webmBlocksOf(t, buf.Bytes())
+
+	// the SeekHead written by the muxer has space for an entry of Tags
+	out := tagFile(t, buf.Bytes(), &Metadata{Title: "Moves Like Jagger"})
+	targets := matroskaTargets(t, out)
+	if _, ok := targets[ebmlIDSeekHead]; ok {
+		t.Error("a second SeekHead is written while the first one has space")
+	}
+	if targets[ebmlIDTags] == nil {
+		t.Fatal("no entry of Tags")
+	}
+	if values := matroskaTagValues(t, targets[ebmlIDTags]); !reflect.DeepEqual(values, map[string]string{"TITLE": "Moves Like Jagger"}) {
+		t.Errorf("got tags %v", values)
+	}
+	if _, blocks := webmBlocksOf(t, out); !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("got blocks %v, expected %v", blocks, expected)
+	}
+
+	// entries of Tags and Attachments go to a second SeekHead, and tagging twice leaves a single set of them
+	for i := 0; i < 2; i++ {
+		out = tagFile(t, out, testMetadata)
+		targets = matroskaTargets(t, out)
+		for _, id := range []uint32{ebmlIDInfo, ebmlIDTracks, ebmlIDSeekHead, ebmlIDTags, ebmlIDAttachments} {
+			if targets[id] == nil {
+				t.Errorf("no entry of %X", id)
+			}
+		}
+		if t.Failed() {
+			return
+		}
+		values := matroskaTagValues(t, targets[ebmlIDTags])
+		if values["TITLE"] != testMetadata.Title || values["ARTIST"] != testMetadata.Author ||
+			values["DATE_RELEASED"] != testMetadata.UploadDate || values["DESCRIPTION"] != testMetadata.Description ||
+			values["URL"] != testMetadata.URL {
+			t.Errorf("got tags %v", values)
+		}
+		if !bytes.Contains(targets[ebmlIDAttachments].data, testMetadata.Thumbnail) {
+			t.Error("no cover art in Attachments")
+		}
+
+		children, blocks := webmBlocksOf(t, out)
+		if !reflect.DeepEqual(blocks, expected) {
+			t.Errorf("got blocks %v, expected %v", blocks, expected)
+		}
+		counts := make(map[uint32]int)
+		for _, c := range children {
+			counts[c.id]++
+		}
+		if counts[ebmlIDTags] != 1 || counts[ebmlIDAttachments] != 1 || counts[ebmlIDSeekHead] != 2 {
+			t.Errorf("got %d Tags, %d Attachments and %d SeekHeads", counts[ebmlIDTags], counts[ebmlIDAttachments], counts[ebmlIDSeekHead])
+		}
+	}
+}
+
+func TestTagMatroskaSegmentSizeOverflow(t *testing.T) {
+	// the Segment has a size field of 1 byte, which cannot hold the size after tagging
+	content := append(makeEBMLElement(ebmlIDSeekHead, nil), ebmlVoid(80)...)
+	data := append(makeEBMLElement(ebmlIDHeader, makeEBMLElement(0x4286, []byte{1})), ebmlIDBytes(ebmlIDSegment)...)
+	data = append(append(data, byte(0x80|len(content))), content...)
+
+	f, err := ioutil.TempFile("", "gotube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(data)
+	f.Close()
+	if err := Tag(f.Name(), testMetadata); err == nil || !strings.Contains(err.Error(), "does not fit") {
+		t.Errorf("got %v, expected the size not to fit", err)
+	}
+	if out, _ := ioutil.ReadFile(f.Name()); !bytes.Equal(out, data) {
+		t.Error("the file is changed by failed tagging")
+	}
+}
+
+func TestTagUnsupported(t *testing.T) {
+	f, err := ioutil.TempFile("", "gotube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("OggS\x00\x02 not a supported file")
+	f.Close()
+	if err := Tag(f.Name(), testMetadata); err == nil {
+		t.Error("tagging an ogg file succeeded")
+	}
+}
+
+func TestMetadataWithThumbnail(t *testing.T) {
+	thumbnail := []byte("\xFF\xD8\xFF\xE0 jpeg")
+	c := &fakeClient{
+		fakeGet: func(ctx context.Context, u string) (*http.Response, error) {
+			if u != "https://i.ytimg.com/vi/iEPTlhBmwRg/hqdefault.jpg" {
+				return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(thumbnail))}, nil
+		},
+	}
+	dl := &YoutubeDownloader{client: c, Retry: &NoRetry, metadata: &Metadata{Title: "Moves Like Jagger", videoID: "iEPTlhBmwRg"}}
+	if m, err := dl.Metadata(); err != nil || m.Thumbnail != nil {
+		t.Errorf("got %v, %v without the thumbnail", m, err)
+	}
+	m, err := dl.MetadataWithThumbnail()
+	if err != nil || !bytes.Equal(m.Thumbnail, thumbnail) || m.Title != "Moves Like Jagger" {
+		t.Errorf("got %v, %v with the thumbnail", m, err)
+	}
+
+	// the thumbnail is left nil if it is not fetched
+	dl.metadata.videoID = "SlPhMPnQ58k"
+	if m, err := dl.MetadataWithThumbnail(); err != nil || m.Thumbnail != nil {
+		t.Errorf("got %v, %v for a missing thumbnail", m, err)
+	}
+}
@@ -22,6 +22,9 @@ const (
 	mockAgeRestrictedEmbedPagePath = "./mocks/data/age_restricted_embed_page.html.zip"
 	mockAgeRestrictedVideoInfoPath = "./mocks/data/age_restricted_video_info.txt.zip"
 	mockAgeRestrictedScriptPath    = "./mocks/data/age_restricted_script.js.zip"
+	mockChannelVideosPagePath      = "./mocks/data/channel_videos_page.html.zip"
+	mockChannelContinuationPath    = "./mocks/data/channel_videos_continuation.json.zip"
+	mockChannelShortsPagePath      = "./mocks/data/channel_shorts_page.html.zip"
 )
 
 var (
@@ -0,0 +1,75 @@
+package gotube
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	videoIDPattern = regexp.MustCompile(`^[\w-]{11}$`)
+	schemePattern  = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+)
+
+// youtubeHosts are hosts of watch pages and players, which put a video id in the v parameter or the path
+var youtubeHosts = map[string]bool{
+	"youtube.com":              true,
+	"www.youtube.com":          true,
+	"m.youtube.com":            true,
+	"music.youtube.com":        true,
+	"youtube-nocookie.com":     true,
+	"www.youtube-nocookie.com": true,
+}
+
+// videoIDPathPrefixes are paths followed by a video id
+var videoIDPathPrefixes = []string{"/embed/", "/shorts/", "/v/", "/live/"}
+
+// ParseVideoID extracts a video id from a url of a video or a bare 11 characters id.
+// Watch urls of www, m and music.youtube.com, youtu.be/ID, /embed/ID, /shorts/ID, /v/ID and /live/ID are accepted,
+// with or without the scheme.
+func ParseVideoID(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if videoIDPattern.MatchString(s) {
+		return s, nil
+	}
+	raw := s
+	if !schemePattern.MatchString(raw) {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("unexpected URL format %s", s)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unexpected URL format %s", s)
+	}
+
+	var id string
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "youtu.be" || host == "www.youtu.be":
+		id = strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)[0]
+	case youtubeHosts[host]:
+		if u.Path == "/watch" {
+			id = u.Query().Get("v")
+			break
+		}
+		for _, prefix := range videoIDPathPrefixes {
+			if strings.HasPrefix(u.Path, prefix) {
+				id = strings.SplitN(strings.TrimPrefix(u.Path, prefix), "/", 2)[0]
+			}
+		}
+	default:
+		return "", fmt.Errorf("%s is not a YouTube URL", s)
+	}
+	if !videoIDPattern.MatchString(id) {
+		return "", fmt.Errorf("no video id found in %s", s)
+	}
+	return id, nil
+}
+
+// CanonicalWatchURL returns the url of the watch page of a video
+func CanonicalWatchURL(videoID string) string {
+	return fmt.Sprintf("https://www.youtube.com/watch?v=%s", videoID)
+}
@@ -0,0 +1,61 @@
+package gotube
+
+import "testing"
+
+func TestParseVideoID(t *testing.T) {
+	const id = "iEPTlhBmwRg"
+	valid := []string{
+		"iEPTlhBmwRg",
+		"https://www.youtube.com/watch?v=iEPTlhBmwRg",
+		"http://www.youtube.com/watch?v=iEPTlhBmwRg",
+		"https://www.youtube.com/watch?feature=share&v=iEPTlhBmwRg&t=90",
+		"https://youtube.com/watch?v=iEPTlhBmwRg",
+		"https://m.youtube.com/watch?v=iEPTlhBmwRg",
+		"https://music.youtube.com/watch?v=iEPTlhBmwRg&list=RDAMVMiEPTlhBmwRg",
+		"https://WWW.YouTube.com/watch?v=iEPTlhBmwRg",
+		"www.youtube.com/watch?v=iEPTlhBmwRg",
+		"youtube.com/watch?v=iEPTlhBmwRg",
+		"https://youtu.be/iEPTlhBmwRg",
+		"https://youtu.be/iEPTlhBmwRg?t=42",
+		"youtu.be/iEPTlhBmwRg",
+		"https://www.youtube.com/embed/iEPTlhBmwRg",
+		"https://www.youtube.com/embed/iEPTlhBmwRg?autoplay=1",
+		"https://www.youtube-nocookie.com/embed/iEPTlhBmwRg",
+		"https://www.youtube.com/shorts/iEPTlhBmwRg",
+		"https://youtube.com/shorts/iEPTlhBmwRg?feature=share",
+		"https://www.youtube.com/v/iEPTlhBmwRg",
+		"https://www.youtube.com/live/iEPTlhBmwRg",
+		"https://m.youtube.com/live/iEPTlhBmwRg?si=abc",
+		"  https://www.youtube.com/watch?v=iEPTlhBmwRg\n",
+	}
+	for _, s := range valid {
+		if parsed, err := ParseVideoID(s); err != nil || parsed != id {
+			t.Errorf("got %q and %v for %q, expected %s", parsed, err, s, id)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"iEPTlhBmwR",
+		"iEPTlhBmwRg0",
+		"https://www.mytube.com/watch?v=iEPTlhBmwRg",
+		"https://www.youtube.com.example.com/watch?v=iEPTlhBmwRg",
+		"https://www.youtube.com/watch",
+		"https://www.youtube.com/watch?v=short",
+		"https://www.youtube.com/channel/UCN1hnUccO4FD5WfM7ithXaw",
+		"https://www.youtube.com/playlist?list=PLx0sYbCqOb8TBPRdmBHs5Iftvv9TPboYG",
+		"https://youtu.be/",
+		"ftp://www.youtube.com/watch?v=iEPTlhBmwRg",
+	}
+	for _, s := range invalid {
+		if parsed, err := ParseVideoID(s); err == nil {
+			t.Errorf("got %q for %q, expected an error", parsed, s)
+		}
+	}
+}
+
+func TestCanonicalWatchURL(t *testing.T) {
+	if u := CanonicalWatchURL("iEPTlhBmwRg"); u != validURL {
+		t.Errorf("got %s, expected %s", u, validURL)
+	}
+}
@@ -0,0 +1,398 @@
+package gotube
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// EBML element ids used in WebM
+const (
+	ebmlIDHeader             = 0x1A45DFA3
+	ebmlIDSegment            = 0x18538067
+	ebmlIDInfo               = 0x1549A966
+	ebmlIDTimecodeScale      = 0x2AD7B1
+	ebmlIDDuration           = 0x4489
+	ebmlIDCues               = 0x1C53BB6B
+	ebmlIDCuePoint           = 0xBB
+	ebmlIDCueTime            = 0xB3
+	ebmlIDCueTrackPositions  = 0xB7
+	ebmlIDCueTrack           = 0xF7
+	ebmlIDCueClusterPosition = 0xF1
+	ebmlIDSeekHead           = 0x114D9B74
+	ebmlIDSeek               = 0x4DBB
+	ebmlIDSeekID             = 0x53AB
+	ebmlIDSeekPosition       = 0x53AC
+	ebmlIDVoid               = 0xEC
+	ebmlIDMuxingApp          = 0x4D80
+	ebmlIDWritingApp         = 0x5741
+	ebmlIDTracks             = 0x1654AE6B
+	ebmlIDTrackEntry         = 0xAE
+	ebmlIDTrackNumber        = 0xD7
+	ebmlIDTrackUID           = 0x73C5
+	ebmlIDCodecID            = 0x86
+	ebmlIDCodecPrivate       = 0x63A2
+	ebmlIDCluster            = 0x1F43B675
+	ebmlIDTimecode           = 0xE7
+	ebmlIDSimpleBlock        = 0xA3
+	ebmlIDBlockGroup         = 0xA0
+	ebmlIDBlock              = 0xA1
+	ebmlIDBlockDuration      = 0x9B
+	ebmlIDDiscardPadding     = 0x75A2
+	ebmlIDTags               = 0x1254C367
+	ebmlIDTag                = 0x7373
+	ebmlIDTargets            = 0x63C0
+	ebmlIDTargetTypeValue    = 0x68CA
+	ebmlIDSimpleTag          = 0x67C8
+	ebmlIDTagName            = 0x45A3
+	ebmlIDTagString          = 0x4487
+	ebmlIDAttachments        = 0x1941A469
+	ebmlIDAttachedFile       = 0x61A7
+	ebmlIDFileName           = 0x466E
+	ebmlIDFileMimeType       = 0x4660
+	ebmlIDFileData           = 0x465C
+	ebmlIDFileUID            = 0x46AE
+)
+
+const (
+	defaultTimecodeScale = 1000000 // nanoseconds per timecode unit
+	ebmlUnknownSize      = -1
+)
+
+// ebmlElement is an element of EBML (WebM, Matroska).
+type ebmlElement struct {
+	id   uint32
+	data []byte
+}
+
+// readEBMLHeader reads an id and a data size of an element at the beginning of data.
+// size is ebmlUnknownSize if the element has an unknown size.
+func readEBMLHeader(data []byte) (id uint32, size int64, headerSize int, err error) {
+	if len(data) == 0 {
+		return 0, 0, 0, errors.New("truncated element id")
+	}
+	idLength := vintLength(data[0])
+	if idLength == 0 || idLength > 4 || len(data) < idLength {
+		return 0, 0, 0, errors.New("invalid element id")
+	}
+	for _, b := range data[:idLength] {
+		id = id<<8 | uint32(b)
+	}
+
+	rest := data[idLength:]
+	if len(rest) == 0 {
+		return 0, 0, 0, errors.New("truncated element size")
+	}
+	sizeLength := vintLength(rest[0])
+	if sizeLength == 0 || len(rest) < sizeLength {
+		return 0, 0, 0, errors.New("invalid element size")
+	}
+	value := uint64(rest[0]) & (0xFF >> uint(sizeLength))
+	allOnes := value == 0xFF>>uint(sizeLength)
+	for _, b := range rest[1:sizeLength] {
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	if allOnes {
+		return id, ebmlUnknownSize, idLength + sizeLength, nil
+	}
+	return id, int64(value), idLength + sizeLength, nil
+}
+
+// vintLength returns a length of a variable size integer from its first byte, 0 if invalid
+func vintLength(first byte) int {
+	for i := 0; i < 8; i++ {
+		if first&(0x80>>uint(i)) != 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// parseEBMLElements splits data into elements
+func parseEBMLElements(data []byte) ([]*ebmlElement, error) {
+	elements := []*ebmlElement{}
+	for len(data) > 0 {
+		id, size, headerSize, err := readEBMLHeader(data)
+		if err != nil {
+			return nil, err
+		}
+		if size == ebmlUnknownSize {
+			size = int64(len(data) - headerSize)
+		}
+		if size > int64(len(data)-headerSize) {
+			return nil, fmt.Errorf("element %X exceeds the data", id)
+		}
+		elements = append(elements, &ebmlElement{id: id, data: data[headerSize : headerSize+int(size)]})
+		data = data[headerSize+int(size):]
+	}
+	return elements, nil
+}
+
+// ebmlUint decodes data of an unsigned integer element
+func ebmlUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// ebmlInt decodes data of a signed integer element
+func ebmlInt(data []byte) int64 {
+	if len(data) == 0 {
+		return 0
+	}
+	v := int64(int8(data[0]))
+	for _, b := range data[1:] {
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+// ebmlFloat decodes data of a float element
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+// ebmlIDBytes encodes an element id, which keeps its length marker
+func ebmlIDBytes(id uint32) []byte {
+	switch {
+	case id > 0xFFFFFF:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFFFF:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFF:
+		return []byte{byte(id >> 8), byte(id)}
+	}
+	return []byte{byte(id)}
+}
+
+// ebmlSizeBytes encodes a data size in the shortest variable size integer
+func ebmlSizeBytes(size int) []byte {
+	length := 1
+	for length < 8 && uint64(size) >= 1<<uint(7*length)-1 {
+		length++
+	}
+	return ebmlFixedSizeBytes(size, length)
+}
+
+// ebmlFixedSizeBytes encodes a data size in a variable size integer of a given length
+func ebmlFixedSizeBytes(size int, length int) []byte {
+	data := make([]byte, length)
+	v := uint64(size)
+	for i := length - 1; i >= 0; i-- {
+		data[i] = byte(v)
+		v >>= 8
+	}
+	data[0] |= 0x80 >> uint(length-1)
+	return data
+}
+
+// makeEBMLElement encodes an element whose data is concatenation of payloads
+func makeEBMLElement(id uint32, payloads ...[]byte) []byte {
+	size := 0
+	for _, p := range payloads {
+		size += len(p)
+	}
+	data := append(ebmlIDBytes(id), ebmlSizeBytes(size)...)
+	for _, p := range payloads {
+		data = append(data, p...)
+	}
+	return data
+}
+
+// ebmlUintBytes encodes an unsigned integer in the shortest bytes
+func ebmlUintBytes(v uint64) []byte {
+	data := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		data = append([]byte{byte(v)}, data...)
+	}
+	return data
+}
+
+// ebmlFloatBytes encodes a float in 8 bytes
+func ebmlFloatBytes(f float64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, math.Float64bits(f))
+	return data
+}
+
+// ebmlVoid returns a Void element whose whole length is a given length, which must be 2 or more
+func ebmlVoid(length int) []byte {
+	if length-2 < 0x7F {
+		return append([]byte{ebmlIDVoid}, append(ebmlFixedSizeBytes(length-2, 1), make([]byte, length-2)...)...)
+	}
+	return append([]byte{ebmlIDVoid}, append(ebmlFixedSizeBytes(length-9, 8), make([]byte, length-9)...)...)
+}
+
+// webmInit is information read from an init range of a WebM stream
+type webmInit struct {
+	segmentStart  int    // offset of data of the Segment element, positions in Cues are relative to it
+	timecodeScale uint64 // nanoseconds per timecode unit
+	duration      float64
+}
+
+// parseWebMInit reads the EBML header, the Segment header and Info of a stream.
+// data is the beginning of the stream and can end in the middle of the Segment.
+func parseWebMInit(data []byte) (*webmInit, error) {
+	id, size, headerSize, err := readEBMLHeader(data)
+	if err != nil || id != ebmlIDHeader || size == ebmlUnknownSize {
+		return nil, errors.New("no EBML header found")
+	}
+	offset := headerSize + int(size)
+	if offset > len(data) {
+		return nil, errors.New("truncated EBML header")
+	}
+
+	id, _, headerSize, err = readEBMLHeader(data[offset:])
+	if err != nil || id != ebmlIDSegment {
+		return nil, errors.New("no Segment found")
+	}
+	wi := &webmInit{segmentStart: offset + headerSize, timecodeScale: defaultTimecodeScale}
+
+	// look for Info among children of the Segment contained in data
+	for offset = wi.segmentStart; offset < len(data); {
+		id, size, headerSize, err := readEBMLHeader(data[offset:])
+		if err != nil || size == ebmlUnknownSize || offset+headerSize+int(size) > len(data) {
+			break
+		}
+		if id == ebmlIDInfo {
+			children, err := parseEBMLElements(data[offset+headerSize : offset+headerSize+int(size)])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Info, %s", err)
+			}
+			for _, c := range children {
+				switch c.id {
+				case ebmlIDTimecodeScale:
+					if scale := ebmlUint(c.data); scale > 0 {
+						wi.timecodeScale = scale
+					}
+				case ebmlIDDuration:
+					wi.duration = ebmlFloat(c.data)
+				}
+			}
+			break
+		}
+		offset += headerSize + int(size)
+	}
+	return wi, nil
+}
+
+// cuePoint is a position of a cluster and its timecode
+type cuePoint struct {
+	time     uint64
+	track    uint64
+	position int // relative to data of the Segment
+}
+
+// parseCues reads cue points in data containing a Cues element
+func parseCues(data []byte) ([]cuePoint, error) {
+	elements, err := parseEBMLElements(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range elements {
+		if e.id != ebmlIDCues {
+			continue
+		}
+		cuePointElements, err := parseEBMLElements(e.data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Cues, %s", err)
+		}
+		points := []cuePoint{}
+		for _, cpe := range cuePointElements {
+			if cpe.id != ebmlIDCuePoint {
+				continue
+			}
+			children, err := parseEBMLElements(cpe.data)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CuePoint, %s", err)
+			}
+			var p cuePoint
+			hasPosition := false
+			for _, c := range children {
+				switch c.id {
+				case ebmlIDCueTime:
+					p.time = ebmlUint(c.data)
+				case ebmlIDCueTrackPositions:
+					if hasPosition {
+						continue // use the first track
+					}
+					positions, err := parseEBMLElements(c.data)
+					if err != nil {
+						return nil, fmt.Errorf("invalid CueTrackPositions, %s", err)
+					}
+					for _, pos := range positions {
+						switch pos.id {
+						case ebmlIDCueTrack:
+							p.track = ebmlUint(pos.data)
+						case ebmlIDCueClusterPosition:
+							p.position = int(ebmlUint(pos.data))
+							hasPosition = true
+						}
+					}
+				}
+			}
+			if hasPosition {
+				points = append(points, p)
+			}
+		}
+		return points, nil
+	}
+	return nil, errors.New("no Cues found in the index range")
+}
+
+// webmSegments converts cue points into media segments.
+// Every cluster referred by a cue point of the first track starts a segment.
+func webmSegments(init []byte, index []byte, totalSize int) ([]mediaSegment, error) {
+	info, err := parseWebMInit(init)
+	if err != nil {
+		return nil, err
+	}
+	points, err := parseCues(index)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []cuePoint{}
+	for _, p := range points {
+		if p.track != points[0].track {
+			continue
+		}
+		if len(filtered) > 0 && p.position <= filtered[len(filtered)-1].position {
+			continue // a cluster can be referred more than once
+		}
+		filtered = append(filtered, p)
+	}
+
+	toDuration := func(timecode float64) time.Duration {
+		return time.Duration(timecode * float64(info.timecodeScale))
+	}
+	segments := make([]mediaSegment, 0, len(filtered))
+	for i, p := range filtered {
+		seg := mediaSegment{
+			start: info.segmentStart + p.position,
+			end:   totalSize,
+			time:  toDuration(float64(p.time)),
+		}
+		if i+1 < len(filtered) {
+			seg.end = info.segmentStart + filtered[i+1].position
+			if next := filtered[i+1].time; next > p.time {
+				seg.duration = toDuration(float64(next - p.time))
+			}
+		} else if total := toDuration(info.duration); total > seg.time {
+			seg.duration = total - seg.time
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
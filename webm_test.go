@@ -0,0 +1,116 @@
+package gotube
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ebmlTestElement encodes an element with an 8 byte size
+func ebmlTestElement(id uint32, payloads ...[]byte) []byte {
+	data := []byte{}
+	for shift := uint(24); ; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(data) > 0 {
+			data = append(data, b)
+		}
+		if shift == 0 {
+			break
+		}
+	}
+	size := 0
+	for _, p := range payloads {
+		size += len(p)
+	}
+	sizeBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(sizeBytes, uint64(size))
+	sizeBytes[0] = 0x01
+	data = append(data, sizeBytes...)
+	for _, p := range payloads {
+		data = append(data, p...)
+	}
+	return data
+}
+
+func ebmlTestUint(id uint32, v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return ebmlTestElement(id, b)
+}
+
+func ebmlTestCuePoint(timecode uint64, track uint64, position uint64) []byte {
+	return ebmlTestElement(ebmlIDCuePoint,
+		ebmlTestUint(ebmlIDCueTime, timecode),
+		ebmlTestElement(ebmlIDCueTrackPositions, ebmlTestUint(ebmlIDCueTrack, track), ebmlTestUint(ebmlIDCueClusterPosition, position)),
+	)
+}
+
+// webmTestInit returns an init range containing the EBML header, the Segment header and Info,
+// and an offset of data of the Segment
+func webmTestInit(timecodeScale uint64, duration float64) ([]byte, int) {
+	durationBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(durationBytes, math.Float64bits(duration))
+	header := ebmlTestElement(ebmlIDHeader, []byte{0x42, 0x82, 0x84, 'w', 'e', 'b', 'm'})
+	info := ebmlTestElement(ebmlIDInfo, ebmlTestUint(ebmlIDTimecodeScale, timecodeScale), ebmlTestElement(ebmlIDDuration, durationBytes))
+	// Segment of unknown size
+	data := append(header, 0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	return append(data, info...), len(data)
+}
+
+func TestReadEBMLHeader(t *testing.T) {
+	cases := []struct {
+		data       []byte
+		id         uint32
+		size       int64
+		headerSize int
+	}{
+		{[]byte{0xA3, 0x85}, 0xA3, 5, 2},
+		{[]byte{0x1F, 0x43, 0xB6, 0x75, 0x40, 0x02}, 0x1F43B675, 2, 6},
+		{[]byte{0x18, 0x53, 0x80, 0x67, 0xFF}, 0x18538067, ebmlUnknownSize, 5},
+	}
+	for _, c := range cases {
+		id, size, headerSize, err := readEBMLHeader(c.data)
+		if err != nil || id != c.id || size != c.size || headerSize != c.headerSize {
+			t.Errorf("read %X, %d, %d, %v from %v, expected %X, %d, %d", id, size, headerSize, err, c.data, c.id, c.size, c.headerSize)
+		}
+	}
+
+	if _, _, _, err := readEBMLHeader([]byte{0x00, 0x81}); err == nil {
+		t.Error("invalid id should be an error")
+	}
+	if _, _, _, err := readEBMLHeader([]byte{0xA3, 0x40}); err == nil {
+		t.Error("truncated size should be an error")
+	}
+}
+
+func TestWebMSegments(t *testing.T) {
+	initData, segmentStart := webmTestInit(1000000, 12000)
+	index := ebmlTestElement(ebmlIDCues,
+		ebmlTestCuePoint(0, 1, 500),
+		ebmlTestCuePoint(5000, 1, 900),
+		ebmlTestCuePoint(5000, 2, 900), // another track
+		ebmlTestCuePoint(10000, 1, 1500),
+	)
+	totalSize := segmentStart + 2000
+
+	segments, err := webmSegments(initData, index, totalSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []mediaSegment{
+		{start: segmentStart + 500, end: segmentStart + 900, time: 0, duration: 5 * time.Second},
+		{start: segmentStart + 900, end: segmentStart + 1500, time: 5 * time.Second, duration: 5 * time.Second},
+		{start: segmentStart + 1500, end: totalSize, time: 10 * time.Second, duration: 2 * time.Second},
+	}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Errorf("got segments %v, expected %v", segments, expected)
+	}
+
+	if _, err := webmSegments(initData, ebmlTestElement(ebmlIDInfo), totalSize); err == nil {
+		t.Error("index without Cues should be an error")
+	}
+	if _, err := webmSegments(index, index, totalSize); err == nil {
+		t.Error("init without EBML header should be an error")
+	}
+}
@@ -0,0 +1,652 @@
+package gotube
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+const (
+	muxingApp = "gotube"
+	// maxEBMLDataSize is the largest element read at once, far larger than a Cluster of a few seconds
+	maxEBMLDataSize = 1 << 30
+)
+
+// MuxWebM downloads a video stream and an audio stream in WebM, such as DASH streams of itag 248 and 251,
+// and writes one WebM containing both tracks to w.
+// Blocks of the streams are interleaved in order of their timecodes into clusters starting at clusters of the video,
+// and Cues pointing the clusters are written at the end.
+// If w is an io.WriteSeeker such as *os.File, the Segment size and the SeekHead to the Cues are filled afterwards.
+func MuxWebM(w io.Writer, video, audio *Stream) error {
+	return MuxWebMContext(context.Background(), w, video, audio)
+}
+
+// MuxWebMContext is MuxWebM with a context.
+// Downloads of both streams are canceled when ctx is done or muxing fails.
+func MuxWebMContext(ctx context.Context, w io.Writer, video, audio *Stream) error {
+	if video.Format != "webm" || audio.Format != "webm" {
+		return fmt.Errorf("cannot mux %s video and %s audio into webm", video.Format, audio.Format)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	videoReader := video.pipe(ctx)
+	defer videoReader.Close()
+	audioReader := audio.pipe(ctx)
+	defer audioReader.Close()
+
+	if err := muxWebM(w, videoReader, audioReader); err != nil {
+		return err
+	}
+	logger.print("mux completed")
+	return nil
+}
+
+// muxWebM merges two WebM of a single track into one with two tracks.
+// Track numbers are renumbered to 1 for video and 2 for audio,
+// and timecodes are converted into the TimecodeScale of the video.
+func muxWebM(w io.Writer, video, audio io.Reader) error {
+	inputs := []*webmReader{{r: video}, {r: audio}}
+	for i, in := range inputs {
+		if err := in.readInit(); err != nil {
+			return fmt.Errorf("invalid %s, %s", [...]string{"video", "audio"}[i], err)
+		}
+	}
+	v, a := inputs[0], inputs[1]
+	return writeWebM(w, v.header, v.timecodeScale, mergeInfo(v, a), mergeTracks(v, a), func(m *webmMuxer) error {
+		return m.writeClusters(v, a)
+	})
+}
+
+// writeWebM writes a WebM of given Info and Tracks with clusters written by writeClusters, followed by Cues pointing the clusters.
+// If w is an io.WriteSeeker such as *os.File, the Segment size and the SeekHead to the Cues are filled afterwards.
+func writeWebM(w io.Writer, header []byte, timecodeScale uint64, info, tracks []byte, writeClusters func(*webmMuxer) error) error {
+	// remember where the output starts to fill sizes and positions at the end
+	ws, seekable := w.(io.WriteSeeker)
+	var origin int64
+	if seekable {
+		var err error
+		if origin, err = ws.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+
+	m := &webmMuxer{w: &countingWriter{w: w}, timecodeScale: timecodeScale}
+	if err := m.write(header); err != nil {
+		return err
+	}
+	sizePos := m.w.n + int64(len(ebmlIDBytes(ebmlIDSegment)))
+	if err := m.write(ebmlIDBytes(ebmlIDSegment), ebmlUnknownSizeBytes()); err != nil {
+		return err
+	}
+	m.segmentStart = m.w.n
+
+	// the SeekHead is written with a space for the Cues, which is filled if w is seekable
+	seekHeadPos := m.w.n
+	seekHeadLength := len(seekHead([]seekEntry{{ebmlIDInfo, 0}, {ebmlIDTracks, 0}, {ebmlIDCues, 0}}))
+	infoPos := seekHeadPos + int64(seekHeadLength) - m.segmentStart
+	tracksPos := infoPos + int64(len(info))
+	entries := []seekEntry{{ebmlIDInfo, infoPos}, {ebmlIDTracks, tracksPos}}
+	head := seekHead(entries)
+	if err := m.write(head, ebmlVoid(seekHeadLength-len(head)), info, tracks); err != nil {
+		return err
+	}
+
+	if err := writeClusters(m); err != nil {
+		return err
+	}
+
+	entries = append(entries, seekEntry{ebmlIDCues, m.w.n - m.segmentStart})
+	if err := m.write(m.cues()); err != nil {
+		return err
+	}
+	if !seekable {
+		return nil
+	}
+
+	end := m.w.n
+	patches := []struct {
+		pos  int64
+		data []byte
+	}{
+		{sizePos, ebmlFixedSizeBytes(int(end-m.segmentStart), 8)},
+		{seekHeadPos, seekHead(entries)},
+	}
+	for _, p := range patches {
+		if _, err := ws.Seek(origin+p.pos, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := ws.Write(p.data); err != nil {
+			return err
+		}
+	}
+	_, err := ws.Seek(origin+end, io.SeekStart)
+	return err
+}
+
+// webmMuxer writes clusters of two tracks and keeps their cue points
+type webmMuxer struct {
+	w             *countingWriter
+	timecodeScale uint64
+	segmentStart  int64
+	cuePoints     []cuePoint
+
+	// the cluster being built
+	open        bool
+	clusterTime int64 // in the timecode unit of the output
+	cue         bool  // whether the cluster is pointed by Cues
+	blocks      [][]byte
+}
+
+func (m *webmMuxer) write(data ...[]byte) error {
+	for _, d := range data {
+		if _, err := m.w.Write(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeClusters writes a cluster for each cluster of the video,
+// which contains audio blocks until the next cluster of the video.
+func (m *webmMuxer) writeClusters(v, a *webmReader) error {
+	vc, err := v.readCluster()
+	if err != nil {
+		return err
+	}
+	if vc == nil {
+		return errors.New("no Cluster found in the video")
+	}
+	for vc != nil {
+		next, err := v.readCluster()
+		if err != nil {
+			return err
+		}
+		end := int64(math.MaxInt64)
+		if next != nil {
+			end = next.time
+		}
+
+		if err := m.startCluster(vc.time/int64(m.timecodeScale), true); err != nil {
+			return err
+		}
+		for _, b := range vc.blocks {
+			if err := m.addAudio(a, b.time); err != nil {
+				return err
+			}
+			if err := m.add(b, 1, v.timecodeScale); err != nil {
+				return err
+			}
+		}
+		if err := m.addAudio(a, end); err != nil {
+			return err
+		}
+		vc = next
+	}
+	return m.flush()
+}
+
+// addAudio adds blocks of the audio earlier than a given time in nanoseconds
+func (m *webmMuxer) addAudio(a *webmReader, until int64) error {
+	for {
+		b, err := a.peekBlock()
+		if err != nil {
+			return err
+		}
+		if b == nil || b.time >= until {
+			return nil
+		}
+		if err := m.add(b, 2, a.timecodeScale); err != nil {
+			return err
+		}
+		a.pending = a.pending[1:]
+	}
+}
+
+// add adds a block to the cluster being built.
+// A new cluster is started if the relative timecode of the block does not fit in the block.
+func (m *webmMuxer) add(b *webmBlock, track uint64, inputScale uint64) error {
+	timecode := b.time / int64(m.timecodeScale)
+	relative := timecode - m.clusterTime
+	if !m.open || relative > math.MaxInt16 || relative < math.MinInt16 {
+		if err := m.startCluster(timecode, false); err != nil {
+			return err
+		}
+		relative = 0
+	}
+	data, err := b.encode(track, int16(relative), func(d uint64) uint64 {
+		return d * inputScale / m.timecodeScale
+	})
+	if err != nil {
+		return err
+	}
+	m.blocks = append(m.blocks, data)
+	return nil
+}
+
+// startCluster writes the cluster being built and starts a new one at a given timecode
+func (m *webmMuxer) startCluster(timecode int64, cue bool) error {
+	if err := m.flush(); err != nil {
+		return err
+	}
+	if timecode < 0 {
+		return fmt.Errorf("negative timecode %d", timecode)
+	}
+	m.open, m.clusterTime, m.cue, m.blocks = true, timecode, cue, nil
+	return nil
+}
+
+// flush writes the cluster being built
+func (m *webmMuxer) flush() error {
+	if !m.open || len(m.blocks) == 0 {
+		return nil
+	}
+	m.open = false
+	if m.cue {
+		m.cuePoints = append(m.cuePoints, cuePoint{
+			time:     uint64(m.clusterTime),
+			track:    1,
+			position: int(m.w.n - m.segmentStart),
+		})
+	}
+	payloads := append([][]byte{makeEBMLElement(ebmlIDTimecode, ebmlUintBytes(uint64(m.clusterTime)))}, m.blocks...)
+	return m.write(makeEBMLElement(ebmlIDCluster, payloads...))
+}
+
+// cues encodes cue points of written clusters
+func (m *webmMuxer) cues() []byte {
+	points := make([][]byte, 0, len(m.cuePoints))
+	for _, p := range m.cuePoints {
+		points = append(points, makeEBMLElement(ebmlIDCuePoint,
+			makeEBMLElement(ebmlIDCueTime, ebmlUintBytes(p.time)),
+			makeEBMLElement(ebmlIDCueTrackPositions,
+				makeEBMLElement(ebmlIDCueTrack, ebmlUintBytes(p.track)),
+				makeEBMLElement(ebmlIDCueClusterPosition, ebmlUintBytes(uint64(p.position))),
+			),
+		))
+	}
+	return makeEBMLElement(ebmlIDCues, points...)
+}
+
+// seekEntry is a position of a top level element relative to data of the Segment
+type seekEntry struct {
+	id       uint32
+	position int64
+}
+
+// seekHead encodes a SeekHead of given entries.
+// Positions are encoded in 8 bytes so that its length does not depend on them.
+func seekHead(entries []seekEntry) []byte {
+	seeks := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		position := make([]byte, 8)
+		binary.BigEndian.PutUint64(position, uint64(e.position))
+		seeks = append(seeks, makeEBMLElement(ebmlIDSeek,
+			makeEBMLElement(ebmlIDSeekID, ebmlIDBytes(e.id)),
+			makeEBMLElement(ebmlIDSeekPosition, position),
+		))
+	}
+	return makeEBMLElement(ebmlIDSeekHead, seeks...)
+}
+
+// ebmlUnknownSizeBytes returns a size of 8 bytes meaning an unknown size
+func ebmlUnknownSizeBytes() []byte {
+	return []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+}
+
+// mergeInfo encodes Info of the video with a duration of the longer track
+func mergeInfo(v, a *webmReader) []byte {
+	duration := v.duration
+	if d := a.duration * float64(a.timecodeScale) / float64(v.timecodeScale); d > duration {
+		duration = d
+	}
+	return webmInfo(v.info, v.timecodeScale, duration)
+}
+
+// webmInfo encodes Info of given children with a duration in the timecode unit, which is omitted if 0
+func webmInfo(info []*ebmlElement, timecodeScale uint64, duration float64) []byte {
+	payloads := [][]byte{makeEBMLElement(ebmlIDTimecodeScale, ebmlUintBytes(timecodeScale))}
+	for _, c := range info {
+		switch c.id {
+		case ebmlIDTimecodeScale, ebmlIDDuration, ebmlIDMuxingApp, ebmlIDWritingApp:
+		default:
+			payloads = append(payloads, makeEBMLElement(c.id, c.data))
+		}
+	}
+	if duration > 0 {
+		payloads = append(payloads, makeEBMLElement(ebmlIDDuration, ebmlFloatBytes(duration)))
+	}
+	payloads = append(payloads,
+		makeEBMLElement(ebmlIDMuxingApp, []byte(muxingApp)),
+		makeEBMLElement(ebmlIDWritingApp, []byte(muxingApp)),
+	)
+	return makeEBMLElement(ebmlIDInfo, payloads...)
+}
+
+// mergeTracks encodes Tracks containing the video track as 1 and the audio track as 2
+func mergeTracks(v, a *webmReader) []byte {
+	audioUID := a.trackUID
+	if audioUID == v.trackUID {
+		audioUID = v.trackUID + 1
+	}
+	return makeEBMLElement(ebmlIDTracks,
+		renumberTrack(v.track, 1, v.trackUID),
+		renumberTrack(a.track, 2, audioUID),
+	)
+}
+
+// renumberTrack encodes a TrackEntry with a track number and a track uid replaced
+func renumberTrack(entry []*ebmlElement, number, uid uint64) []byte {
+	payloads := [][]byte{
+		makeEBMLElement(ebmlIDTrackNumber, ebmlUintBytes(number)),
+		makeEBMLElement(ebmlIDTrackUID, ebmlUintBytes(uid)),
+	}
+	for _, c := range entry {
+		if c.id != ebmlIDTrackNumber && c.id != ebmlIDTrackUID {
+			payloads = append(payloads, makeEBMLElement(c.id, c.data))
+		}
+	}
+	return makeEBMLElement(ebmlIDTrackEntry, payloads...)
+}
+
+// webmReader reads a WebM of a single track
+type webmReader struct {
+	r             io.Reader
+	header        []byte         // the EBML header element
+	info          []*ebmlElement // children of Info
+	track         []*ebmlElement // children of the TrackEntry
+	trackNumber   uint64
+	trackUID      uint64
+	timecodeScale uint64 // nanoseconds per timecode unit
+	duration      float64
+
+	inCluster   bool  // whether the header of a Cluster has been read
+	clusterSize int64 // size of the Cluster
+	pending     []*webmBlock
+}
+
+// webmCluster is blocks of a cluster
+type webmCluster struct {
+	time   int64 // timecode in nanoseconds
+	blocks []*webmBlock
+}
+
+// webmBlock is a SimpleBlock or a BlockGroup of the track
+type webmBlock struct {
+	element *ebmlElement
+	time    int64 // timecode in nanoseconds
+}
+
+// readHeader reads an id and a data size of the next element.
+// It returns io.EOF if the stream ends before the element.
+func (wr *webmReader) readHeader() (uint32, int64, error) {
+	header := make([]byte, 0, 12)
+	for i := 0; i < 2; i++ {
+		first := make([]byte, 1)
+		if _, err := io.ReadFull(wr.r, first); err != nil {
+			if i > 0 {
+				return 0, 0, unexpectedEOF(err)
+			}
+			return 0, 0, err
+		}
+		length := vintLength(first[0])
+		if length == 0 {
+			return 0, 0, errors.New("invalid element header")
+		}
+		rest := make([]byte, length-1)
+		if _, err := io.ReadFull(wr.r, rest); err != nil {
+			return 0, 0, unexpectedEOF(err)
+		}
+		header = append(append(header, first...), rest...)
+	}
+	id, size, _, err := readEBMLHeader(header)
+	return id, size, err
+}
+
+// readData reads data of an element.
+// The buffer grows as the data arrives, so a broken size does not allocate memory at once.
+func (wr *webmReader) readData(size int64) ([]byte, error) {
+	if size < 0 || size > maxEBMLDataSize {
+		return nil, fmt.Errorf("invalid element size %d", size)
+	}
+	data := new(bytes.Buffer)
+	if _, err := io.CopyN(data, wr.r, size); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return data.Bytes(), nil
+}
+
+// readInit reads elements before the first Cluster
+func (wr *webmReader) readInit() error {
+	id, size, err := wr.readHeader()
+	if err != nil || id != ebmlIDHeader || size == ebmlUnknownSize {
+		return errors.New("no EBML header found")
+	}
+	data, err := wr.readData(size)
+	if err != nil {
+		return err
+	}
+	wr.header = makeEBMLElement(ebmlIDHeader, data)
+
+	// children of the Segment are read until its end regardless of its size
+	if id, _, err = wr.readHeader(); err != nil || id != ebmlIDSegment {
+		return errors.New("no Segment found")
+	}
+	wr.timecodeScale = defaultTimecodeScale
+	var tracks []*ebmlElement
+	for {
+		id, size, err := wr.readHeader()
+		if err != nil {
+			return fmt.Errorf("no Cluster found, %s", err)
+		}
+		if id == ebmlIDCluster {
+			wr.inCluster, wr.clusterSize = true, size
+			break
+		}
+		if size == ebmlUnknownSize {
+			return fmt.Errorf("element %X of an unknown size", id)
+		}
+		data, err := wr.readData(size)
+		if err != nil {
+			return err
+		}
+		switch id {
+		case ebmlIDInfo:
+			if wr.info, err = parseEBMLElements(data); err != nil {
+				return fmt.Errorf("invalid Info, %s", err)
+			}
+		case ebmlIDTracks:
+			if tracks, err = parseEBMLElements(data); err != nil {
+				return fmt.Errorf("invalid Tracks, %s", err)
+			}
+		}
+	}
+
+	for _, c := range wr.info {
+		switch c.id {
+		case ebmlIDTimecodeScale:
+			if scale := ebmlUint(c.data); scale > 0 {
+				wr.timecodeScale = scale
+			}
+		case ebmlIDDuration:
+			wr.duration = ebmlFloat(c.data)
+		}
+	}
+
+	entries := 0
+	for _, t := range tracks {
+		if t.id != ebmlIDTrackEntry {
+			continue
+		}
+		entries++
+		if wr.track, err = parseEBMLElements(t.data); err != nil {
+			return fmt.Errorf("invalid TrackEntry, %s", err)
+		}
+	}
+	if entries != 1 {
+		return fmt.Errorf("%d tracks found, expected 1", entries)
+	}
+	for _, c := range wr.track {
+		switch c.id {
+		case ebmlIDTrackNumber:
+			wr.trackNumber = ebmlUint(c.data)
+		case ebmlIDTrackUID:
+			wr.trackUID = ebmlUint(c.data)
+		}
+	}
+	return nil
+}
+
+// readCluster reads blocks of the next Cluster, or returns nil at the end of the stream
+func (wr *webmReader) readCluster() (*webmCluster, error) {
+	for !wr.inCluster {
+		id, size, err := wr.readHeader()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if id == ebmlIDCluster {
+			wr.inCluster, wr.clusterSize = true, size
+			break
+		}
+		if size == ebmlUnknownSize {
+			return nil, fmt.Errorf("element %X of an unknown size", id)
+		}
+		if _, err := io.CopyN(ioutil.Discard, wr.r, size); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	wr.inCluster = false
+	if wr.clusterSize == ebmlUnknownSize {
+		return nil, errors.New("clusters of an unknown size are not supported")
+	}
+
+	data, err := wr.readData(wr.clusterSize)
+	if err != nil {
+		return nil, err
+	}
+	children, err := parseEBMLElements(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Cluster, %s", err)
+	}
+	var timecode int64
+	for _, c := range children {
+		if c.id == ebmlIDTimecode {
+			timecode = int64(ebmlUint(c.data))
+		}
+	}
+	scale := int64(wr.timecodeScale)
+	cluster := &webmCluster{time: timecode * scale}
+	for _, c := range children {
+		if c.id != ebmlIDSimpleBlock && c.id != ebmlIDBlockGroup {
+			continue
+		}
+		block, err := blockOf(c)
+		if err != nil {
+			return nil, err
+		}
+		track, relative, _, err := parseBlockHeader(block)
+		if err != nil {
+			return nil, err
+		}
+		if track != wr.trackNumber {
+			continue
+		}
+		cluster.blocks = append(cluster.blocks, &webmBlock{element: c, time: (timecode + int64(relative)) * scale})
+	}
+	return cluster, nil
+}
+
+// peekBlock returns the next block of the stream without consuming it, or nil at the end of the stream
+func (wr *webmReader) peekBlock() (*webmBlock, error) {
+	for len(wr.pending) == 0 {
+		c, err := wr.readCluster()
+		if err != nil || c == nil {
+			return nil, err
+		}
+		wr.pending = c.blocks
+	}
+	return wr.pending[0], nil
+}
+
+// blockOf returns data of a SimpleBlock or of the Block in a BlockGroup
+func blockOf(e *ebmlElement) ([]byte, error) {
+	if e.id == ebmlIDSimpleBlock {
+		return e.data, nil
+	}
+	children, err := parseEBMLElements(e.data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BlockGroup, %s", err)
+	}
+	for _, c := range children {
+		if c.id == ebmlIDBlock {
+			return c.data, nil
+		}
+	}
+	return nil, errors.New("no Block found in a BlockGroup")
+}
+
+// parseBlockHeader reads a track number and a relative timecode of a block.
+// headerSize is an offset of flags following them.
+func parseBlockHeader(data []byte) (track uint64, relative int16, headerSize int, err error) {
+	if len(data) == 0 {
+		return 0, 0, 0, errors.New("empty block")
+	}
+	length := vintLength(data[0])
+	if length == 0 || len(data) < length+3 {
+		return 0, 0, 0, errors.New("invalid block header")
+	}
+	track = uint64(data[0]) & (0xFF >> uint(length))
+	for _, b := range data[1:length] {
+		track = track<<8 | uint64(b)
+	}
+	relative = int16(uint16(data[length])<<8 | uint16(data[length+1]))
+	return track, relative, length + 2, nil
+}
+
+// encode encodes the block with a track number and a relative timecode replaced.
+// BlockDuration of a BlockGroup is converted by rescale.
+func (b *webmBlock) encode(track uint64, relative int16, rescale func(uint64) uint64) ([]byte, error) {
+	rewrite := func(block []byte) ([]byte, error) {
+		_, _, headerSize, err := parseBlockHeader(block)
+		if err != nil {
+			return nil, err
+		}
+		header := append(ebmlSizeBytes(int(track)), byte(uint16(relative)>>8), byte(relative))
+		return append(header, block[headerSize:]...), nil
+	}
+
+	if b.element.id == ebmlIDSimpleBlock {
+		block, err := rewrite(b.element.data)
+		if err != nil {
+			return nil, err
+		}
+		return makeEBMLElement(ebmlIDSimpleBlock, block), nil
+	}
+
+	children, err := parseEBMLElements(b.element.data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BlockGroup, %s", err)
+	}
+	payloads := make([][]byte, 0, len(children))
+	for _, c := range children {
+		data := c.data
+		switch c.id {
+		case ebmlIDBlock:
+			if data, err = rewrite(data); err != nil {
+				return nil, err
+			}
+		case ebmlIDBlockDuration:
+			data = ebmlUintBytes(rescale(ebmlUint(data)))
+		}
+		payloads = append(payloads, makeEBMLElement(c.id, data))
+	}
+	return makeEBMLElement(ebmlIDBlockGroup, payloads...), nil
+}
@@ -0,0 +1,285 @@
+package gotube
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// testCluster is a cluster of a synthetic WebM with frames at relative timecodes
+type testCluster struct {
+	timecode uint64
+	relative []int16
+	frames   []string
+}
+
+// testWebM builds a WebM of a single track with a track number 1.
+// An Opus track has OpusHead in CodecPrivate.
+// Blocks are SimpleBlock for a video and BlockGroup with BlockDuration for an audio.
+func testWebM(timecodeScale uint64, duration float64, codec string, audio bool, clusters []testCluster) []byte {
+	header := makeEBMLElement(ebmlIDHeader, makeEBMLElement(0x4282, []byte("webm")))
+	info := makeEBMLElement(ebmlIDInfo,
+		makeEBMLElement(ebmlIDTimecodeScale, ebmlUintBytes(timecodeScale)),
+		makeEBMLElement(ebmlIDDuration, ebmlFloatBytes(duration)),
+		makeEBMLElement(ebmlIDMuxingApp, []byte("google")),
+	)
+	entry := [][]byte{
+		makeEBMLElement(ebmlIDTrackNumber, ebmlUintBytes(1)),
+		makeEBMLElement(ebmlIDTrackUID, ebmlUintBytes(1)),
+		makeEBMLElement(ebmlIDCodecID, []byte(codec)),
+	}
+	if codec == "A_OPUS" {
+		// stereo, pre-skip of 312 samples, 48 kHz
+		entry = append(entry, makeEBMLElement(ebmlIDCodecPrivate, []byte("OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00")))
+	}
+	tracks := makeEBMLElement(ebmlIDTracks, makeEBMLElement(ebmlIDTrackEntry, entry...))
+	segment := [][]byte{makeEBMLElement(ebmlIDSeekHead), info, tracks, makeEBMLElement(ebmlIDCues)}
+	for _, c := range clusters {
+		payloads := [][]byte{makeEBMLElement(ebmlIDTimecode, ebmlUintBytes(c.timecode))}
+		for i, frame := range c.frames {
+			block := append([]byte{0x81, byte(uint16(c.relative[i]) >> 8), byte(c.relative[i]), 0x80}, frame...)
+			if audio {
+				payloads = append(payloads, makeEBMLElement(ebmlIDBlockGroup,
+					makeEBMLElement(ebmlIDBlock, block),
+					makeEBMLElement(ebmlIDBlockDuration, ebmlUintBytes(40)),
+				))
+			} else {
+				payloads = append(payloads, makeEBMLElement(ebmlIDSimpleBlock, block))
+			}
+		}
+		segment = append(segment, makeEBMLElement(ebmlIDCluster, payloads...))
+	}
+	return append(header, makeEBMLElement(ebmlIDSegment, segment...)...)
+}
+
+// testWebMBlock is a block found in a muxed WebM
+type testWebMBlock struct {
+	track    uint64
+	timecode int64
+	frame    string
+}
+
+// webmBlocksOf returns children of the Segment and blocks in clusters of a WebM
+func webmBlocksOf(t *testing.T, data []byte) ([]*ebmlElement, []testWebMBlock) {
+	elements, err := parseEBMLElements(data)
+	if err != nil || len(elements) != 2 || elements[1].id != ebmlIDSegment {
+		t.Fatalf("invalid WebM, %v", err)
+	}
+	children, err := parseEBMLElements(elements[1].data)
+	if err != nil {
+		t.Fatalf("invalid Segment, %s", err)
+	}
+	var blocks []testWebMBlock
+	for _, c := range children {
+		if c.id != ebmlIDCluster {
+			continue
+		}
+		clusterChildren, _ := parseEBMLElements(c.data)
+		var timecode int64
+		for _, cc := range clusterChildren {
+			switch cc.id {
+			case ebmlIDTimecode:
+				timecode = int64(ebmlUint(cc.data))
+			case ebmlIDSimpleBlock, ebmlIDBlockGroup:
+				block, err := blockOf(cc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				track, relative, headerSize, err := parseBlockHeader(block)
+				if err != nil {
+					t.Fatal(err)
+				}
+				blocks = append(blocks, testWebMBlock{track, timecode + int64(relative), string(block[headerSize+1:])})
+			}
+		}
+	}
+	return children, blocks
+}
+
+func TestMuxWebM(t *testing.T) {
+	video := testWebM(1000000, 160, "V_VP9", false, []testCluster{
+		{0, []int16{0, 40}, []string{"v0", "v1"}},
+		{80, []int16{0, 40}, []string{"v2", "v3"}},
+	})
+	// the audio has a timecode unit of 0.5 ms
+	audio := testWebM(500000, 70040, "A_OPUS", true, []testCluster{
+		{0, []int16{0, 100, 200}, []string{"a0", "a1", "a2"}},
+		{300, []int16{0}, []string{"a3"}},
+		{70000, []int16{0}, []string{"a4"}},
+	})
+
+	buf := new(bytes.Buffer)
+	if err := muxWebM(buf, bytes.NewReader(video), bytes.NewReader(audio)); err != nil {
+		t.Fatalf("mux failed, %s", err)
+	}
+	out := buf.Bytes()
+	children, blocks := webmBlocksOf(t, out)
+
+	expected := []testWebMBlock{
+		{1, 0, "v0"}, {2, 0, "a0"}, {1, 40, "v1"}, {2, 50, "a1"},
+		{1, 80, "v2"}, {2, 100, "a2"}, {1, 120, "v3"}, {2, 150, "a3"},
+		{2, 35000, "a4"}, // in a cluster of its own, which is too far for a relative timecode
+	}
+	if !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("got blocks %v, expected %v", blocks, expected)
+	}
+
+	var trackNumbers, trackUIDs []uint64
+	for _, c := range children {
+		switch c.id {
+		case ebmlIDTracks:
+			entries, _ := parseEBMLElements(c.data)
+			for _, e := range entries {
+				fields, _ := parseEBMLElements(e.data)
+				for _, f := range fields {
+					switch f.id {
+					case ebmlIDTrackNumber:
+						trackNumbers = append(trackNumbers, ebmlUint(f.data))
+					case ebmlIDTrackUID:
+						trackUIDs = append(trackUIDs, ebmlUint(f.data))
+					}
+				}
+			}
+		case ebmlIDCluster:
+			clusterChildren, _ := parseEBMLElements(c.data)
+			for _, cc := range clusterChildren {
+				if cc.id != ebmlIDBlockGroup {
+					continue
+				}
+				group, _ := parseEBMLElements(cc.data)
+				for _, g := range group {
+					if g.id == ebmlIDBlockDuration && ebmlUint(g.data) != 20 {
+						t.Errorf("got BlockDuration %d, expected 20", ebmlUint(g.data))
+					}
+				}
+			}
+		}
+	}
+	if !reflect.DeepEqual(trackNumbers, []uint64{1, 2}) || trackUIDs[0] == trackUIDs[1] {
+		t.Errorf("got track numbers %v and uids %v, expected 1, 2 and distinct uids", trackNumbers, trackUIDs)
+	}
+
+	wi, err := parseWebMInit(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wi.timecodeScale != 1000000 || wi.duration != 35020 {
+		t.Errorf("got duration %f in %d, expected 35020 in 1000000", wi.duration, wi.timecodeScale)
+	}
+
+	// cue points refer clusters starting at the video clusters
+	points, err := parseCues(out[wi.segmentStart:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].time != 0 || points[1].time != 80 {
+		t.Fatalf("got cue points %v, expected ones at 0 and 80", points)
+	}
+	for _, p := range points {
+		id, _, _, err := readEBMLHeader(out[wi.segmentStart+p.position:])
+		if err != nil || id != ebmlIDCluster {
+			t.Errorf("cue point %v does not refer a cluster", p)
+		}
+	}
+
+	if err := muxWebM(new(bytes.Buffer), bytes.NewReader(video[:20]), bytes.NewReader(audio)); err == nil {
+		t.Error("truncated video should be rejected")
+	}
+}
+
+func TestMuxWebMToFile(t *testing.T) {
+	video := testWebM(1000000, 80, "V_VP9", false, []testCluster{{0, []int16{0, 40}, []string{"v0", "v1"}}})
+	audio := testWebM(1000000, 80, "A_OPUS", true, []testCluster{{0, []int16{0, 40}, []string{"a0", "a1"}}})
+
+	f, err := ioutil.TempFile("", "gotube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := muxWebM(f, bytes.NewReader(video), bytes.NewReader(audio)); err != nil {
+		t.Fatalf("mux failed, %s", err)
+	}
+	f.Close()
+	out, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wi, err := parseWebMInit(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, size, _, _ := readEBMLHeader(out[wi.segmentStart-12:])
+	if size != int64(len(out)-wi.segmentStart) {
+		t.Errorf("got Segment size %d, expected %d", size, len(out)-wi.segmentStart)
+	}
+
+	// the SeekHead points the Cues
+	children, _ := webmBlocksOf(t, out)
+	seeks, _ := parseEBMLElements(children[0].data)
+	found := false
+	for _, s := range seeks {
+		fields, _ := parseEBMLElements(s.data)
+		if len(fields) == 2 && bytes.Equal(fields[0].data, ebmlIDBytes(ebmlIDCues)) {
+			id, _, _, _ := readEBMLHeader(out[wi.segmentStart+int(ebmlUint(fields[1].data)):])
+			found = id == ebmlIDCues
+		}
+	}
+	if !found {
+		t.Error("no SeekHead entry pointing the Cues")
+	}
+}
+
+func TestMuxWebMStreams(t *testing.T) {
+	video := testWebM(1000000, 80, "V_VP9", false, []testCluster{{0, []int16{0, 40}, []string{"v0", "v1"}}})
+	audio := testWebM(1000000, 80, "A_OPUS", true, []testCluster{{0, []int16{0, 40}, []string{"a0", "a1"}}})
+	var mu sync.Mutex
+	var requested []int
+	videoStream := &Stream{
+		url:    "https://foobar?itag=248&signature=geho",
+		Format: "webm",
+		Retry:  &NoRetry,
+		client: rangeServingClient(video, nil, &requested, &mu),
+	}
+	audioStream := &Stream{
+		url:    "https://foobar?itag=251&signature=geho",
+		Format: "webm",
+		Retry:  &NoRetry,
+		client: rangeServingClient(audio, nil, &requested, &mu),
+	}
+
+	expected := new(bytes.Buffer)
+	if err := muxWebM(expected, bytes.NewReader(video), bytes.NewReader(audio)); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := MuxWebM(buf, videoStream, audioStream); err != nil {
+		t.Fatalf("mux failed, %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected.Bytes()) {
+		t.Error("muxed streams differ from muxed files")
+	}
+
+	audioStream.Format = "mp4"
+	if err := MuxWebM(new(bytes.Buffer), videoStream, audioStream); err == nil {
+		t.Error("mp4 stream should be rejected")
+	}
+}
+
+func TestWebMReaderBrokenSize(t *testing.T) {
+	// an EBML header of 2^48 bytes is rejected before reading it
+	header := append(ebmlIDBytes(ebmlIDHeader), 0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	wr := &webmReader{r: bytes.NewReader(append(header, 0x42, 0x86))}
+	if err := wr.readInit(); err == nil {
+		t.Error("broken size is accepted")
+	}
+	// data shorter than the size is an error
+	wr = &webmReader{r: bytes.NewReader([]byte{1, 2, 3})}
+	if _, err := wr.readData(100); err == nil {
+		t.Error("truncated data is accepted")
+	}
+}
@@ -0,0 +1,242 @@
+package gotube
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const (
+	// tagTargetMovie is TargetTypeValue of tags for a whole movie
+	tagTargetMovie = 50
+)
+
+// ebmlHeaderAt reads an id and a data size of an element at a given position
+func ebmlHeaderAt(r io.ReaderAt, pos int64) (uint32, int64, int, error) {
+	buf := make([]byte, 12)
+	n, _ := r.ReadAt(buf, pos)
+	return readEBMLHeader(buf[:n])
+}
+
+// tagMatroska writes Tags and Attachments of a cover art at the end of the Segment of a Matroska file.
+// Old Tags and Attachments are removed, and the SeekHead is updated to point the new ones.
+// If the first SeekHead has no space for the entries, they are moved to a new SeekHead at the end.
+func tagMatroska(f *os.File, m *Metadata) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+
+	_, size, headerSize, err := ebmlHeaderAt(f, 0)
+	if err != nil || size == ebmlUnknownSize {
+		return errors.New("no EBML header found")
+	}
+	segmentPos := int64(headerSize) + size
+	id, segmentSize, headerSize, err := ebmlHeaderAt(f, segmentPos)
+	if err != nil || id != ebmlIDSegment {
+		return errors.New("no Segment found")
+	}
+	segmentStart := segmentPos + int64(headerSize)
+	sizeLength := headerSize - len(ebmlIDBytes(ebmlIDSegment))
+	segmentEnd := fileSize
+	if segmentSize != ebmlUnknownSize {
+		segmentEnd = segmentStart + segmentSize
+	}
+	if segmentEnd != fileSize {
+		return errors.New("data after the Segment is not supported")
+	}
+
+	// find SeekHeads and elements to be replaced among children of the Segment
+	type element struct {
+		id          uint32
+		pos, length int64
+	}
+	var elements []element
+	for pos := segmentStart; pos < segmentEnd; {
+		id, size, headerSize, err := ebmlHeaderAt(f, pos)
+		if err != nil {
+			return fmt.Errorf("invalid element at %d, %s", pos, err)
+		}
+		if size == ebmlUnknownSize {
+			return fmt.Errorf("element %X of an unknown size is not supported", id)
+		}
+		elements = append(elements, element{id, pos, int64(headerSize) + size})
+		pos += int64(headerSize) + size
+	}
+	if len(elements) == 0 || elements[0].id != ebmlIDSeekHead {
+		return errors.New("no SeekHead found at the beginning of the Segment")
+	}
+
+	var entries []seekEntry
+	removed := make(map[int64]bool) // positions of elements to be removed
+	for i, e := range elements {
+		switch e.id {
+		case ebmlIDSeekHead:
+			data := make([]byte, e.length)
+			if _, err := f.ReadAt(data, e.pos); err != nil {
+				return err
+			}
+			seeks, err := parseSeekHead(data)
+			if err != nil {
+				return err
+			}
+			for _, s := range seeks {
+				switch s.id {
+				case ebmlIDSeekHead, ebmlIDTags, ebmlIDAttachments:
+				default:
+					entries = append(entries, s)
+				}
+			}
+			// a SeekHead other than the first is written by the last tagging
+			removed[e.pos] = i > 0
+		case ebmlIDTags, ebmlIDAttachments:
+			removed[e.pos] = true
+		}
+	}
+
+	// removed elements and Voids at the end are truncated, and the other removed ones become Void
+	appendAt := segmentEnd
+	for last := len(elements) - 1; last > 1; last-- {
+		e := elements[last]
+		if !removed[e.pos] && e.id != ebmlIDVoid {
+			break
+		}
+		appendAt = e.pos
+	}
+	for _, e := range elements {
+		if removed[e.pos] && e.pos < appendAt {
+			if _, err := f.WriteAt(ebmlVoid(int(e.length)), e.pos); err != nil {
+				return err
+			}
+		}
+	}
+
+	tail := matroskaTags(m)
+	entries = append(entries, seekEntry{ebmlIDTags, appendAt - segmentStart})
+	if attachments := matroskaAttachments(m.Thumbnail); attachments != nil {
+		entries = append(entries, seekEntry{ebmlIDAttachments, appendAt - segmentStart + int64(len(tail))})
+		tail = append(tail, attachments...)
+	}
+
+	// the first SeekHead can take a following Void
+	space := elements[0].length
+	if len(elements) > 1 && elements[1].id == ebmlIDVoid {
+		space += elements[1].length
+	}
+	head := seekHead(entries)
+	if !fitsIn(len(head), space) {
+		second := appendAt - segmentStart + int64(len(tail))
+		tail = append(tail, head...)
+		head = seekHead([]seekEntry{{ebmlIDSeekHead, second}})
+		if !fitsIn(len(head), space) {
+			return errors.New("no space for the SeekHead")
+		}
+	}
+	if rest := int(space) - len(head); rest > 0 {
+		head = append(head, ebmlVoid(rest)...)
+	}
+	// the file is left untouched if the new size of the Segment cannot be written
+	end := appendAt + int64(len(tail))
+	newSize := end - segmentStart
+	if segmentSize != ebmlUnknownSize && sizeLength < 8 && newSize >= 1<<uint(7*sizeLength)-1 {
+		return errors.New("size of the Segment does not fit in its field")
+	}
+
+	if _, err := f.WriteAt(head, elements[0].pos); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(tail, appendAt); err != nil {
+		return err
+	}
+	if err := f.Truncate(end); err != nil {
+		return err
+	}
+	if segmentSize != ebmlUnknownSize {
+		if _, err := f.WriteAt(ebmlFixedSizeBytes(int(newSize), sizeLength), segmentPos+int64(len(ebmlIDBytes(ebmlIDSegment)))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fitsIn tells whether an element of a length can be written in a space, where the rest is filled by a Void
+func fitsIn(length int, space int64) bool {
+	rest := space - int64(length)
+	return rest == 0 || rest >= 2
+}
+
+// parseSeekHead reads entries of a SeekHead element
+func parseSeekHead(data []byte) ([]seekEntry, error) {
+	elements, err := parseEBMLElements(data)
+	if err != nil || len(elements) != 1 {
+		return nil, errors.New("invalid SeekHead")
+	}
+	seeks, err := parseEBMLElements(elements[0].data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SeekHead, %s", err)
+	}
+	var entries []seekEntry
+	for _, s := range seeks {
+		if s.id != ebmlIDSeek {
+			continue
+		}
+		children, err := parseEBMLElements(s.data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Seek, %s", err)
+		}
+		var e seekEntry
+		for _, c := range children {
+			switch c.id {
+			case ebmlIDSeekID:
+				e.id = uint32(ebmlUint(c.data))
+			case ebmlIDSeekPosition:
+				e.position = int64(ebmlUint(c.data))
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// matroskaTags encodes Tags of metadata for the whole movie
+func matroskaTags(m *Metadata) []byte {
+	payloads := [][]byte{makeEBMLElement(ebmlIDTargets, makeEBMLElement(ebmlIDTargetTypeValue, ebmlUintBytes(tagTargetMovie)))}
+	for _, t := range []struct {
+		name  string
+		value string
+	}{
+		{"TITLE", m.Title},
+		{"ARTIST", m.Author},
+		{"DATE_RELEASED", m.UploadDate},
+		{"DESCRIPTION", m.Description},
+		{"URL", m.URL},
+	} {
+		if t.value != "" {
+			payloads = append(payloads, makeEBMLElement(ebmlIDSimpleTag,
+				makeEBMLElement(ebmlIDTagName, []byte(t.name)),
+				makeEBMLElement(ebmlIDTagString, []byte(t.value)),
+			))
+		}
+	}
+	return makeEBMLElement(ebmlIDTags, makeEBMLElement(ebmlIDTag, payloads...))
+}
+
+// matroskaAttachments encodes Attachments of a cover art, or returns nil if there is no image
+func matroskaAttachments(image []byte) []byte {
+	if image == nil {
+		return nil
+	}
+	name, mimeType := "cover.jpg", "image/jpeg"
+	if isPNG(image) {
+		name, mimeType = "cover.png", "image/png"
+	}
+	return makeEBMLElement(ebmlIDAttachments, makeEBMLElement(ebmlIDAttachedFile,
+		makeEBMLElement(ebmlIDFileName, []byte(name)),
+		makeEBMLElement(ebmlIDFileMimeType, []byte(mimeType)),
+		makeEBMLElement(ebmlIDFileData, image),
+		makeEBMLElement(ebmlIDFileUID, ebmlUintBytes(uint64(crc32.ChecksumIEEE(image))+1)),
+	))
+}